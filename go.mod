@@ -1,3 +1,8 @@
 module github.com/hoangan/superwallet
 
 go 1.21.0
+
+require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/lib/pq v1.12.3
+)
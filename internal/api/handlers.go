@@ -0,0 +1,266 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/storage"
+)
+
+// SubscribeRequest is the body of POST /v1/subscriptions.
+type SubscribeRequest struct {
+	Address string `json:"address"`
+
+	// FromBlock backfills the transactions of the blocks already indexed from the block, a decimal number,
+	// or from the first activity of the address with "first". Only the new transactions are recorded when empty.
+	FromBlock string `json:"fromBlock,omitempty"`
+}
+
+// SubscribeResponse is the body of the response of POST /v1/subscriptions.
+type SubscribeResponse struct {
+	Subscription *m.Subscription `json:"subscription"`
+
+	// BackfillJob is the job backfilling the transactions of the address, when a start block is given.
+	BackfillJob *m.BackfillJob `json:"backfillJob,omitempty"`
+}
+
+// BlockResponse is the body of the response of GET /v1/block.
+type BlockResponse struct {
+	BlockNumber *big.Int `json:"blockNumber"`
+}
+
+// BalancesResponse is the body of the response of GET /v1/addresses/{address}/balances.
+type BalancesResponse struct {
+	Address  string       `json:"address"`
+	Balances []*m.Balance `json:"balances"`
+}
+
+func (s *Server) getCurrentBlock(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &BlockResponse{BlockNumber: s.indexer.GetCurrentBlock()})
+}
+
+// subscribe subscribes the address, 201 when newly subscribed or subscribed again, 200 when already active.
+func (s *Server) subscribe(w http.ResponseWriter, r *http.Request) {
+	var request SubscribeRequest
+	if err := decodeBody(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	address, err := parseAddress(request.Address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	status := http.StatusCreated
+	if subscription, err := s.indexer.GetSubscription(address); err == nil && subscription.State == m.SubscriptionStateActive {
+		status = http.StatusOK
+	}
+
+	response := &SubscribeResponse{}
+	switch request.FromBlock {
+	case "":
+		err = s.indexer.SubscribeAddress(address)
+	case "first":
+		response.BackfillJob, err = s.indexer.SubscribeAddressFrom(address, nil)
+	default:
+		fromBlockNumber, ok := new(big.Int).SetString(request.FromBlock, 10)
+		if !ok || fromBlockNumber.Sign() < 0 {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("invalid fromBlock %q, expected a block number or first", request.FromBlock))
+			return
+		}
+		response.BackfillJob, err = s.indexer.SubscribeAddressFrom(address, fromBlockNumber)
+	}
+	if err != nil {
+		writeIndexerError(w, r, err)
+		return
+	}
+
+	subscription, err := s.indexer.GetSubscription(address)
+	if err != nil {
+		writeIndexerError(w, r, err)
+		return
+	}
+	response.Subscription = withoutSecret(subscription)
+
+	writeJSON(w, status, response)
+}
+
+func (s *Server) getSubscription(w http.ResponseWriter, r *http.Request, address string) {
+	address, err := parseAddress(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	subscription, err := s.indexer.GetSubscription(address)
+	if err != nil {
+		writeIndexerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, withoutSecret(subscription))
+}
+
+// unsubscribe unsubscribes the address, its history is purged with ?purge=true, kept otherwise.
+func (s *Server) unsubscribe(w http.ResponseWriter, r *http.Request, address string) {
+	address, err := parseAddress(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	purge := false
+	if value := r.URL.Query().Get("purge"); value != "" {
+		if purge, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid purge %q, expected true or false", value))
+			return
+		}
+	}
+
+	if err := s.indexer.UnsubscribeAddress(address, purge); err != nil {
+		writeIndexerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) queryTransactions(w http.ResponseWriter, r *http.Request, address string) {
+	address, err := parseAddress(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	query, err := parseTransactionQuery(address, r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	page, err := s.indexer.QueryTransactions(query)
+	if err != nil {
+		writeIndexerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) getBalances(w http.ResponseWriter, r *http.Request, address string) {
+	address, err := parseAddress(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	balances, err := s.indexer.GetBalances(address)
+	if err != nil {
+		writeIndexerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &BalancesResponse{Address: address, Balances: balances})
+}
+
+func (s *Server) getTransaction(w http.ResponseWriter, r *http.Request, hash string) {
+	hash, err := parseHash(hash)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	tx, err := s.indexer.GetTransaction(hash)
+	if err != nil {
+		writeIndexerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tx)
+}
+
+// writeIndexerError answers 404 for the addresses and transactions not found, 500 for the other errors.
+func writeIndexerError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, storage.ErrSubscriptionNotFound), errors.Is(err, storage.ErrTransactionNotFound):
+		writeError(w, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, m.ErrInvalidCursor), errors.Is(err, m.ErrPrivateWebhook):
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	default:
+		writeInternalError(w, r, err)
+	}
+}
+
+// parseTransactionQuery parses the parameters of the query of the transactions of the address:
+// fromBlock and toBlock, fromTime and toTime (RFC 3339), direction (in or out), coin and state (repeated or
+// comma separated), minValue, order (asc or desc), limit and cursor, the nextCursor of the previous page.
+func parseTransactionQuery(address string, values url.Values) (*m.TransactionQuery, error) {
+	query := &m.TransactionQuery{Address: address}
+	for name, params := range values {
+		value := params[len(params)-1]
+		list := strings.Split(strings.Join(params, ","), ",")
+
+		var err error
+		switch name {
+		case "fromBlock":
+			query.FromBlock, err = parseBlockNumber(value)
+		case "toBlock":
+			query.ToBlock, err = parseBlockNumber(value)
+		case "fromTime":
+			query.FromTime, err = time.Parse(time.RFC3339, value)
+		case "toTime":
+			query.ToTime, err = time.Parse(time.RFC3339, value)
+		case "direction":
+			query.Direction = m.Direction(value)
+		case "coin":
+			for _, id := range list {
+				coinID, parseErr := strconv.ParseInt(id, 10, 64)
+				if parseErr != nil {
+					return nil, fmt.Errorf("invalid coin id %q", id)
+				}
+				query.CoinIDs = append(query.CoinIDs, coinID)
+			}
+		case "minValue":
+			var ok bool
+			if query.MinValue, ok = new(big.Int).SetString(value, 10); !ok || query.MinValue.Sign() < 0 {
+				return nil, fmt.Errorf("invalid minValue %q", value)
+			}
+		case "state":
+			for _, state := range list {
+				query.States = append(query.States, m.TransactionState(state))
+			}
+		case "order":
+			query.Order = m.SortOrder(value)
+		case "limit":
+			query.Limit, err = strconv.Atoi(value)
+		case "cursor":
+			query.Cursor = value
+		default:
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+
+	return query, query.Validate()
+}
+
+func parseBlockNumber(value string) (*big.Int, error) {
+	number, ok := new(big.Int).SetString(value, 10)
+	if !ok || number.Sign() < 0 {
+		return nil, fmt.Errorf("invalid block number %s", value)
+	}
+
+	return number, nil
+}
@@ -0,0 +1,220 @@
+// Package api serves the indexer over HTTP, with JSON requests and responses.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hoangan/superwallet/internal"
+)
+
+// Error codes of the error responses, along with their HTTP status.
+const (
+	CodeInvalidRequest   = "invalid_request"    // 400
+	CodeNotFound         = "not_found"          // 404
+	CodeMethodNotAllowed = "method_not_allowed" // 405
+	CodeInternal         = "internal_error"     // 500
+)
+
+// maximum size of a request body
+const maxBodySize = 1 << 20
+
+var (
+	addressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+	hashPattern    = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+)
+
+// Error is the body of the error responses, e.g: {"error": {"code": "not_found", "message": "subscription not found"}}.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type errorResponse struct {
+	Error *Error `json:"error"`
+}
+
+// Server serves the endpoints of the indexer:
+//
+//	GET    /v1/block                                current indexed block
+//	POST   /v1/subscriptions                        subscribe an address, see SubscribeRequest
+//	GET    /v1/subscriptions/{address}              subscription of an address
+//	DELETE /v1/subscriptions/{address}              unsubscribe an address, its history is purged with ?purge=true
+//	PUT    /v1/subscriptions/{address}/webhook      set the webhook of an address, see WebhookRequest
+//	DELETE /v1/subscriptions/{address}/webhook      remove the webhook of an address
+//	GET    /v1/addresses/{address}/transactions     page of the transactions of an address, see parseTransactionQuery
+//	GET    /v1/addresses/{address}/balances         balances of an address by coin
+//	GET    /v1/transactions/{hash}                  transaction by its hash
+//	GET    /v1/webhooks/deliveries                  deliveries to the webhooks, filtered by ?address= and ?state=
+//	POST   /v1/webhooks/deliveries/{id}/redeliver   deliver a dead delivery again
+//
+// The deliveries endpoints are served when the deliveries are given with WithDeliveries.
+// The errors are answered with their status code and an Error body.
+type Server struct {
+	indexer    internal.Indexer
+	deliveries Deliveries
+	server     *http.Server
+}
+
+// Option configures the server.
+type Option func(*Server)
+
+// WithDeliveries serves the history of the deliveries to the webhooks, and their redelivery.
+func WithDeliveries(deliveries Deliveries) Option {
+	return func(s *Server) {
+		s.deliveries = deliveries
+	}
+}
+
+// NewServer creates the server of the indexer listening on the address, e.g: localhost:8080.
+func NewServer(addr string, indexer internal.Indexer, opts ...Option) *Server {
+	s := &Server{indexer: indexer}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	return s
+}
+
+// ListenAndServe serves the requests until the server is shut down, nil is returned then.
+func (s *Server) ListenAndServe() error {
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve http: %w", err)
+	}
+
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for the requests in flight until the context is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Handler returns the handler of the endpoints, served by ListenAndServe.
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(s.route)
+}
+
+// route dispatches the request by its path and method.
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		writeError(w, http.StatusNotFound, CodeNotFound, "no such endpoint "+r.URL.Path)
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "block":
+		s.handle(w, r, map[string]http.HandlerFunc{http.MethodGet: s.getCurrentBlock})
+	case len(parts) == 2 && parts[1] == "subscriptions":
+		s.handle(w, r, map[string]http.HandlerFunc{http.MethodPost: s.subscribe})
+	case len(parts) == 3 && parts[1] == "subscriptions":
+		s.handle(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:    func(w http.ResponseWriter, r *http.Request) { s.getSubscription(w, r, parts[2]) },
+			http.MethodDelete: func(w http.ResponseWriter, r *http.Request) { s.unsubscribe(w, r, parts[2]) },
+		})
+	case len(parts) == 4 && parts[1] == "subscriptions" && parts[3] == "webhook":
+		s.handle(w, r, map[string]http.HandlerFunc{
+			http.MethodPut:    func(w http.ResponseWriter, r *http.Request) { s.setWebhook(w, r, parts[2]) },
+			http.MethodDelete: func(w http.ResponseWriter, r *http.Request) { s.deleteWebhook(w, r, parts[2]) },
+		})
+	case len(parts) == 4 && parts[1] == "addresses" && parts[3] == "transactions":
+		s.handle(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.queryTransactions(w, r, parts[2]) },
+		})
+	case len(parts) == 4 && parts[1] == "addresses" && parts[3] == "balances":
+		s.handle(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.getBalances(w, r, parts[2]) },
+		})
+	case len(parts) == 3 && parts[1] == "transactions":
+		s.handle(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { s.getTransaction(w, r, parts[2]) },
+		})
+	case len(parts) == 3 && parts[1] == "webhooks" && parts[2] == "deliveries" && s.deliveries != nil:
+		s.handle(w, r, map[string]http.HandlerFunc{http.MethodGet: s.getDeliveries})
+	case len(parts) == 5 && parts[1] == "webhooks" && parts[2] == "deliveries" && parts[4] == "redeliver" && s.deliveries != nil:
+		s.handle(w, r, map[string]http.HandlerFunc{
+			http.MethodPost: func(w http.ResponseWriter, r *http.Request) { s.redeliver(w, r, parts[3]) },
+		})
+	default:
+		writeError(w, http.StatusNotFound, CodeNotFound, "no such endpoint "+r.URL.Path)
+	}
+}
+
+// handle calls the handler of the request method, answers 405 with the allowed methods otherwise.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request, handlers map[string]http.HandlerFunc) {
+	if handler, ok := handlers[r.Method]; ok {
+		handler(w, r)
+		return
+	}
+
+	allowed := make([]string, 0, len(handlers))
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method "+r.Method+" not allowed")
+}
+
+// writeJSON writes the value as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		fmt.Printf("failed to write http response: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, &errorResponse{Error: &Error{Code: code, Message: message}})
+}
+
+// writeInternalError logs the error and answers 500 without its details.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	fmt.Printf("failed to serve %s %s: %v\n", r.Method, r.URL.Path, err)
+	writeError(w, http.StatusInternalServerError, CodeInternal, "internal error")
+}
+
+// decodeBody decodes the JSON body of the request, the unknown fields are rejected.
+func decodeBody(w http.ResponseWriter, r *http.Request, value interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		return fmt.Errorf("invalid json body: %w", err)
+	}
+
+	return nil
+}
+
+// parseAddress validates the address, returned in lower case as the addresses are saved.
+func parseAddress(address string) (string, error) {
+	if !addressPattern.MatchString(address) {
+		return "", fmt.Errorf("invalid address %q, expected 0x followed by 40 hex digits", address)
+	}
+
+	return strings.ToLower(address), nil
+}
+
+// parseHash validates the transaction hash, returned in lower case as the hashes are saved.
+func parseHash(hash string) (string, error) {
+	if !hashPattern.MatchString(hash) {
+		return "", fmt.Errorf("invalid transaction hash %q, expected 0x followed by 64 hex digits", hash)
+	}
+
+	return strings.ToLower(hash), nil
+}
@@ -0,0 +1,263 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hoangan/superwallet/internal/api"
+	"github.com/hoangan/superwallet/internal/eth"
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/notifier"
+	"github.com/hoangan/superwallet/internal/storage/inmemorystorage"
+	"github.com/hoangan/superwallet/internal/testdata"
+)
+
+const (
+	address = "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender  = "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+)
+
+var (
+	hash1 = "0x" + strings.Repeat("1", 64)
+	hash2 = "0x" + strings.Repeat("2", 64)
+)
+
+func TestServer(t *testing.T) {
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0", testdata.NewRawTransaction(hash1, sender, address, 100)))
+	node.SetBlock(testdata.NewRawBlock(2, "0xa2", "0xa1", testdata.NewRawTransaction(hash2, address, sender, 50)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1), eth.WithPollInterval(10*time.Millisecond))
+	ethIndexer.Start()
+	defer ethIndexer.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ethIndexer.GetCurrentBlock().Int64() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("indexer did not reach block 2")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	server := httptest.NewServer(api.NewServer("", ethIndexer).Handler())
+	defer server.Close()
+
+	t.Run("Current Block", func(t *testing.T) {
+		var block api.BlockResponse
+		request(t, server, http.MethodGet, "/v1/block", "", http.StatusOK, &block)
+		if block.BlockNumber.Int64() != 2 {
+			t.Errorf("failed to get the current block, got %s", block.BlockNumber)
+		}
+	})
+
+	t.Run("Subscribe", func(t *testing.T) {
+		other := "0x00000000000000000000000000000000000000AA"
+
+		var response api.SubscribeResponse
+		request(t, server, http.MethodPost, "/v1/subscriptions", `{"address": "`+other+`"}`, http.StatusCreated, &response)
+		if response.Subscription.Address != strings.ToLower(other) || response.Subscription.State != m.SubscriptionStateActive ||
+			response.BackfillJob != nil {
+			t.Errorf("failed to subscribe address: %+v", response)
+		}
+
+		// already subscribed
+		request(t, server, http.MethodPost, "/v1/subscriptions", `{"address": "`+other+`"}`, http.StatusOK, &response)
+
+		var subscription m.Subscription
+		request(t, server, http.MethodGet, "/v1/subscriptions/"+other, "", http.StatusOK, &subscription)
+		if subscription.State != m.SubscriptionStateActive {
+			t.Errorf("failed to get the subscription: %+v", subscription)
+		}
+
+		request(t, server, http.MethodDelete, "/v1/subscriptions/"+other+"?purge=true", "", http.StatusNoContent, nil)
+		checkError(t, server, http.MethodGet, "/v1/subscriptions/"+other, "", http.StatusNotFound, api.CodeNotFound)
+		checkError(t, server, http.MethodDelete, "/v1/subscriptions/"+other, "", http.StatusNotFound, api.CodeNotFound)
+	})
+
+	t.Run("Subscribe With Backfill", func(t *testing.T) {
+		other := "0x00000000000000000000000000000000000000bb"
+
+		var response api.SubscribeResponse
+		request(t, server, http.MethodPost, "/v1/subscriptions", `{"address": "`+other+`", "fromBlock": "1"}`, http.StatusCreated, &response)
+		if response.BackfillJob == nil || response.BackfillJob.FromBlock.Int64() != 1 || response.BackfillJob.ToBlock.Int64() != 2 {
+			t.Errorf("failed to backfill the subscribed address: %+v", response.BackfillJob)
+		}
+	})
+
+	t.Run("Invalid Requests", func(t *testing.T) {
+		checkError(t, server, http.MethodPost, "/v1/subscriptions", `{"address": "0x1234"}`, http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodPost, "/v1/subscriptions", `{"address": "`+address+`", "unknown": 1}`, http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodPost, "/v1/subscriptions", `{"address": "`+address+`", "fromBlock": "last"}`, http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodPost, "/v1/subscriptions", `not json`, http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodDelete, "/v1/subscriptions/"+address+"?purge=maybe", "", http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodGet, "/v1/addresses/"+address+"/transactions?limit=5000", "", http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodGet, "/v1/addresses/"+address+"/transactions?cursor=invalid", "", http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodGet, "/v1/addresses/"+address+"/transactions?unknown=1", "", http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodGet, "/v1/transactions/0x1234", "", http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodGet, "/v1/unknown", "", http.StatusNotFound, api.CodeNotFound)
+		checkError(t, server, http.MethodPut, "/v1/block", "", http.StatusMethodNotAllowed, api.CodeMethodNotAllowed)
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		var page m.TransactionPage
+		request(t, server, http.MethodGet, "/v1/addresses/"+address+"/transactions?limit=1&order=asc", "", http.StatusOK, &page)
+		if len(page.Transactions) != 1 || page.Transactions[0].Hash != hash1 || page.NextCursor == "" {
+			t.Fatalf("failed to get the first page: %+v", page)
+		}
+
+		var next m.TransactionPage
+		request(t, server, http.MethodGet, "/v1/addresses/"+address+"/transactions?limit=1&order=asc&cursor="+page.NextCursor, "", http.StatusOK, &next)
+		if len(next.Transactions) != 1 || next.Transactions[0].Hash != hash2 || next.NextCursor != "" {
+			t.Errorf("failed to get the last page: %+v", next)
+		}
+
+		request(t, server, http.MethodGet, "/v1/addresses/"+address+"/transactions?direction=in&state=pending,confirmed", "", http.StatusOK, &page)
+		if len(page.Transactions) != 1 || page.Transactions[0].Hash != hash1 {
+			t.Errorf("failed to filter the transactions: %+v", page)
+		}
+
+		var tx m.Transaction
+		request(t, server, http.MethodGet, "/v1/transactions/"+hash2, "", http.StatusOK, &tx)
+		if tx.Hash != hash2 || tx.From != address {
+			t.Errorf("failed to get the transaction by hash: %+v", tx)
+		}
+		checkError(t, server, http.MethodGet, "/v1/transactions/0x"+strings.Repeat("f", 64), "", http.StatusNotFound, api.CodeNotFound)
+		checkError(t, server, http.MethodGet, "/v1/addresses/"+sender+"/transactions", "", http.StatusNotFound, api.CodeNotFound)
+	})
+
+	t.Run("Webhook", func(t *testing.T) {
+		var webhook m.Webhook
+		request(t, server, http.MethodPut, "/v1/subscriptions/"+address+"/webhook",
+			`{"url": "https://example.com/hook", "events": ["transaction.confirmed"]}`, http.StatusOK, &webhook)
+		if webhook.URL != "https://example.com/hook" || len(webhook.Secret) != 64 || len(webhook.Events) != 1 {
+			t.Errorf("failed to set the webhook with a generated secret: %+v", webhook)
+		}
+
+		var subscription m.Subscription
+		request(t, server, http.MethodGet, "/v1/subscriptions/"+address, "", http.StatusOK, &subscription)
+		if subscription.Webhook == nil || subscription.Webhook.URL != webhook.URL || subscription.Webhook.Secret != "" {
+			t.Errorf("failed to get the webhook without its secret: %+v", subscription.Webhook)
+		}
+
+		checkError(t, server, http.MethodPut, "/v1/subscriptions/"+address+"/webhook", `{"url": "ftp://example.com"}`, http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodPut, "/v1/subscriptions/"+address+"/webhook", `{"url": "https://example.com", "events": ["unknown"]}`, http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, server, http.MethodPut, "/v1/subscriptions/"+sender+"/webhook", `{"url": "https://example.com"}`, http.StatusNotFound, api.CodeNotFound)
+		for _, url := range []string{"http://localhost:8080", "http://127.0.0.1", "http://[::1]/hook", "http://169.254.169.254/latest", "https://10.0.0.1"} {
+			checkError(t, server, http.MethodPut, "/v1/subscriptions/"+address+"/webhook", `{"url": "`+url+`"}`, http.StatusBadRequest, api.CodeInvalidRequest)
+		}
+
+		request(t, server, http.MethodDelete, "/v1/subscriptions/"+address+"/webhook", "", http.StatusNoContent, nil)
+		var removed m.Subscription
+		request(t, server, http.MethodGet, "/v1/subscriptions/"+address, "", http.StatusOK, &removed)
+		if removed.Webhook != nil {
+			t.Errorf("failed to remove the webhook: %+v", removed.Webhook)
+		}
+
+		// the deliveries are not served without WithDeliveries
+		checkError(t, server, http.MethodGet, "/v1/webhooks/deliveries", "", http.StatusNotFound, api.CodeNotFound)
+	})
+
+	t.Run("Deliveries", func(t *testing.T) {
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer receiver.Close()
+
+		_ = storage.SetWebhook(address, &m.Webhook{URL: receiver.URL, Secret: "secret"})
+		defer func() { _ = storage.SetWebhook(address, nil) }()
+
+		webhooks := notifier.NewWebhookNotifier(ctx, storage, notifier.WithWebhookPollInterval(10*time.Millisecond),
+			notifier.WithWebhookPolicy(notifier.WebhookPolicy{MaxAttempts: 1, Timeout: time.Second}), notifier.WithPrivateWebhooks(true))
+		webhooks.Start()
+		defer webhooks.Stop()
+
+		// the delivery of the event is created along with the confirmation of the transaction
+		if _, err := storage.UpdateBlockTransactionsState(big.NewInt(1), m.TransactionStateConfirmed); err != nil {
+			t.Fatalf("failed to confirm the transactions: %v", err)
+		}
+
+		deliveriesServer := httptest.NewServer(api.NewServer("", ethIndexer, api.WithDeliveries(webhooks)).Handler())
+		defer deliveriesServer.Close()
+
+		var response api.DeliveriesResponse
+		deadline := time.Now().Add(5 * time.Second)
+		for len(response.Deliveries) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("failed to list the dead delivery")
+			}
+			time.Sleep(10 * time.Millisecond)
+			request(t, deliveriesServer, http.MethodGet, "/v1/webhooks/deliveries?state=dead&address="+address, "", http.StatusOK, &response)
+		}
+		delivery := response.Deliveries[0]
+		if delivery.Event.Transaction.Hash != hash1 || len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != http.StatusInternalServerError {
+			t.Errorf("failed to list the attempts of the delivery: %+v", delivery)
+		}
+
+		id := strconv.FormatInt(delivery.ID, 10)
+		request(t, deliveriesServer, http.MethodPost, "/v1/webhooks/deliveries/"+id+"/redeliver", "", http.StatusAccepted, nil)
+		checkError(t, deliveriesServer, http.MethodPost, "/v1/webhooks/deliveries/1000/redeliver", "", http.StatusNotFound, api.CodeNotFound)
+		checkError(t, deliveriesServer, http.MethodPost, "/v1/webhooks/deliveries/one/redeliver", "", http.StatusBadRequest, api.CodeInvalidRequest)
+		checkError(t, deliveriesServer, http.MethodGet, "/v1/webhooks/deliveries?state=lost", "", http.StatusBadRequest, api.CodeInvalidRequest)
+	})
+
+	t.Run("Balances", func(t *testing.T) {
+		var balances api.BalancesResponse
+		request(t, server, http.MethodGet, "/v1/addresses/"+address+"/balances", "", http.StatusOK, &balances)
+		if balances.Address != address || len(balances.Balances) == 0 || balances.Balances[0].CoinID != m.NativeCoinID {
+			t.Errorf("failed to get the balances: %+v", balances)
+		}
+		checkError(t, server, http.MethodGet, "/v1/addresses/"+sender+"/balances", "", http.StatusNotFound, api.CodeNotFound)
+	})
+}
+
+// request sends the request and decodes the JSON response into response, unless nil.
+func request(t *testing.T, server *httptest.Server, method string, path string, body string, status int, response interface{}) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		t.Fatalf("failed to get status %d of %s %s, got %d", status, method, path, resp.StatusCode)
+	}
+	if response == nil {
+		return
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+}
+
+// checkError checks the status and the code of the error response.
+func checkError(t *testing.T, server *httptest.Server, method string, path string, body string, status int, code string) {
+	t.Helper()
+
+	var response struct {
+		Error *api.Error `json:"error"`
+	}
+	request(t, server, method, path, body, status, &response)
+	if response.Error == nil || response.Error.Code != code || response.Error.Message == "" {
+		t.Errorf("failed to get error %s of %s %s, got %+v", code, method, path, response.Error)
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/notifier"
+)
+
+// Deliveries is the history of the deliveries of the events to the webhooks, e.g: notifier.WebhookNotifier.
+type Deliveries interface {
+	// GetDeliveries returns the deliveries of the address, of all the addresses when empty, in the state when given.
+	GetDeliveries(address string, state m.DeliveryState) ([]*m.Delivery, error)
+
+	// Redeliver delivers the dead delivery again.
+	Redeliver(id int64) (*m.Delivery, error)
+}
+
+// WebhookRequest is the body of PUT /v1/subscriptions/{address}/webhook.
+type WebhookRequest struct {
+	URL string `json:"url"`
+
+	// Secret signs the payloads, a random one is generated when empty.
+	Secret string `json:"secret,omitempty"`
+
+	// Events are the types of the events delivered, all of them when empty.
+	Events []m.EventType `json:"events,omitempty"`
+}
+
+// DeliveriesResponse is the body of the response of GET /v1/webhooks/deliveries.
+type DeliveriesResponse struct {
+	Deliveries []*m.Delivery `json:"deliveries"`
+}
+
+// setWebhook sets the webhook of the subscribed address, answered with the webhook along with its secret,
+// the only response the secret is shown in.
+func (s *Server) setWebhook(w http.ResponseWriter, r *http.Request, address string) {
+	address, err := parseAddress(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	var request WebhookRequest
+	if err := decodeBody(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	webhook := &m.Webhook{URL: request.URL, Secret: request.Secret, Events: request.Events}
+	if err := webhook.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if webhook.Secret == "" {
+		if webhook.Secret, err = notifier.NewWebhookSecret(); err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+	}
+
+	if err := s.indexer.SetWebhook(address, webhook); err != nil {
+		writeIndexerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, webhook)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request, address string) {
+	address, err := parseAddress(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	if err := s.indexer.SetWebhook(address, nil); err != nil {
+		writeIndexerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDeliveries lists the deliveries along with their attempts, filtered by ?address= and ?state=.
+func (s *Server) getDeliveries(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address != "" {
+		var err error
+		if address, err = parseAddress(address); err != nil {
+			writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	state := m.DeliveryState(r.URL.Query().Get("state"))
+	switch state {
+	case "", m.DeliveryStatePending, m.DeliveryStateDelivered, m.DeliveryStateDead:
+	default:
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid state %q, expected pending, delivered or dead", state))
+		return
+	}
+
+	deliveries, err := s.deliveries.GetDeliveries(address, state)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &DeliveriesResponse{Deliveries: deliveries})
+}
+
+// redeliver delivers a dead delivery again, answered with 202 as the delivery is attempted in the background.
+func (s *Server) redeliver(w http.ResponseWriter, r *http.Request, id string) {
+	deliveryID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("invalid delivery id %q", id))
+		return
+	}
+
+	delivery, err := s.deliveries.Redeliver(deliveryID)
+	switch {
+	case errors.Is(err, notifier.ErrDeliveryNotFound):
+		writeError(w, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, notifier.ErrDeliveryNotDead), errors.Is(err, notifier.ErrNoWebhook):
+		writeError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	case err != nil:
+		writeInternalError(w, r, err)
+	default:
+		writeJSON(w, http.StatusAccepted, delivery)
+	}
+}
+
+// withoutSecret returns the subscription with the secret of its webhook hidden.
+func withoutSecret(subscription *m.Subscription) *m.Subscription {
+	if subscription.Webhook == nil {
+		return subscription
+	}
+
+	webhook := *subscription.Webhook
+	webhook.Secret = ""
+	copied := *subscription
+	copied.Webhook = &webhook
+
+	return &copied
+}
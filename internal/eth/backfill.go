@@ -0,0 +1,394 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
+)
+
+// number of consecutive failures of a backfill job before giving up on it
+const maxBackfillFailures = 10
+
+// Errors of the canceled backfill jobs, by the reason they were interrupted.
+var (
+	errBackfillCanceled    = errors.New("indexer stopped")
+	errAddressPaused       = errors.New("address paused")
+	errAddressUnsubscribed = errors.New("address unsubscribed")
+)
+
+// SubscribeAddressFrom subscribes the address and backfills its transactions of the blocks already indexed,
+// from fromBlockNumber, or from the first activity of the address when fromBlockNumber is nil.
+// The backfill runs in background alongside the live indexing, its progress is reported by GetBackfillJobs.
+func (i *EthIndexer) SubscribeAddressFrom(address string, fromBlockNumber *big.Int) (*m.BackfillJob, error) {
+	// The blocks after the current indexed block are indexed live once the address is subscribed,
+	// the blocks up to it are left to the backfill.
+	// The job is registered before the address can be paused or unsubscribed again, to be canceled then.
+	i.commitLock.Lock()
+	defer i.commitLock.Unlock()
+
+	toBlockNumber := i.GetCurrentBlock()
+	if err := i.SubscribeAddress(address); err != nil {
+		return nil, err
+	}
+
+	job := &m.BackfillJob{
+		Address:   address,
+		ToBlock:   toBlockNumber,
+		State:     m.BackfillStateQueued,
+		CreatedAt: time.Now(),
+	}
+	if fromBlockNumber != nil {
+		job.FromBlock = new(big.Int).Set(fromBlockNumber)
+	}
+
+	// canceled along with the indexer, or by the address being paused or unsubscribed
+	ctx, cancel := context.WithCancelCause(i.ctx)
+
+	i.backfillLock.Lock()
+	i.nextBackfillID++
+	job.ID = i.nextBackfillID
+	i.backfills = append(i.backfills, job)
+	i.backfillCancels[job.ID] = cancel
+	i.backfillLock.Unlock()
+
+	i.wg.Add(1)
+	go func() {
+		defer i.wg.Done()
+		i.runBackfill(ctx, job)
+	}()
+
+	return i.backfillSnapshot(job), nil
+}
+
+// GetBackfillJobs returns the backfill jobs in the order they were created, along with their progress.
+func (i *EthIndexer) GetBackfillJobs() []*m.BackfillJob {
+	i.backfillLock.Lock()
+	defer i.backfillLock.Unlock()
+
+	jobs := make([]*m.BackfillJob, 0, len(i.backfills))
+	for _, job := range i.backfills {
+		jobs = append(jobs, copyBackfillJob(job))
+	}
+
+	return jobs
+}
+
+func (i *EthIndexer) backfillSnapshot(job *m.BackfillJob) *m.BackfillJob {
+	i.backfillLock.Lock()
+	defer i.backfillLock.Unlock()
+
+	return copyBackfillJob(job)
+}
+
+func copyBackfillJob(job *m.BackfillJob) *m.BackfillJob {
+	snapshot := *job
+	for _, number := range []**big.Int{&snapshot.FromBlock, &snapshot.ToBlock, &snapshot.ScannedBlock} {
+		if *number != nil {
+			*number = new(big.Int).Set(*number)
+		}
+	}
+
+	return &snapshot
+}
+
+// updateBackfill changes the job under the lock, the job is read concurrently by GetBackfillJobs.
+func (i *EthIndexer) updateBackfill(job *m.BackfillJob, update func(job *m.BackfillJob)) {
+	i.backfillLock.Lock()
+	defer i.backfillLock.Unlock()
+
+	update(job)
+}
+
+// cancelBackfills cancels the jobs of the address not finished yet, for the reason given.
+// Called along with the change of the subscription, under the commit lock: no block of the jobs is saved afterwards.
+func (i *EthIndexer) cancelBackfills(address string, reason error) {
+	i.backfillLock.Lock()
+	defer i.backfillLock.Unlock()
+
+	for _, job := range i.backfills {
+		if cancel, ok := i.backfillCancels[job.ID]; ok && job.Address == address {
+			cancel(reason)
+		}
+	}
+}
+
+// finishBackfill moves the job to its final state.
+func (i *EthIndexer) finishBackfill(job *m.BackfillJob, state m.BackfillState, err error) {
+	i.updateBackfill(job, func(job *m.BackfillJob) {
+		job.State = state
+		job.FinishedAt = time.Now()
+		if err != nil {
+			job.Error = err.Error()
+		}
+
+		if cancel, ok := i.backfillCancels[job.ID]; ok {
+			cancel(nil)
+			delete(i.backfillCancels, job.ID)
+		}
+	})
+
+	if err != nil {
+		fmt.Printf("backfill %d of %s %s: %v\n", job.ID, job.Address, state, err)
+		return
+	}
+	fmt.Printf("backfill %d of %s %s, %d transactions found\n", job.ID, job.Address, state, job.Transactions)
+}
+
+// runBackfill scans the blocks of the job once the running job is done, one job runs at a time.
+// The failed blocks are retried from the last scanned block, the job fails after too many consecutive failures.
+// The job is canceled once the context is done, by the indexer being stopped or the address being paused or unsubscribed.
+func (i *EthIndexer) runBackfill(ctx context.Context, job *m.BackfillJob) {
+	select {
+	case <-ctx.Done():
+		i.finishBackfill(job, m.BackfillStateCanceled, backfillCancelCause(ctx))
+		return
+	case i.backfillSlot <- struct{}{}:
+	}
+	defer func() { <-i.backfillSlot }()
+
+	i.updateBackfill(job, func(job *m.BackfillJob) { job.State = m.BackfillStateRunning })
+
+	failures := 0
+	for {
+		err := i.backfill(ctx, job)
+		if err == nil {
+			i.finishBackfill(job, m.BackfillStateCompleted, nil)
+			return
+		}
+
+		if ctx.Err() != nil {
+			i.finishBackfill(job, m.BackfillStateCanceled, backfillCancelCause(ctx))
+			return
+		}
+
+		failures++
+		if failures >= maxBackfillFailures {
+			i.finishBackfill(job, m.BackfillStateFailed, err)
+			return
+		}
+
+		delay := retryDelay(err, failures)
+		if errors.Is(err, rpc.ErrMethodNotSupported) {
+			fmt.Printf("node does not support a method required to backfill the blocks\n")
+		}
+		fmt.Printf("backfill %d of %s: %v. Retry in %v...\n", job.ID, job.Address, err, delay)
+		sleep(ctx, delay)
+	}
+}
+
+// backfillCancelCause returns why the job was canceled, errBackfillCanceled when the indexer was stopped.
+func backfillCancelCause(ctx context.Context) error {
+	if cause := context.Cause(ctx); errors.Is(cause, errAddressPaused) || errors.Is(cause, errAddressUnsubscribed) {
+		return cause
+	}
+	return errBackfillCanceled
+}
+
+// backfill scans the blocks of the job after the last scanned block,
+// with the pipeline workers fetching the blocks in parallel.
+func (i *EthIndexer) backfill(ctx context.Context, job *m.BackfillJob) error {
+	if job.FromBlock == nil {
+		fromBlockNumber, err := i.findFirstActivity(ctx, job.Address, job.ToBlock)
+		if err != nil {
+			return fmt.Errorf("failed to find first activity: %w", err)
+		}
+		i.updateBackfill(job, func(job *m.BackfillJob) { job.FromBlock = fromBlockNumber })
+	}
+
+	if job.ScannedBlock == nil {
+		i.updateBackfill(job, func(job *m.BackfillJob) { job.ScannedBlock = new(big.Int).Sub(job.FromBlock, big.NewInt(1)) })
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	// stop fetching ahead when returning early
+	defer cancel()
+
+	fromBlockNumber := new(big.Int).Add(job.ScannedBlock, big.NewInt(1))
+	for slot := range i.prefetchBlocks(ctx, fromBlockNumber, job.ToBlock) {
+		var block *fetchedBlock
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case block = <-slot:
+		}
+
+		if block.err != nil {
+			return block.err
+		}
+
+		saved, err := i.saveBackfilledBlock(ctx, job.Address, block)
+		if err != nil {
+			return err
+		}
+
+		i.updateBackfill(job, func(job *m.BackfillJob) {
+			job.ScannedBlock = block.number
+			job.Transactions += saved
+		})
+	}
+
+	return ctx.Err()
+}
+
+// saveBackfilledBlock saves the transactions of the address of the block, in the confirmation state of the block.
+// The balance changes of the backfilled transactions are left out, they are part of the balance seeded from the node.
+// Returns the number of transactions saved.
+func (i *EthIndexer) saveBackfilledBlock(ctx context.Context, address string, block *fetchedBlock) (int, error) {
+	// The block must be on the indexed chain, no block is committed or rolled back in between.
+	i.commitLock.Lock()
+	defer i.commitLock.Unlock()
+
+	// canceled by the address being paused or unsubscribed while the block was fetched
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// rolled back since the job started, the block is indexed live again
+	if block.number.Cmp(i.GetCurrentBlock()) > 0 {
+		return 0, nil
+	}
+	if hash, ok := i.recentBlocks.hash(block.number); ok && hash != block.hash {
+		return 0, fmt.Errorf("block %s has been reorganized while backfilling", block.number)
+	}
+
+	state := m.TransactionStatePending
+	if block.number.Cmp(i.finalizedBlock) <= 0 {
+		state = m.TransactionStateFinalized
+	} else if block.number.Cmp(i.confirmedBlock) <= 0 {
+		state = m.TransactionStateConfirmed
+	}
+
+	saved := 0
+	for _, tx := range block.txs {
+		if !involvesAddress(tx, address) {
+			continue
+		}
+
+		tx.State = state
+		if err := i.storage.AddAddressTransaction(address, tx); err != nil {
+			return saved, fmt.Errorf("failed to save backfilled transaction %s: %w", tx.Hash, err)
+		}
+		saved++
+	}
+
+	return saved, nil
+}
+
+// involvesAddress reports whether the transaction is sent by the address or transfers from or to it.
+func involvesAddress(tx *m.Transaction, address string) bool {
+	for _, txAddress := range transactionAddresses(tx) {
+		if txAddress == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findFirstActivity searches the first block up to toBlockNumber where the address has sent a transaction,
+// holds ether or received tokens. The address may receive tokens long before any ether, e.g: a deposit address,
+// so the earlier of the first ether activity and the first token receipt is returned.
+// The block after toBlockNumber is returned when the address has no activity at all.
+func (i *EthIndexer) findFirstActivity(ctx context.Context, address string, toBlockNumber *big.Int) (*big.Int, error) {
+	first, err := i.findFirstAccountActivity(ctx, address, toBlockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the token receipts before the first ether activity matter
+	if first.Sign() > 0 {
+		tokenFirst, err := i.findFirstTokenReceipt(ctx, address, big.NewInt(0), new(big.Int).Sub(first, big.NewInt(1)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to find first token receipt: %w", err)
+		}
+		if tokenFirst != nil {
+			first = tokenFirst
+		}
+	}
+
+	if first.Cmp(toBlockNumber) > 0 {
+		fmt.Printf("no activity found for %s up to block %s\n", address, toBlockNumber)
+	}
+
+	return first, nil
+}
+
+// findFirstAccountActivity searches the first block up to toBlockNumber where the address has sent a transaction
+// or holds ether. Neither goes back to zero for an account, except by sending a transaction,
+// so the first activity is found by a binary search on the state of the node, which needs an archive node.
+// The block after toBlockNumber is returned when not found.
+func (i *EthIndexer) findFirstAccountActivity(ctx context.Context, address string, toBlockNumber *big.Int) (*big.Int, error) {
+	active := func(blockNumber *big.Int) (bool, error) {
+		nonce, err := i.client.GetTransactionCount(ctx, address, blockNumber)
+		if err != nil {
+			return false, err
+		}
+		if nonce.Sign() > 0 {
+			return true, nil
+		}
+
+		balance, err := i.client.GetBalance(ctx, address, blockNumber)
+		if err != nil {
+			return false, err
+		}
+		return balance.Sign() > 0, nil
+	}
+
+	low, high := big.NewInt(0), new(big.Int).Add(toBlockNumber, big.NewInt(1))
+	for low.Cmp(high) < 0 {
+		middle := new(big.Int).Add(low, high)
+		middle.Rsh(middle, 1)
+
+		ok, err := active(middle)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			high = middle
+		} else {
+			low = middle.Add(middle, big.NewInt(1))
+		}
+	}
+
+	return low, nil
+}
+
+// findFirstTokenReceipt searches the first block from fromBlockNumber to toBlockNumber with an ERC-20 Transfer
+// to the address, nil when none. A range rejected by the provider for returning too many logs is split in halves,
+// the lower half searched first.
+func (i *EthIndexer) findFirstTokenReceipt(ctx context.Context, address string, fromBlockNumber *big.Int, toBlockNumber *big.Int) (*big.Int, error) {
+	logs, err := i.client.GetLogs(ctx, fromBlockNumber, toBlockNumber, transferEventTopic, nil, addressToTopic(address))
+	if errors.Is(err, rpc.ErrCallRejected) && fromBlockNumber.Cmp(toBlockNumber) < 0 {
+		middle := new(big.Int).Add(fromBlockNumber, toBlockNumber)
+		middle.Rsh(middle, 1)
+
+		first, err := i.findFirstTokenReceipt(ctx, address, fromBlockNumber, middle)
+		if err != nil || first != nil {
+			return first, err
+		}
+		return i.findFirstTokenReceipt(ctx, address, new(big.Int).Add(middle, big.NewInt(1)), toBlockNumber)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var first *big.Int
+	for _, log := range logs {
+		blockNumber, err := hexencoder.HexToDecimal(log.BlockNumber)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse block number of log: %w", err)
+		}
+		if first == nil || blockNumber.Cmp(first) < 0 {
+			first = blockNumber
+		}
+	}
+
+	return first, nil
+}
@@ -0,0 +1,134 @@
+package eth
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	m "github.com/hoangan/superwallet/internal/models"
+)
+
+const (
+	// how often the balances computed from the indexed transactions are checked against the node
+	defaultBalanceReconcileInterval = 10 * time.Minute
+
+	// number of subscribed addresses waiting for their balances to be seeded
+	balanceSeedQueueSize = 64
+)
+
+// BalanceDrift is a balance computed from the indexed transactions that differs from the balance of the node
+// at the same block, e.g: a transfer of the address missed by the indexer.
+type BalanceDrift struct {
+	Address     string
+	CoinID      int64
+	BlockNumber *big.Int
+	Computed    *big.Int
+	Node        *big.Int
+}
+
+// BalanceDriftHook is called for every balance found drifting from the node.
+type BalanceDriftHook func(drift *BalanceDrift)
+
+// logBalanceDrift logs the balances drifting from the node.
+func logBalanceDrift(drift *BalanceDrift) {
+	fmt.Printf("balance of coin %d of %s drifted at block %s: computed %s, node %s\n",
+		drift.CoinID, drift.Address, drift.BlockNumber, drift.Computed, drift.Node)
+}
+
+// reconcileBalances seeds the balances of the newly subscribed addresses,
+// and checks the balances of every subscribed address against the node every reconcile interval.
+func (i *EthIndexer) reconcileBalances() {
+	ticker := time.NewTicker(i.balanceReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-i.ctx.Done():
+			return
+		case address := <-i.balanceSeeds:
+			if err := i.reconcileAddress(address); err != nil {
+				fmt.Printf("failed to seed balances of %s: %v\n", address, err)
+			}
+		case <-ticker.C:
+			addresses, err := i.storage.GetAddressesWithBalances()
+			if err != nil {
+				fmt.Printf("failed to reconcile balances: %v\n", err)
+				continue
+			}
+
+			for address := range addresses {
+				// the transactions of a paused address are not recorded, its balances drift from the node
+				if !i.storage.IsSubscribedAddress(address) {
+					continue
+				}
+
+				if err := i.reconcileAddress(address); err != nil {
+					fmt.Printf("failed to reconcile balances of %s: %v\n", address, err)
+				}
+			}
+		}
+	}
+}
+
+// reconcileAddress compares the balances of the address computed from the indexed transactions
+// with the balances of the node at the current indexed block, for the native coin and the known tokens.
+// A balance not seeded yet gets the difference, the balance of the address before the indexed transactions,
+// a seeded balance differing from the node is reported to the drift hook.
+func (i *EthIndexer) reconcileAddress(address string) error {
+	// The computed balances must match the block, no block is committed or rolled back in between.
+	i.commitLock.Lock()
+	blockNumber := i.GetCurrentBlock()
+	balances, err := i.storage.GetBalances(address)
+	i.commitLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	computed := make(map[int64]*m.Balance)
+	for _, balance := range balances {
+		computed[balance.CoinID] = balance
+	}
+
+	// the node balances of the same block, later blocks only add their own changes on top of the seed
+	nodeBalances := make(map[int64]*big.Int)
+	if nodeBalances[coinId], err = i.client.GetBalance(i.ctx, address, blockNumber); err != nil {
+		return err
+	}
+	for contract, token := range i.tokens {
+		if nodeBalances[token.CoinID], err = i.client.GetTokenBalance(i.ctx, contract, address, blockNumber); err != nil {
+			return err
+		}
+	}
+
+	for coinID, nodeBalance := range nodeBalances {
+		balance, ok := computed[coinID]
+		if !ok {
+			balance = &m.Balance{CoinID: coinID, Balance: big.NewInt(0)}
+		}
+
+		if !balance.Seeded {
+			if err := i.storage.SeedBalance(address, coinID, new(big.Int).Sub(nodeBalance, balance.Balance)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if balance.Balance.Cmp(nodeBalance) != 0 {
+			i.balanceDriftHook(&BalanceDrift{
+				Address:     address,
+				CoinID:      coinID,
+				BlockNumber: blockNumber,
+				Computed:    balance.Balance,
+				Node:        nodeBalance,
+			})
+		}
+	}
+
+	return nil
+}
+
+// GetBalances returns the balances of the address by coin, computed from the indexed transactions
+// and seeded with the balances of the node once subscribed.
+func (i *EthIndexer) GetBalances(address string) ([]*m.Balance, error) {
+	return i.storage.GetBalances(address)
+}
@@ -0,0 +1,72 @@
+package eth
+
+import "math/big"
+
+// blockWindow keeps the hashes of the most recent indexed blocks,
+// contiguous by block number, in order to detect chain reorganizations
+// and to find the common ancestor with the canonical chain.
+type blockWindow struct {
+	size   int
+	first  *big.Int // block number of hashes[0]
+	hashes []string
+}
+
+func newBlockWindow(size int) *blockWindow {
+	return &blockWindow{
+		size:   size,
+		hashes: make([]string, 0, size),
+	}
+}
+
+// push appends the hash of the next block, evicting the oldest one once the window is full.
+// A block not following the last one in the window resets the window.
+func (w *blockWindow) push(blockNumber *big.Int, hash string) {
+	if len(w.hashes) == 0 || w.next().Cmp(blockNumber) != 0 {
+		w.first = new(big.Int).Set(blockNumber)
+		w.hashes = w.hashes[:0]
+	}
+
+	w.hashes = append(w.hashes, hash)
+	if len(w.hashes) > w.size {
+		w.hashes = w.hashes[1:]
+		w.first.Add(w.first, big.NewInt(1))
+	}
+}
+
+// hash returns the hash of the block if it is still in the window.
+func (w *blockWindow) hash(blockNumber *big.Int) (string, bool) {
+	if len(w.hashes) == 0 || blockNumber.Cmp(w.first) < 0 || blockNumber.Cmp(w.next()) >= 0 {
+		return "", false
+	}
+
+	return w.hashes[new(big.Int).Sub(blockNumber, w.first).Int64()], true
+}
+
+// oldest returns the number of the oldest block in the window, nil when the window is empty.
+func (w *blockWindow) oldest() *big.Int {
+	if len(w.hashes) == 0 {
+		return nil
+	}
+
+	return new(big.Int).Set(w.first)
+}
+
+// truncate drops the blocks after the given block number.
+func (w *blockWindow) truncate(blockNumber *big.Int) {
+	if len(w.hashes) == 0 {
+		return
+	}
+
+	if blockNumber.Cmp(w.first) < 0 {
+		w.hashes = w.hashes[:0]
+		return
+	}
+
+	if keep := new(big.Int).Sub(blockNumber, w.first).Int64() + 1; keep < int64(len(w.hashes)) {
+		w.hashes = w.hashes[:keep]
+	}
+}
+
+func (w *blockWindow) next() *big.Int {
+	return new(big.Int).Add(w.first, big.NewInt(int64(len(w.hashes))))
+}
@@ -0,0 +1,135 @@
+package eth
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
+)
+
+const (
+	// number of blocks, including the transaction block, for a transaction to be confirmed
+	defaultConfirmations = 12
+
+	// number of blocks for a transaction to be finalized when the node does not support the finalized tag,
+	// two epochs of 32 slots on mainnet
+	defaultFinalityDepth = 64
+)
+
+// updateConfirmations moves the saved transactions forward to confirmed and finalized states
+// as the current indexed block moves ahead, and notifies the subscribers about it.
+// A block is confirmed once it has enough confirmations or is considered safe by the consensus layer,
+// and finalized once it is finalized by the consensus layer or deep enough when block tags are not in use.
+func (i *EthIndexer) updateConfirmations() {
+	currentBlockNumber := i.GetCurrentBlock()
+
+	confirmedBlockNumber := new(big.Int).Sub(currentBlockNumber, new(big.Int).SetUint64(i.confirmations-1))
+	finalizedBlockNumber := new(big.Int).Sub(currentBlockNumber, new(big.Int).SetUint64(i.finalityDepth-1))
+
+	if i.useBlockTags {
+		i.refreshBlockTags()
+
+		if i.safeTagBlock != nil {
+			confirmedBlockNumber = maxBig(confirmedBlockNumber, minBig(i.safeTagBlock, currentBlockNumber))
+		}
+
+		if i.finalizedTagBlock != nil {
+			finalizedBlockNumber = minBig(i.finalizedTagBlock, currentBlockNumber)
+		}
+	}
+
+	// Every finalized transaction goes through the confirmed state first.
+	confirmedBlockNumber = maxBig(confirmedBlockNumber, finalizedBlockNumber)
+
+	i.confirmedBlock = i.promoteTransactions(i.confirmedBlock, confirmedBlockNumber, m.TransactionStateConfirmed, m.EventTransactionConfirmed)
+	i.finalizedBlock = i.promoteTransactions(i.finalizedBlock, finalizedBlockNumber, m.TransactionStateFinalized, m.EventTransactionFinalized)
+}
+
+// promoteTransactions moves the transactions of the blocks after fromBlockNumber up to toBlockNumber to the state.
+// Returns the block number it has been promoted up to, which is behind toBlockNumber in case of storage failure.
+func (i *EthIndexer) promoteTransactions(fromBlockNumber *big.Int, toBlockNumber *big.Int, state m.TransactionState, eventType m.EventType) *big.Int {
+	blockNumber := new(big.Int).Set(fromBlockNumber)
+	for blockNumber.Cmp(toBlockNumber) < 0 {
+		nextBlockNumber := new(big.Int).Add(blockNumber, big.NewInt(1))
+
+		updated, err := i.storage.UpdateBlockTransactionsState(nextBlockNumber, state)
+		if err != nil {
+			fmt.Printf("failed to update transactions of block %s to %s: %v\n", nextBlockNumber, state, err)
+			return blockNumber
+		}
+
+		for _, addressTx := range updated {
+			i.notify(eventType, addressTx.Address, addressTx.Transaction)
+		}
+
+		blockNumber = nextBlockNumber
+	}
+
+	return blockNumber
+}
+
+// refreshBlockTags fetches the safe and finalized blocks, at most once per poll interval.
+// Block tags are disabled when the node does not support them, e.g: pre-merge chains or L2s
+// answer no block or reject the tag.
+func (i *EthIndexer) refreshBlockTags() {
+	if time.Since(i.blockTagsFetchedAt) < i.pollInterval {
+		return
+	}
+	i.blockTagsFetchedAt = time.Now()
+
+	for _, tag := range []string{rpc.BlockTagSafe, rpc.BlockTagFinalized} {
+		header, err := i.client.GetBlockHeaderByTag(i.ctx, tag)
+		if errors.Is(err, rpc.ErrNotFound) || errors.Is(err, rpc.ErrMethodNotSupported) || errors.Is(err, rpc.ErrCallRejected) {
+			i.disableBlockTags(tag, err)
+			return
+		} else if err != nil {
+			fmt.Printf("failed to get %s block: %v\n", tag, err)
+			return
+		}
+
+		blockNumber, err := hexencoder.HexToDecimal(header.Number)
+		if err != nil {
+			i.disableBlockTags(tag, err)
+			return
+		}
+
+		if tag == rpc.BlockTagSafe {
+			i.safeTagBlock = blockNumber
+		} else {
+			i.finalizedTagBlock = blockNumber
+		}
+	}
+}
+
+// disableBlockTags falls back to the finality depth for good, the node does not serve the tag.
+func (i *EthIndexer) disableBlockTags(tag string, err error) {
+	fmt.Printf("node does not support %s block tag (%v), falling back to %d blocks finality depth\n", tag, err, i.finalityDepth)
+	i.useBlockTags = false
+	i.safeTagBlock = nil
+	i.finalizedTagBlock = nil
+}
+
+// resetConfirmations moves the confirmation progress back after a rollback,
+// the re-indexed blocks have to be confirmed again.
+func (i *EthIndexer) resetConfirmations(blockNumber *big.Int) {
+	i.confirmedBlock = minBig(i.confirmedBlock, blockNumber)
+	i.finalizedBlock = minBig(i.finalizedBlock, blockNumber)
+}
+
+func minBig(a *big.Int, b *big.Int) *big.Int {
+	if a.Cmp(b) < 0 {
+		return new(big.Int).Set(a)
+	}
+	return new(big.Int).Set(b)
+}
+
+func maxBig(a *big.Int, b *big.Int) *big.Int {
+	if a.Cmp(b) > 0 {
+		return new(big.Int).Set(a)
+	}
+	return new(big.Int).Set(b)
+}
@@ -0,0 +1,104 @@
+package eth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
+)
+
+const (
+	// keccak256("Transfer(address,address,uint256)")
+	transferEventTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+	// coin id of transfers of tokens not known by the indexer
+	unknownTokenCoinId = m.UnknownCoinID
+)
+
+// DefaultTokens are the ERC-20 tokens resolved to their coin id and ticker by default.
+var DefaultTokens = []*m.Token{
+	{CoinID: 2, Ticker: "USDT", Contract: "0xdac17f958d2ee523a2206206994597c13d831ec7", Decimals: 6},
+	{CoinID: 3, Ticker: "USDC", Contract: "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", Decimals: 6},
+}
+
+// ParseTransferLog decodes an ERC-20 Transfer(address indexed from, address indexed to, uint256 value) event
+// into a transfer of the token. The token is resolved to its coin id and ticker when known by the indexer,
+// unknown tokens are transferred with coin id 0 and no ticker.
+func (i *EthIndexer) ParseTransferLog(log *rpc.RawLog) (*m.Transfer, error) {
+	// ERC-721 Transfer event has the same signature with the token id indexed as the fourth topic
+	if len(log.Topics) != 3 || log.Topics[0] != transferEventTopic {
+		return nil, fmt.Errorf("not an erc20 transfer event: %v", log.Topics)
+	}
+
+	from, err := topicToAddress(log.Topics[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transfer from: %w", err)
+	}
+
+	to, err := topicToAddress(log.Topics[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transfer to: %w", err)
+	}
+
+	value, err := hexencoder.HexToDecimal(log.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transfer value: %w", err)
+	}
+
+	logIndex, err := hexencoder.HexToDecimal(log.LogIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse log index: %w", err)
+	}
+
+	transfer := &m.Transfer{
+		CoinID:   unknownTokenCoinId,
+		From:     from,
+		To:       to,
+		Value:    value,
+		Token:    strings.ToLower(log.Address),
+		LogIndex: logIndex,
+	}
+
+	if token, ok := i.tokens[transfer.Token]; ok {
+		transfer.CoinID = token.CoinID
+		transfer.Ticker = token.Ticker
+	}
+
+	return transfer, nil
+}
+
+// parseTransferLogs decodes the token transfers of the transaction logs, other events are skipped.
+func (i *EthIndexer) parseTransferLogs(logs []*rpc.RawLog) []*m.Transfer {
+	transfers := []*m.Transfer{}
+	for _, log := range logs {
+		// removed logs belong to a block dropped by a reorganization
+		if log.Removed {
+			continue
+		}
+
+		transfer, err := i.ParseTransferLog(log)
+		if err != nil {
+			continue
+		}
+
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers
+}
+
+// topicToAddress takes the address out of a 32 bytes topic, addresses are left padded with zeros.
+func topicToAddress(topic string) (string, error) {
+	if len(topic) != 66 || !strings.HasPrefix(topic, "0x") {
+		return "", fmt.Errorf("invalid address topic: %s", topic)
+	}
+
+	return "0x" + strings.ToLower(topic[26:]), nil
+}
+
+// addressToTopic pads the address to the 32 bytes of an indexed address topic.
+func addressToTopic(address string) string {
+	return "0x" + strings.Repeat("0", 24) + strings.ToLower(strings.TrimPrefix(address, "0x"))
+}
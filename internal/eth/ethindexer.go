@@ -2,56 +2,168 @@ package eth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hoangan/superwallet/internal/eth/rpc"
 	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/notifier"
 	"github.com/hoangan/superwallet/internal/storage"
 	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
+	"github.com/hoangan/superwallet/pkg/httpclient"
 )
 
 const (
 	DefaultFromBlockNumber = 15537393
-	blockTime              = 15    // seconds
-	retryTime              = 10    // seconds
-	chainId                = 1     // mainnet
-	coinId                 = 1     // ethereum
-	coinTicker             = "ETH" // ethereum
+	blockTime              = 15             // seconds
+	retryTime              = 10             // seconds
+	minRetryTime           = 1              // seconds, backing off up to maxRetryTime
+	maxRetryTime           = 60             // seconds
+	rateLimitedRetryTime   = 30             // seconds, when the node does not tell how long to wait
+	nodeBehindRetryTime    = 1              // seconds
+	chainId                = 1              // mainnet
+	coinId                 = m.NativeCoinID // ethereum
+	coinTicker             = "ETH"          // ethereum
+
+	// number of blocks fetched in parallel, and fetched ahead of the committed block
+	defaultWorkers       = 4
+	defaultPrefetchDepth = 16
+
+	// number of recent block hashes kept to detect chain reorganizations
+	// mainnet reorgs rarely go deeper than a few blocks since the merge
+	defaultReorgWindow = 64
 )
 
 type EthIndexer struct {
 	ctx                 context.Context
+	cancel              context.CancelFunc
 	ticker              *time.Ticker
 	client              *rpc.EthClient
+	endpoints           []rpc.Endpoint
+	pool                *rpc.Pool
+	heads               *rpc.HeadsSubscriber // nil without WebSocket endpoint
+	pollInterval        time.Duration
+	circuitStateHook    httpclient.StateHook
+	methodCosts         map[string]int
 	currentIndexedBlock *big.Int
-	storage             storage.Storage
-	once                sync.Once
-	wg                  sync.WaitGroup
+	recentBlocks        *blockWindow
+	workers             int
+	prefetchDepth       int
+	tokens              map[string]*m.Token // by contract address
+
+	// node capabilities detected by the pipeline workers
+	traceMethod              atomic.Int32
+	blockReceiptsUnsupported atomic.Bool
+
+	// confirmation settings and progress, the transactions of the blocks
+	// up to confirmedBlock and finalizedBlock have been moved to the respective state
+	confirmations      uint64
+	finalityDepth      uint64
+	useBlockTags       bool
+	confirmedBlock     *big.Int
+	finalizedBlock     *big.Int
+	safeTagBlock       *big.Int
+	finalizedTagBlock  *big.Int
+	blockTagsFetchedAt time.Time
+
+	// balances of the subscribed addresses checked against the node, seeded once subscribed
+	balanceReconcileInterval time.Duration
+	balanceDriftHook         BalanceDriftHook
+	balanceSeeds             chan string
+
+	// webhooks pointing at non-public hosts are allowed, see WithPrivateWebhooks
+	privateWebhooks bool
+
+	storage  storage.Storage
+	notifier notifier.Notifier
+	lock     sync.RWMutex
+
+	// held while a block is committed or rolled back, and the confirmations are updated
+	commitLock sync.Mutex
+
+	// backfill jobs of the addresses subscribed from a past block, run one at a time
+	backfills       []*m.BackfillJob
+	backfillCancels map[int64]context.CancelCauseFunc // of the jobs not finished, by id
+	nextBackfillID  int64
+	backfillLock    sync.Mutex
+	backfillSlot    chan struct{}
+	once            sync.Once
+	wg              sync.WaitGroup
 }
 
-func NewIndexer(ctx context.Context, endpoint string, storage storage.Storage, fromBlockNumber *big.Int) (*EthIndexer, error) {
-	var err error
+// NewIndexer creates the indexer starting from fromBlockNumber.
+// When fromBlockNumber is nil, the indexer resumes from the block after the saved checkpoint,
+// or from DefaultFromBlockNumber in case nothing has been indexed yet.
+func NewIndexer(ctx context.Context, endpoint string, store storage.Storage, fromBlockNumber *big.Int, opts ...Option) (*EthIndexer, error) {
+	var checkpoint *m.Checkpoint
 	var currentIndexedBlock *big.Int
 	if fromBlockNumber == nil {
-		// in production system, load the last indexed block from the database
+		// load the last indexed block from the database
 		// for case where by the system is restarted
-		if currentIndexedBlock, err = storage.GetIndexedBlockNumber(); err != nil {
-			currentIndexedBlock = big.NewInt(DefaultFromBlockNumber)
+		var err error
+		checkpoint, err = store.GetCheckpoint()
+		if errors.Is(err, storage.ErrCheckpointNotFound) {
+			currentIndexedBlock = big.NewInt(DefaultFromBlockNumber - 1)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+		} else {
+			currentIndexedBlock = new(big.Int).Set(checkpoint.BlockNumber)
 		}
 	} else {
-		currentIndexedBlock = fromBlockNumber.Sub(fromBlockNumber, big.NewInt(1))
+		currentIndexedBlock = new(big.Int).Sub(fromBlockNumber, big.NewInt(1))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	indexer := &EthIndexer{
+		ctx:                      ctx,
+		cancel:                   cancel,
+		ticker:                   time.NewTicker(blockTime * time.Second),
+		pollInterval:             blockTime * time.Second,
+		circuitStateHook:         logCircuitState,
+		endpoints:                []rpc.Endpoint{{URL: endpoint, Weight: 1}},
+		currentIndexedBlock:      currentIndexedBlock,
+		recentBlocks:             newBlockWindow(defaultReorgWindow),
+		workers:                  defaultWorkers,
+		prefetchDepth:            defaultPrefetchDepth,
+		tokens:                   make(map[string]*m.Token),
+		confirmations:            defaultConfirmations,
+		finalityDepth:            defaultFinalityDepth,
+		useBlockTags:             true,
+		balanceReconcileInterval: defaultBalanceReconcileInterval,
+		balanceDriftHook:         logBalanceDrift,
+		balanceSeeds:             make(chan string, balanceSeedQueueSize),
+		backfillCancels:          make(map[int64]context.CancelCauseFunc),
+		backfillSlot:             make(chan struct{}, 1),
+		storage:                  store,
+		notifier:                 notifier.NewConsoleNotifier(),
+	}
+
+	for _, token := range DefaultTokens {
+		indexer.tokens[token.Contract] = token
+	}
+
+	for _, opt := range opts {
+		opt(indexer)
+	}
+
+	indexer.pool = rpc.NewPool(indexer.endpoints, httpclient.WithStateHook(indexer.circuitStateHook))
+	indexer.client = rpc.NewEthClientWithTransport(indexer.pool, rpc.WithMethodCosts(indexer.methodCosts))
+
+	// The next block is checked against the checkpoint hash,
+	// a reorganization happened while the indexer was down is detected as well.
+	if checkpoint != nil && checkpoint.BlockHash != "" {
+		indexer.recentBlocks.push(checkpoint.BlockNumber, checkpoint.BlockHash)
 	}
 
-	return &EthIndexer{
-		ctx:                 ctx,
-		ticker:              time.NewTicker(blockTime * time.Second),
-		client:              rpc.NewEthClient(endpoint),
-		currentIndexedBlock: currentIndexedBlock,
-		storage:             storage,
-	}, nil
+	// Recheck the recent blocks before the starting block, their transactions may not be finalized yet.
+	indexer.finalizedBlock = maxBig(big.NewInt(0), new(big.Int).Sub(currentIndexedBlock, new(big.Int).SetUint64(indexer.finalityDepth)))
+	indexer.confirmedBlock = new(big.Int).Set(indexer.finalizedBlock)
+
+	return indexer, nil
 }
 
 func (i *EthIndexer) Start() {
@@ -59,74 +171,263 @@ func (i *EthIndexer) Start() {
 		i.wg.Add(1)
 		go func() {
 			defer i.wg.Done()
+			i.pool.Run(i.ctx)
+		}()
+
+		if i.heads != nil {
+			i.wg.Add(1)
+			go func() {
+				defer i.wg.Done()
+				i.heads.Run(i.ctx)
+			}()
+		}
+
+		i.wg.Add(1)
+		go func() {
+			defer i.wg.Done()
+			i.reconcileBalances()
+		}()
+
+		i.wg.Add(1)
+		go func() {
+			defer i.wg.Done()
+
+			// consecutive failures, the retries back off exponentially
+			failures := 0
 			for {
 				select {
 				case <-i.ctx.Done():
-					i.Stop()
 					return
 				default:
-					i.ticker.Stop()
-					latestRawBlock, err := i.client.GetLatestBlock()
-
+					latestBlockNumber, err := i.client.GetBlockNumber(i.ctx)
 					if err != nil {
-						fmt.Printf("failed to get latest block: %v. Retry in %ds...\n", err, retryTime)
+						delay := retryDelay(err, failures)
+						failures++
+						if errors.Is(err, rpc.ErrMethodNotSupported) {
+							fmt.Printf("node does not support a method required to index the blocks\n")
+						}
+						fmt.Printf("failed to get latest block number: %v. Retry in %v...\n", err, delay)
 
-						// In case of error, node is not reachable, wait for a block time before retrying
-						time.Sleep(retryTime * time.Second)
+						// In case of error, node is not reachable or rate limited, wait before retrying
+						i.sleep(delay)
 						continue
 					}
 
-					latestBlockNumber, err := hexencoder.HexToDecimal(latestRawBlock.Number)
-					if err != nil {
-						fmt.Printf("failed to parse latest block number %s: %v. Retry in %ds...\n", latestRawBlock.Number, err, retryTime)
+					if i.GetCurrentBlock().Cmp(latestBlockNumber) < 0 {
+						if err := i.indexBlocks(latestBlockNumber); err != nil {
+							delay := retryDelay(err, failures)
+							failures++
+							if errors.Is(err, rpc.ErrMethodNotSupported) {
+								fmt.Printf("node does not support a method required to index the blocks\n")
+							}
+							fmt.Printf("%v. Retry in %v...\n", err, delay)
 
-						// In case of error, wait for a block time before retrying
-						// node does return gibberish data when it is faulty sometime
-						time.Sleep(retryTime * time.Second)
-						continue
+							i.sleep(delay)
+							continue
+						}
 					}
 
-					if i.currentIndexedBlock.Cmp(latestBlockNumber) < 0 {
-						currentBlockNumber := i.currentIndexedBlock.Add(i.currentIndexedBlock, big.NewInt(1))
+					failures = 0
+					i.waitNextBlock()
+				}
+			}
+		}()
 
-						currentRawBlock, err := i.client.GetBlockByNumber(currentBlockNumber)
-						if err != nil {
-							fmt.Printf("failed to get block by number: %v. Retry in %ds...\n", err, retryTime)
+	})
+}
 
-							// In case of error, wait for a block time before retrying
-							time.Sleep(retryTime * time.Second)
-							continue
-						}
+// logCircuitState logs the changes of the circuit breaker state of the endpoints.
+func logCircuitState(url string, from httpclient.CircuitState, to httpclient.CircuitState) {
+	fmt.Printf("endpoint %s circuit breaker %s -> %s\n", url, from, to)
+}
 
-						// IMPROVE: use worker pool to speed up the parsing and saving of transactions
-						// for hectic network like TRON with 3s block time, txs hit ~2000 per block at peak
-						// the indexer would not be able to keep up with the network if parsing txs sequentially
-						// or in case the server to crash, the inderxer can catch up quickly when server comes back up
-						for _, rawTx := range currentRawBlock.Transactions {
-							tx, err := i.ParseTransaction(rawTx)
-							if err != nil {
-								fmt.Printf("failed to parse transaction: %v\n", err)
-								continue
-							}
-							err = i.SaveSubscibedAddressTransaction(tx)
-							if err != nil {
-								fmt.Printf("failed to save subscribed address transaction: %v\n", err)
-								continue
-							}
-						}
+// sleep waits for the delay, or until the indexer is stopped.
+func (i *EthIndexer) sleep(delay time.Duration) {
+	sleep(i.ctx, delay)
+}
 
-						i.currentIndexedBlock = currentBlockNumber
+// sleep waits for the delay, or until the context is done.
+func sleep(ctx context.Context, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 
-						// fmt.Printf("processed block %s\n", currentBlockNumber.String())
-					}
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
 
-					i.ticker.Reset(blockTime * time.Second)
+// waitNextBlock waits for the next head of the subscription,
+// or polls the latest block number every poll interval while the subscription is down.
+func (i *EthIndexer) waitNextBlock() {
+	var heads <-chan *rpc.RawBlockHeader
+	if i.heads != nil {
+		heads = i.heads.Heads()
+	}
 
-				}
+	i.ticker.Reset(i.pollInterval)
+	defer i.ticker.Stop()
+
+	for {
+		select {
+		case <-i.ctx.Done():
+			return
+		case <-heads:
+			return
+		case <-i.ticker.C:
+			if i.heads == nil || !i.heads.Connected() {
+				return
 			}
-		}()
+		}
+	}
+}
 
-	})
+// retryDelay returns how long to wait before retrying after the failure, by its kind:
+// a node behind catches up within a block, a rate limited call waits as long as the provider asks,
+// the other failures back off exponentially with the number of consecutive failures.
+func retryDelay(err error, failures int) time.Duration {
+	switch {
+	case errors.Is(err, rpc.ErrRateLimited):
+		if retryAfter := rpc.RetryAfter(err); retryAfter > 0 {
+			return retryAfter
+		}
+		return rateLimitedRetryTime * time.Second
+	case errors.Is(err, rpc.ErrNodeBehind), errors.Is(err, rpc.ErrNotFound):
+		return nodeBehindRetryTime * time.Second
+	case errors.Is(err, rpc.ErrMethodNotSupported):
+		// a required method, retrying soon does not help until the node is reconfigured or replaced
+		return retryTime * time.Second
+	}
+
+	return minRetryTime*time.Second + httpclient.Backoff(failures, minRetryTime*time.Second, maxRetryTime*time.Second)
+}
+
+// indexBlocks indexes the blocks after the current indexed block up to toBlockNumber.
+// The blocks are fetched ahead by the pipeline workers and committed one by one in strict block order.
+// In case the chain has been reorganized since the current indexed block, the dropped blocks are rolled back
+// and it returns early, the canonical blocks get indexed in the following calls.
+func (i *EthIndexer) indexBlocks(toBlockNumber *big.Int) error {
+	ctx, cancel := context.WithCancel(i.ctx)
+	// stop fetching ahead when returning early
+	defer cancel()
+
+	fromBlockNumber := new(big.Int).Add(i.GetCurrentBlock(), big.NewInt(1))
+	for slot := range i.prefetchBlocks(ctx, fromBlockNumber, toBlockNumber) {
+		var block *fetchedBlock
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case block = <-slot:
+		}
+
+		if block.err != nil {
+			return block.err
+		}
+
+		i.commitLock.Lock()
+		rolledBack, err := i.commitBlock(block)
+		if err == nil && !rolledBack {
+			i.updateConfirmations()
+		}
+		i.commitLock.Unlock()
+
+		if err != nil || rolledBack {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commitBlock saves the transactions of subscribed addresses of the block following the current indexed block.
+// In case the block is not built on top of the current indexed block, the dropped blocks are rolled back instead.
+func (i *EthIndexer) commitBlock(block *fetchedBlock) (bool, error) {
+	currentBlockNumber := i.GetCurrentBlock()
+
+	// The next block must be built on top of the current indexed block,
+	// otherwise the current indexed block is no longer part of the canonical chain.
+	if parentHash, ok := i.recentBlocks.hash(currentBlockNumber); ok && parentHash != block.parentHash {
+		fmt.Printf("chain reorganization detected at block %s\n", block.number)
+		return true, i.rollback()
+	}
+
+	// The subscriptions are checked at commit time, the block could be fetched before an address is subscribed.
+	addressTxs := []*m.AddressTransaction{}
+	for _, tx := range block.txs {
+		addressTxs = append(addressTxs, i.subscribedAddressTransactions(tx)...)
+	}
+
+	// The transactions and the checkpoint are committed together,
+	// a crash never skips nor double processes the block.
+	checkpoint := &m.Checkpoint{BlockNumber: block.number, BlockHash: block.hash}
+	if err := i.storage.CommitBlock(checkpoint, addressTxs); err != nil {
+		return false, fmt.Errorf("failed to commit block %s: %w", block.number, err)
+	}
+
+	for _, addressTx := range addressTxs {
+		i.notify(m.EventTransactionIndexed, addressTx.Address, addressTx.Transaction)
+	}
+
+	i.recentBlocks.push(block.number, block.hash)
+	i.setCurrentBlock(block.number)
+
+	// fmt.Printf("processed block %s\n", block.number.String())
+
+	return false, nil
+}
+
+// rollback walks back the recent blocks to find the common ancestor with the canonical chain,
+// removes the transactions saved from the dropped blocks and notifies the subscribers about them.
+// The indexer then continues from the common ancestor to index the canonical blocks.
+func (i *EthIndexer) rollback() error {
+	currentBlockNumber := i.GetCurrentBlock()
+	oldestBlockNumber := i.recentBlocks.oldest()
+	if oldestBlockNumber == nil {
+		return nil
+	}
+
+	// In case none of the recent blocks is canonical, the reorganization is deeper than the window.
+	// Roll back the whole window, the transactions of blocks before it can not be checked anymore.
+	commonAncestor := new(big.Int).Sub(oldestBlockNumber, big.NewInt(1))
+	for blockNumber := new(big.Int).Set(currentBlockNumber); blockNumber.Cmp(oldestBlockNumber) >= 0; blockNumber.Sub(blockNumber, big.NewInt(1)) {
+		header, err := i.client.GetBlockHeaderByNumber(i.ctx, blockNumber)
+		if err != nil {
+			return fmt.Errorf("failed to get block header by number: %w", err)
+		}
+
+		if hash, _ := i.recentBlocks.hash(blockNumber); hash == header.Hash {
+			commonAncestor.Set(blockNumber)
+			break
+		}
+	}
+
+	if commonAncestor.Cmp(oldestBlockNumber) < 0 {
+		fmt.Printf("chain reorganization is deeper than %d blocks, rolling back to block %s\n", i.recentBlocks.size, commonAncestor)
+	}
+
+	// Roll back one block at a time, so a failure leaves the indexer at a consistent block to retry from.
+	for blockNumber := new(big.Int).Set(currentBlockNumber); blockNumber.Cmp(commonAncestor) > 0; blockNumber.Sub(blockNumber, big.NewInt(1)) {
+		// The parent hash is unknown when rolling back beyond the window,
+		// the checkpoint then has no hash and the next block is not checked against it.
+		parentBlockNumber := new(big.Int).Sub(blockNumber, big.NewInt(1))
+		parentHash, _ := i.recentBlocks.hash(parentBlockNumber)
+		removed, err := i.storage.RollbackBlock(blockNumber, &m.Checkpoint{BlockNumber: parentBlockNumber, BlockHash: parentHash})
+		if err != nil {
+			return fmt.Errorf("failed to roll back block %s: %w", blockNumber, err)
+		}
+
+		for _, addressTx := range removed {
+			i.notify(m.EventTransactionReorged, addressTx.Address, addressTx.Transaction)
+		}
+
+		i.recentBlocks.truncate(parentBlockNumber)
+		i.resetConfirmations(parentBlockNumber)
+		i.setCurrentBlock(parentBlockNumber)
+	}
+
+	fmt.Printf("rolled back to block %s\n", commonAncestor)
+
+	return nil
 }
 
 func (i *EthIndexer) ParseTransaction(rawTxn *rpc.RawTransaction) (*m.Transaction, error) {
@@ -137,7 +438,10 @@ func (i *EthIndexer) ParseTransaction(rawTxn *rpc.RawTransaction) (*m.Transactio
 	// parse raw transaction to transaction for internal use
 	tx.Hash = rawTxn.Hash
 
-	if tx.Type, err = hexencoder.HexToDecimal(rawTxn.Type); err != nil {
+	// transactions before Berlin are all legacy, some nodes leave the type out for them
+	if rawTxn.Type == "" {
+		tx.Type = big.NewInt(legacyTxType)
+	} else if tx.Type, err = hexencoder.HexToDecimal(rawTxn.Type); err != nil {
 		return nil, fmt.Errorf("failed to parse type: %w", err)
 	}
 
@@ -145,6 +449,13 @@ func (i *EthIndexer) ParseTransaction(rawTxn *rpc.RawTransaction) (*m.Transactio
 		return nil, fmt.Errorf("failed to parse block number: %w", err)
 	}
 	tx.BlockHash = rawTxn.BlockHash
+
+	// pending transactions have no position yet
+	if rawTxn.TransactionIndex != "" {
+		if tx.TransactionIndex, err = hexencoder.HexToDecimal(rawTxn.TransactionIndex); err != nil {
+			return nil, fmt.Errorf("failed to parse transaction index: %w", err)
+		}
+	}
 	tx.From = rawTxn.From
 	tx.To = rawTxn.To
 
@@ -161,11 +472,9 @@ func (i *EthIndexer) ParseTransaction(rawTxn *rpc.RawTransaction) (*m.Transactio
 		Value:  tx.Value,
 	})
 
+	// Contract calls could transfer value internally,
+	// the internal transfers are added from the block traces, see fetchInternalTransfers
 	tx.Input = rawTxn.Input
-	if len(tx.Input) > 2 {
-		// TODO: parse internal transactions using trace_transaction
-		// However, the node api provided by cloudflare does not support this method
-	}
 
 	if tx.ChainId, err = hexencoder.HexToDecimal(rawTxn.ChainId); err != nil {
 		// default chain id to mainnet for now
@@ -181,56 +490,128 @@ func (i *EthIndexer) ParseTransaction(rawTxn *rpc.RawTransaction) (*m.Transactio
 		return nil, fmt.Errorf("failed to parse gas: %w", err)
 	}
 
-	if tx.GasPrice, err = hexencoder.HexToDecimal(rawTxn.GasPrice); err != nil {
-		return nil, fmt.Errorf("failed to parse gas price: %w", err)
+	if err := parseTypedFields(tx, rawTxn); err != nil {
+		return nil, err
 	}
 
 	tx.Transfers = transfers
 
+	// New transaction has no confirmations yet, the state moves forward as the indexer moves ahead
+	tx.State = m.TransactionStatePending
+
 	return tx, nil
 }
 
-// Check if the transaction contains subscribed address
-// then save it to the database
-func (i *EthIndexer) SaveSubscibedAddressTransaction(tx *m.Transaction) error {
-	for _, transfer := range tx.Transfers {
-		if i.storage.IsSubscribedAddress(transfer.From) {
-			if err := i.storage.AddAddressTransaction(transfer.From, tx); err != nil {
-				fmt.Printf("failed to save transaction subscribed address %s : %v", transfer.From, err)
-			} else {
-				fmt.Printf("saved transaction for subscribed address: %s hash: %s\n", transfer.From, tx.Hash)
-			}
+// subscribedAddressTransactions returns the transaction once for each subscribed address it transfers from or to.
+// The sender is always included for the fee it paid, even when the transaction failed and transferred nothing.
+func (i *EthIndexer) subscribedAddressTransactions(tx *m.Transaction) []*m.AddressTransaction {
+	addressTxs := []*m.AddressTransaction{}
+	seen := make(map[string]bool)
+	for _, address := range transactionAddresses(tx) {
+		if seen[address] || !i.storage.IsSubscribedAddress(address) {
+			continue
 		}
+		seen[address] = true
 
-		if i.storage.IsSubscribedAddress(transfer.To) {
-			if err := i.storage.AddAddressTransaction(transfer.To, tx); err != nil {
-				fmt.Printf("failed to save transaction subscribed address %s : %+v", transfer.To, err)
-			} else {
-				fmt.Printf("saved transaction for subscribed address: %s hash: %s\n", transfer.To, tx.Hash)
-			}
-		}
+		addressTxs = append(addressTxs, &m.AddressTransaction{
+			Address:        address,
+			Transaction:    tx,
+			BalanceChanges: tx.BalanceChanges(address),
+		})
+	}
 
-		// call webhook to notify transaction of the subscribed address here
-		// create a message, send to the the notification queue, call the webhook
+	return addressTxs
+}
 
+// transactionAddresses returns the sender of the transaction and the addresses of its transfers, with duplicates.
+func transactionAddresses(tx *m.Transaction) []string {
+	addresses := []string{tx.From}
+	for _, transfer := range tx.Transfers {
+		addresses = append(addresses, transfer.From, transfer.To)
 	}
 
-	return nil
+	return addresses
+}
+
+func (i *EthIndexer) notify(eventType m.EventType, address string, tx *m.Transaction) {
+	if err := i.notifier.Notify(&m.Event{Type: eventType, Address: address, Transaction: tx}); err != nil {
+		fmt.Printf("failed to notify %s for address %s hash %s: %v\n", eventType, address, tx.Hash, err)
+	}
+}
+
+// EndpointStats returns the statistics of the node endpoints, to find out which provider is misbehaving.
+func (i *EthIndexer) EndpointStats() []rpc.EndpointStats {
+	return i.pool.Stats()
 }
 
 func (i *EthIndexer) GetCurrentBlock() *big.Int {
-	return i.currentIndexedBlock
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	return new(big.Int).Set(i.currentIndexedBlock)
 }
 
-func (i *EthIndexer) GetTransactions(address string) ([]*m.Transaction, error) {
-	return i.storage.GetTransactionsByAddress(address)
+func (i *EthIndexer) setCurrentBlock(blockNumber *big.Int) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.currentIndexedBlock = new(big.Int).Set(blockNumber)
 }
 
+// GetTransactions returns the transactions of the address,
+// only the transactions in one of the given states when any state is given.
+func (i *EthIndexer) GetTransactions(address string, states ...m.TransactionState) ([]*m.Transaction, error) {
+	txns, err := i.storage.GetTransactionsByAddress(address)
+	if err != nil || len(states) == 0 {
+		return txns, err
+	}
+
+	filtered := []*m.Transaction{}
+	for _, tx := range txns {
+		for _, state := range states {
+			if tx.State == state {
+				filtered = append(filtered, tx)
+				break
+			}
+		}
+	}
+
+	return filtered, nil
+}
+
+// GetTransaction returns the indexed transaction of the hash, storage.ErrTransactionNotFound if none.
+func (i *EthIndexer) GetTransaction(hash string) (*m.Transaction, error) {
+	return i.storage.GetTransaction(hash)
+}
+
+// QueryTransactions returns a page of the transactions of the address matching the query,
+// the next page is queried with the NextCursor of the page.
+func (i *EthIndexer) QueryTransactions(query *m.TransactionQuery) (*m.TransactionPage, error) {
+	if err := query.Validate(); err != nil {
+		return nil, err
+	}
+
+	return i.storage.QueryTransactions(query)
+}
+
+// SubscribeAddress adds the address to observe, its balances are seeded from the node in the background.
 func (i *EthIndexer) SubscribeAddress(address string) error {
-	return i.storage.SubscribeAddress(address)
+	if err := i.storage.SubscribeAddress(address); err != nil {
+		return err
+	}
+
+	// seeded on the next reconciliation otherwise
+	select {
+	case i.balanceSeeds <- address:
+	default:
+	}
+
+	return nil
 }
 
+// Stop stops the indexer, interrupting any call in flight, and waits for it to return.
 func (i *EthIndexer) Stop() {
-	i.ticker.Stop()
+	i.cancel()
 	i.wg.Wait()
+	i.ticker.Stop()
 }
@@ -2,10 +2,20 @@ package eth_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/hoangan/superwallet/internal/eth"
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/notifier"
 	"github.com/hoangan/superwallet/internal/storage/inmemorystorage"
 	"github.com/hoangan/superwallet/internal/testdata"
 )
@@ -57,6 +67,14 @@ func TestEthIndexer(t *testing.T) {
 			t.Errorf("failed to parse transaction gas price: %s", txn.GasPrice)
 		}
 
+		if txn.MaxFeePerGas.Cmp(testdata.Transaction1.MaxFeePerGas) != 0 {
+			t.Errorf("failed to parse transaction max fee per gas: %s", txn.MaxFeePerGas)
+		}
+
+		if txn.MaxPriorityFeePerGas.Cmp(testdata.Transaction1.MaxPriorityFeePerGas) != 0 {
+			t.Errorf("failed to parse transaction max priority fee per gas: %s", txn.MaxPriorityFeePerGas)
+		}
+
 		if txn.From != testdata.Transaction1.From {
 			t.Errorf("failed to parse transaction from: %s", txn.From)
 		}
@@ -96,3 +114,965 @@ func TestEthIndexer(t *testing.T) {
 		}
 	})
 }
+
+func TestParseTypedTransaction(t *testing.T) {
+	storage, _ := inmemorystorage.New()
+	ethIndexer, _ := eth.NewIndexer(context.Background(), ethEndpoint, storage, big.NewInt(fromBlockNumber))
+
+	txn, err := ethIndexer.ParseTransaction(testdata.RawBlobTransaction1)
+	if err != nil {
+		t.Fatalf("failed to parse blob transaction: %v", err)
+	}
+
+	if txn.GasPrice != nil {
+		t.Errorf("failed to parse blob transaction without gas price: %s", txn.GasPrice)
+	}
+
+	if txn.MaxFeePerGas.String() != "10000000000" || txn.MaxPriorityFeePerGas.String() != "1000000000" {
+		t.Errorf("failed to parse blob transaction fees: %s %s", txn.MaxFeePerGas, txn.MaxPriorityFeePerGas)
+	}
+
+	if txn.MaxFeePerBlobGas.String() != "1000000000" || len(txn.BlobVersionedHashes) != 2 {
+		t.Errorf("failed to parse blob fields: %s %v", txn.MaxFeePerBlobGas, txn.BlobVersionedHashes)
+	}
+
+	if len(txn.AccessList) != 1 || len(txn.AccessList[0].StorageKeys) != 1 {
+		t.Errorf("failed to parse access list: %v", txn.AccessList)
+	}
+
+	// every type requires its own fields
+	missingFee := *testdata.RawBlobTransaction1
+	missingFee.MaxFeePerGas = ""
+	if _, err := ethIndexer.ParseTransaction(&missingFee); err == nil {
+		t.Errorf("failed to reject blob transaction without max fee per gas")
+	}
+}
+
+func TestParseTransferLog(t *testing.T) {
+	storage, _ := inmemorystorage.New()
+	ethIndexer, _ := eth.NewIndexer(context.Background(), ethEndpoint, storage, big.NewInt(fromBlockNumber))
+
+	transfer, err := ethIndexer.ParseTransferLog(testdata.RawTransferLog1)
+	if err != nil {
+		t.Fatalf("failed to parse transfer log: %v", err)
+	}
+
+	expected := testdata.TransferLog1Transfer
+	if transfer.CoinID != expected.CoinID || transfer.Ticker != expected.Ticker {
+		t.Errorf("failed to resolve transfer token: %d %s", transfer.CoinID, transfer.Ticker)
+	}
+
+	if transfer.From != expected.From || transfer.To != expected.To {
+		t.Errorf("failed to parse transfer addresses: %s -> %s", transfer.From, transfer.To)
+	}
+
+	if transfer.Value.Cmp(expected.Value) != 0 || transfer.LogIndex.Cmp(expected.LogIndex) != 0 {
+		t.Errorf("failed to parse transfer value: %s log index: %s", transfer.Value, transfer.LogIndex)
+	}
+
+	if transfer.Token != expected.Token {
+		t.Errorf("failed to parse transfer token: %s", transfer.Token)
+	}
+}
+
+func TestEthIndexerTokenTransfer(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	// contract call without value, the tokens are transferred by the event only
+	contractCall := testdata.NewRawTransaction(testdata.RawTransferLog1.TransactionHash, "0x28c6c06298d514db089934071355e5743bf21d60", "0xdac17f958d2ee523a2206206994597c13d831ec7", 0)
+	node.SetBlock(testdata.NewRawBlock(1, testdata.RawTransferLog1.BlockHash, "0xa0", contractCall))
+	node.AddLogs(testdata.RawTransferLog1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 1)
+
+	txns, err := ethIndexer.GetTransactions(address)
+	if err != nil || len(txns) != 1 {
+		t.Fatalf("failed to index token transfer: %v, txs count: %d", err, len(txns))
+	}
+
+	if len(txns[0].Transfers) != 2 || txns[0].Transfers[1].Ticker != "USDT" {
+		t.Errorf("failed to index token transfer: %v", txns[0].Transfers)
+	}
+}
+
+func TestParseParityTraces(t *testing.T) {
+	storage, _ := inmemorystorage.New()
+	ethIndexer, _ := eth.NewIndexer(context.Background(), ethEndpoint, storage, big.NewInt(fromBlockNumber))
+
+	newTrace := func(traceAddress []int, from string, to string, value string, errMessage string) *rpc.RawTrace {
+		trace := &rpc.RawTrace{Type: "call", TransactionHash: "0xt1", TraceAddress: traceAddress, Error: errMessage}
+		trace.Action.CallType = "call"
+		trace.Action.From = from
+		trace.Action.To = to
+		trace.Action.Value = value
+		return trace
+	}
+
+	traces := []*rpc.RawTrace{
+		newTrace([]int{}, "0xsender", "0xmultisig", "0x0", ""),
+		newTrace([]int{0}, "0xmultisig", "0xreceiver", "0xde0b6b3a7640000", ""),
+		newTrace([]int{1}, "0xmultisig", "0xrouter", "0x1", "Reverted"),
+		newTrace([]int{1, 0}, "0xrouter", "0xreceiver", "0x2", ""),
+	}
+
+	transfers, err := ethIndexer.ParseParityTraces(traces)
+	if err != nil {
+		t.Fatalf("failed to parse parity traces: %v", err)
+	}
+
+	if len(transfers["0xt1"]) != 1 {
+		t.Fatalf("failed to skip top level and reverted traces: %v", transfers["0xt1"])
+	}
+
+	transfer := transfers["0xt1"][0]
+	if !transfer.Internal || transfer.From != "0xmultisig" || transfer.To != "0xreceiver" || transfer.Value.String() != "1000000000000000000" {
+		t.Errorf("failed to parse internal transfer: %+v", transfer)
+	}
+}
+
+func TestEthIndexerInternalTransfer(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+	multisig := "0x8fa4f4a1ee5cf8b1bc0e2fe5ea5a13f2e5f6d6b1"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0", testdata.NewRawTransaction("0xt1", sender, multisig, 0)))
+	node.SetCallTraces(1, &rpc.RawTransactionTrace{
+		TxHash: "0xt1",
+		Result: &rpc.RawCallFrame{
+			Type: "CALL", From: sender, To: multisig, Value: "0x0",
+			Calls: []*rpc.RawCallFrame{
+				{Type: "DELEGATECALL", From: multisig, To: "0x34cfac646f301356faa8b21e94227e3583fe3f5f", Value: "0x0",
+					Calls: []*rpc.RawCallFrame{
+						{Type: "CALL", From: multisig, To: address, Value: "0x64"},
+					}},
+				{Type: "CALL", From: multisig, To: address, Value: "0xc8", Error: "execution reverted"},
+			},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 1)
+
+	txns, err := ethIndexer.GetTransactions(address)
+	if err != nil || len(txns) != 1 {
+		t.Fatalf("failed to index internal transfer: %v, txs count: %d", err, len(txns))
+	}
+
+	if len(txns[0].Transfers) != 2 || !txns[0].Transfers[1].Internal || txns[0].Transfers[1].Value.Int64() != 100 {
+		t.Errorf("failed to index internal transfer: %v", txns[0].Transfers)
+	}
+}
+
+func TestEthIndexerFailedTransaction(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	// receipts are fetched one by one when the node does not support block receipts
+	node.DisableBlockReceipts()
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0", testdata.NewRawTransaction("0xt1", sender, address, 100)))
+	node.SetReceipt(&rpc.RawReceipt{
+		TransactionHash:   "0xt1",
+		Status:            "0x0",
+		GasUsed:           "0x5208",
+		CumulativeGasUsed: "0x5208",
+		EffectiveGasPrice: "0x3b9aca00",
+		Logs:              []*rpc.RawLog{},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	_ = storage.SubscribeAddress(sender)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 1)
+
+	// the receiver got nothing from the failed transaction
+	if txns, err := ethIndexer.GetTransactions(address); err != nil || len(txns) != 0 {
+		t.Errorf("failed to exclude failed transaction: %v, txs count: %d", err, len(txns))
+	}
+
+	// the sender paid the fee
+	txns, err := ethIndexer.GetTransactions(sender)
+	if err != nil || len(txns) != 1 {
+		t.Fatalf("failed to record failed transaction for the sender: %v, txs count: %d", err, len(txns))
+	}
+
+	if !txns[0].Failed() || len(txns[0].Transfers) != 0 || txns[0].Fee.Cmp(big.NewInt(21000*1000000000)) != 0 {
+		t.Errorf("failed to parse failed transaction receipt: %+v", txns[0])
+	}
+}
+
+func TestEthIndexerPreByzantiumReceipt(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0",
+		testdata.NewRawTransaction("0xt1", sender, address, 100),
+		testdata.NewRawTransaction("0xt2", sender, address, 200)))
+	// the receipts before Byzantium have the state root instead of the status
+	node.SetReceipt(&rpc.RawReceipt{
+		TransactionHash:   "0xt1",
+		Root:              "0x5a8cd4b4a2a6ba9e3d1ee1fb9e06e77e68d95e4cd27e1c26bd4b7b3ce1d5a8c4",
+		GasUsed:           "0x5208",
+		CumulativeGasUsed: "0x5208",
+		Logs:              []*rpc.RawLog{},
+	})
+	// a receipt failing to parse fails the whole block until the node serves it right
+	node.SetReceipt(&rpc.RawReceipt{
+		TransactionHash: "0xt2",
+		Status:          "0x1",
+		Logs:            []*rpc.RawLog{},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1), eth.WithPollInterval(10*time.Millisecond))
+	ethIndexer.Start()
+
+	time.Sleep(100 * time.Millisecond)
+	if ethIndexer.GetCurrentBlock().Int64() != 0 {
+		t.Fatalf("failed to hold the block with a receipt failing to parse, indexed up to block %s", ethIndexer.GetCurrentBlock())
+	}
+
+	node.SetReceipt(&rpc.RawReceipt{
+		TransactionHash:   "0xt2",
+		Status:            "0x1",
+		GasUsed:           "0x5208",
+		CumulativeGasUsed: "0xa410",
+		Logs:              []*rpc.RawLog{},
+	})
+	waitForBlock(t, ethIndexer, 1)
+
+	txns, err := ethIndexer.GetTransactions(address)
+	if err != nil || len(txns) != 2 {
+		t.Fatalf("failed to index the transactions of the block: %v, txs count: %d", err, len(txns))
+	}
+
+	for _, txn := range txns {
+		if txn.Failed() || len(txn.Transfers) != 1 {
+			t.Errorf("failed to parse receipt of transaction %s, status: %s, transfers: %v", txn.Hash, txn.Status, txn.Transfers)
+		}
+	}
+}
+
+// recordingNotifier keeps the notified events for assertions.
+type recordingNotifier struct {
+	lock   sync.Mutex
+	events []*m.Event
+}
+
+func (n *recordingNotifier) Notify(event *m.Event) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.events = append(n.events, event)
+	return nil
+}
+
+func (n *recordingNotifier) count(eventType m.EventType) int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	count := 0
+	for _, event := range n.events {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+// waitFor waits until the condition is met by the indexer running in background.
+func waitFor(t *testing.T, condition func() bool, format string, args ...interface{}) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForBlock waits until the indexer reaches the block number.
+func waitForBlock(t *testing.T, indexer *eth.EthIndexer, blockNumber int64) {
+	t.Helper()
+
+	waitFor(t, func() bool {
+		return indexer.GetCurrentBlock().Cmp(big.NewInt(blockNumber)) == 0
+	}, "indexer did not reach block %d", blockNumber)
+}
+
+// waitForTransactions waits until the address has the number of transactions in the state.
+func waitForTransactions(t *testing.T, indexer *eth.EthIndexer, address string, state m.TransactionState, count int) {
+	t.Helper()
+
+	waitFor(t, func() bool {
+		txns, err := indexer.GetTransactions(address, state)
+		return err == nil && len(txns) == count
+	}, "address %s does not have %d %s transactions", address, count, state)
+}
+
+func TestEthIndexerReorg(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0"))
+	node.SetBlock(testdata.NewRawBlock(2, "0xa2", "0xa1", testdata.NewRawTransaction("0xt1", sender, address, 100)))
+	node.SetBlock(testdata.NewRawBlock(3, "0xa3", "0xa2"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notifier := &recordingNotifier{}
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1), eth.WithNotifier(notifier), eth.WithPollInterval(10*time.Millisecond))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 3)
+
+	txns, err := ethIndexer.GetTransactions(address)
+	if err != nil || len(txns) != 1 {
+		t.Fatalf("failed to index transaction before reorg: %v, txs count: %d", err, len(txns))
+	}
+
+	// block 2 and 3 are replaced by a fork without the transaction
+	node.SetBlock(testdata.NewRawBlock(2, "0xb2", "0xa1"))
+	node.SetBlock(testdata.NewRawBlock(3, "0xb3", "0xb2"))
+	node.SetBlock(testdata.NewRawBlock(4, "0xb4", "0xb3"))
+
+	waitForBlock(t, ethIndexer, 4)
+
+	txns, err = ethIndexer.GetTransactions(address)
+	if err != nil || len(txns) != 0 {
+		t.Errorf("failed to roll back reorged transaction: %v, txs count: %d", err, len(txns))
+	}
+
+	if count := notifier.count(m.EventTransactionReorged); count != 1 {
+		t.Errorf("failed to notify reorged transaction, events count: %d", count)
+	}
+}
+
+func TestEthIndexerConfirmations(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0", testdata.NewRawTransaction("0xt1", sender, address, 100)))
+	node.SetBlock(testdata.NewRawBlock(2, "0xa2", "0xa1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notifier := &recordingNotifier{}
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1),
+		eth.WithNotifier(notifier), eth.WithConfirmations(2), eth.WithFinalityDepth(3), eth.WithPollInterval(10*time.Millisecond))
+	ethIndexer.Start()
+
+	waitForTransactions(t, ethIndexer, address, m.TransactionStateConfirmed, 1)
+
+	node.SetBlock(testdata.NewRawBlock(3, "0xa3", "0xa2"))
+	waitForTransactions(t, ethIndexer, address, m.TransactionStateFinalized, 1)
+
+	if notifier.count(m.EventTransactionConfirmed) != 1 || notifier.count(m.EventTransactionFinalized) != 1 {
+		t.Errorf("failed to notify transaction confirmations: %v", notifier.events)
+	}
+}
+
+func TestEthIndexerWithoutBlockTags(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	// the node answers no safe and finalized blocks, as a pre-merge node
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0", testdata.NewRawTransaction("0xt1", sender, address, 100)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1),
+		eth.WithConfirmations(2), eth.WithFinalityDepth(3), eth.WithPollInterval(10*time.Millisecond))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 1)
+
+	// the next blocks come after the block tags could have been refreshed
+	for number := int64(2); number <= 3; number++ {
+		time.Sleep(20 * time.Millisecond)
+		node.SetBlock(testdata.NewRawBlock(number, fmt.Sprintf("0xa%d", number), fmt.Sprintf("0xa%d", number-1)))
+		waitForBlock(t, ethIndexer, number)
+	}
+
+	// finalized by the finality depth
+	waitForTransactions(t, ethIndexer, address, m.TransactionStateFinalized, 1)
+
+	if requests := node.TagRequests("safe"); requests != 1 {
+		t.Errorf("failed to disable block tags, safe block requested %d times", requests)
+	}
+	if requests := node.TagRequests("finalized"); requests != 0 {
+		t.Errorf("failed to disable block tags, finalized block requested %d times", requests)
+	}
+}
+
+func TestEthIndexerResume(t *testing.T) {
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0"))
+	node.SetBlock(testdata.NewRawBlock(2, "0xa2", "0xa1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1))
+	ethIndexer.Start()
+	waitForBlock(t, ethIndexer, 2)
+
+	// restarted indexer resumes from the checkpoint
+	resumedIndexer, err := eth.NewIndexer(ctx, node.URL, storage, nil)
+	if err != nil {
+		t.Fatalf("failed to resume indexer: %v", err)
+	}
+
+	if resumedIndexer.GetCurrentBlock().Int64() != 2 {
+		t.Errorf("failed to resume from checkpoint, current block: %s", resumedIndexer.GetCurrentBlock())
+	}
+}
+
+func TestEthIndexerCatchUp(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	for number := int64(1); number <= 50; number++ {
+		var txs []*rpc.RawTransaction
+		if number%5 == 0 {
+			txs = append(txs, testdata.NewRawTransaction(fmt.Sprintf("0xt%d", number), sender, address, number))
+		}
+		node.SetBlock(testdata.NewRawBlock(number, fmt.Sprintf("0xa%d", number), fmt.Sprintf("0xa%d", number-1), txs...))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1), eth.WithWorkers(8), eth.WithPrefetchDepth(4))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 50)
+
+	txns, err := ethIndexer.GetTransactions(address)
+	if err != nil || len(txns) != 10 {
+		t.Fatalf("failed to index transactions while catching up: %v, txs count: %d", err, len(txns))
+	}
+
+	// blocks are committed in strict block order
+	for idx, txn := range txns {
+		if txn.BlockNumber.Int64() != int64(idx+1)*5 {
+			t.Errorf("failed to commit blocks in order, tx %d in block %s", idx, txn.BlockNumber)
+		}
+	}
+}
+
+func TestEthIndexerHeadsSubscription(t *testing.T) {
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1), eth.WithHeadsSubscription(node.WSURL()))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 1)
+	waitFor(t, func() bool { return node.Subscriptions() == 1 }, "failed to subscribe to new heads")
+
+	// the new head wakes up the indexer without waiting for the poll interval
+	node.SetBlock(testdata.NewRawBlock(2, "0xa2", "0xa1"))
+	waitForBlock(t, ethIndexer, 2)
+
+	// the subscription is restored once the connection drops
+	node.CloseSubscriptions()
+	waitFor(t, func() bool { return node.Subscriptions() == 1 }, "failed to subscribe again to new heads")
+
+	node.SetBlock(testdata.NewRawBlock(3, "0xa3", "0xa2"))
+	waitForBlock(t, ethIndexer, 3)
+}
+
+func TestEthIndexerStop(t *testing.T) {
+	// the node hangs until the request is cancelled
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the disconnection is only noticed once the body is read
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer node.Close()
+
+	storage, _ := inmemorystorage.New()
+	ethIndexer, _ := eth.NewIndexer(context.Background(), node.URL, storage, big.NewInt(1))
+	ethIndexer.Start()
+
+	// let the indexer call the node
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		ethIndexer.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("failed to stop the indexer while calling the node")
+	}
+}
+
+func TestEthIndexerBalances(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBalance(address, 0, big.NewInt(5000))
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	drifts := make(chan *eth.BalanceDrift, 10)
+	storage, _ := inmemorystorage.New()
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1), eth.WithPollInterval(10*time.Millisecond),
+		eth.WithBalanceReconcileInterval(20*time.Millisecond),
+		eth.WithBalanceDriftHook(func(drift *eth.BalanceDrift) { drifts <- drift }))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 1)
+	if err := ethIndexer.SubscribeAddress(address); err != nil {
+		t.Fatalf("failed to subscribe address: %v", err)
+	}
+
+	nativeBalance := func(expected int64) func() bool {
+		return func() bool {
+			balances, err := ethIndexer.GetBalances(address)
+			if err != nil {
+				return false
+			}
+			for _, balance := range balances {
+				if balance.CoinID == m.NativeCoinID {
+					return balance.Seeded && balance.Balance.Int64() == expected
+				}
+			}
+			return false
+		}
+	}
+
+	// seeded from the node
+	waitFor(t, nativeBalance(5000), "failed to seed the balance from the node")
+
+	// computed from the indexed transfer
+	node.SetBalance(address, 2, big.NewInt(5100))
+	node.SetBlock(testdata.NewRawBlock(2, "0xa2", "0xa1", testdata.NewRawTransaction("0xt1", sender, address, 100)))
+	waitFor(t, nativeBalance(5100), "failed to apply the balance change of the transfer")
+
+	select {
+	case drift := <-drifts:
+		t.Fatalf("failed to match the balance of the node, drifted: %+v", drift)
+	default:
+	}
+
+	// a transfer missed by the indexer
+	node.SetBalance(address, 2, big.NewInt(9999))
+	select {
+	case drift := <-drifts:
+		if drift.CoinID != m.NativeCoinID || drift.Computed.Int64() != 5100 || drift.Node.Int64() != 9999 {
+			t.Errorf("failed to report the balance drift, got %+v", drift)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("failed to report the balance drift")
+	}
+}
+
+func TestEthIndexerBackfill(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	// a deposit address receiving tokens in block 2, before any ether in block 3
+	holder := "0x00000000000000000000000000000000000000cc"
+	usdt := "0xdac17f958d2ee523a2206206994597c13d831ec7"
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0", testdata.NewRawTransaction("0xt1", sender, address, 100)))
+	node.SetBlock(testdata.NewRawBlock(2, "0xa2", "0xa1", testdata.NewRawTransaction("0xt2", sender, address, 200),
+		testdata.NewRawTransaction("0xt3", sender, usdt, 0)))
+	node.SetBlock(testdata.NewRawBlock(3, "0xa3", "0xa2"))
+	node.SetBalance(address, 1, big.NewInt(100))
+	node.SetBalance(holder, 3, big.NewInt(100))
+	node.AddLogs(&rpc.RawLog{
+		Address: usdt,
+		Topics: []string{
+			"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+			"0x0000000000000000000000004838b106fce9647bdf1e7877bf73ce8b0bad5f97",
+			"0x00000000000000000000000000000000000000000000000000000000000000cc",
+		},
+		Data:             "0x000000000000000000000000000000000000000000000000000000001dcd6500",
+		BlockNumber:      "0x2",
+		BlockHash:        "0xa2",
+		TransactionHash:  "0xt3",
+		TransactionIndex: "0x1",
+		LogIndex:         "0x0",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1), eth.WithPollInterval(10*time.Millisecond),
+		eth.WithConfirmations(2), eth.WithFinalityDepth(3))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 3)
+
+	waitForJob := func(id int64) *m.BackfillJob {
+		var job *m.BackfillJob
+		waitFor(t, func() bool {
+			for _, job = range ethIndexer.GetBackfillJobs() {
+				if job.ID == id {
+					return job.State == m.BackfillStateCompleted
+				}
+			}
+			return false
+		}, "backfill %d did not complete", id)
+		return job
+	}
+
+	t.Run("From Block", func(t *testing.T) {
+		job, err := ethIndexer.SubscribeAddressFrom(address, big.NewInt(2))
+		if err != nil {
+			t.Fatalf("failed to subscribe address: %v", err)
+		}
+		if job.ToBlock.Int64() != 3 {
+			t.Errorf("failed to backfill up to the current indexed block, got %s", job.ToBlock)
+		}
+
+		job = waitForJob(job.ID)
+		if job.Transactions != 1 || job.Progress() != 1 {
+			t.Errorf("failed to report the backfill progress, got %+v", job)
+		}
+
+		// the confirmation state of the block, block 2 has 2 confirmations
+		txns, err := ethIndexer.GetTransactions(address)
+		if err != nil || len(txns) != 1 || txns[0].Hash != "0xt2" || txns[0].State != m.TransactionStateConfirmed {
+			t.Errorf("failed to backfill transactions from block: %v, %v", txns, err)
+		}
+	})
+
+	t.Run("First Activity", func(t *testing.T) {
+		job, err := ethIndexer.SubscribeAddressFrom(address, nil)
+		if err != nil {
+			t.Fatalf("failed to subscribe address: %v", err)
+		}
+
+		job = waitForJob(job.ID)
+		if job.FromBlock == nil || job.FromBlock.Int64() != 1 || job.Transactions != 2 {
+			t.Errorf("failed to backfill from the first activity, got %+v", job)
+		}
+
+		if txns, err := ethIndexer.GetTransactions(address); err != nil || len(txns) != 2 {
+			t.Errorf("failed to backfill transactions from first activity: %v, %v", txns, err)
+		}
+	})
+
+	t.Run("First Token Receipt", func(t *testing.T) {
+		job, err := ethIndexer.SubscribeAddressFrom(holder, nil)
+		if err != nil {
+			t.Fatalf("failed to subscribe address: %v", err)
+		}
+
+		job = waitForJob(job.ID)
+		if job.FromBlock == nil || job.FromBlock.Int64() != 2 || job.Transactions != 1 {
+			t.Errorf("failed to backfill from the first token receipt, got %+v", job)
+		}
+
+		if txns, err := ethIndexer.GetTransactions(holder); err != nil || len(txns) != 1 || txns[0].Hash != "0xt3" {
+			t.Errorf("failed to backfill the token receipt: %v, %v", txns, err)
+		}
+	})
+
+	// indexed live once subscribed
+	node.SetBlock(testdata.NewRawBlock(4, "0xa4", "0xa3", testdata.NewRawTransaction("0xt4", sender, address, 400)))
+	waitFor(t, func() bool {
+		txns, err := ethIndexer.GetTransactions(address)
+		return err == nil && len(txns) == 3
+	}, "failed to index live transactions along with the backfilled ones")
+}
+
+func TestEthIndexerBackfillCanceled(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+
+	// the blocks before block 5 are not served, the backfill keeps retrying them
+	node := testdata.NewNode()
+	defer node.Close()
+	node.SetBlock(testdata.NewRawBlock(5, "0xa5", "0xa4"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(5), eth.WithPollInterval(10*time.Millisecond))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 5)
+
+	waitForCanceled := func(id int64) *m.BackfillJob {
+		var job *m.BackfillJob
+		waitFor(t, func() bool {
+			for _, job = range ethIndexer.GetBackfillJobs() {
+				if job.ID == id {
+					return job.State == m.BackfillStateCanceled
+				}
+			}
+			return false
+		}, "backfill %d was not canceled", id)
+		return job
+	}
+
+	job, err := ethIndexer.SubscribeAddressFrom(address, big.NewInt(1))
+	if err != nil {
+		t.Fatalf("failed to subscribe address: %v", err)
+	}
+	if err := ethIndexer.PauseAddress(address); err != nil {
+		t.Fatalf("failed to pause address: %v", err)
+	}
+	if job = waitForCanceled(job.ID); job.Error != "address paused" {
+		t.Errorf("failed to cancel the backfill of the paused address, got %+v", job)
+	}
+
+	job, err = ethIndexer.SubscribeAddressFrom(address, big.NewInt(1))
+	if err != nil {
+		t.Fatalf("failed to subscribe address: %v", err)
+	}
+	if err := ethIndexer.UnsubscribeAddress(address, true); err != nil {
+		t.Fatalf("failed to unsubscribe address: %v", err)
+	}
+	if job = waitForCanceled(job.ID); job.Error != "address unsubscribed" {
+		t.Errorf("failed to cancel the backfill of the purged address, got %+v", job)
+	}
+	if _, err := ethIndexer.GetSubscription(address); err == nil {
+		t.Errorf("failed to keep the purged address purged after the backfill was canceled")
+	}
+}
+
+func TestEthIndexerPauseAddress(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0", testdata.NewRawTransaction("0xt1", sender, address, 100)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1), eth.WithPollInterval(10*time.Millisecond))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 1)
+
+	if err := ethIndexer.PauseAddress(address); err != nil {
+		t.Fatalf("failed to pause address: %v", err)
+	}
+
+	// not recorded while paused
+	node.SetBlock(testdata.NewRawBlock(2, "0xa2", "0xa1", testdata.NewRawTransaction("0xt2", sender, address, 200)))
+	waitForBlock(t, ethIndexer, 2)
+	if txns, err := ethIndexer.GetTransactions(address); err != nil || len(txns) != 1 {
+		t.Fatalf("failed to stop recording the transactions of the paused address: %v, %v", txns, err)
+	}
+
+	// the gap is backfilled
+	job, err := ethIndexer.ResumeAddress(address, true)
+	if err != nil || job == nil || job.FromBlock.Int64() != 2 || job.ToBlock.Int64() != 2 {
+		t.Fatalf("failed to resume address with backfill: %+v, %v", job, err)
+	}
+	waitFor(t, func() bool {
+		txns, err := ethIndexer.GetTransactions(address)
+		return err == nil && len(txns) == 2
+	}, "failed to backfill the transactions missed while paused")
+
+	node.SetBlock(testdata.NewRawBlock(3, "0xa3", "0xa2", testdata.NewRawTransaction("0xt3", sender, address, 300)))
+	waitFor(t, func() bool {
+		txns, err := ethIndexer.GetTransactions(address)
+		return err == nil && len(txns) == 3
+	}, "failed to record the transactions of the resumed address")
+
+	if err := ethIndexer.UnsubscribeAddress(address, true); err != nil {
+		t.Fatalf("failed to unsubscribe address: %v", err)
+	}
+	if _, err := ethIndexer.GetTransactions(address); err == nil {
+		t.Errorf("failed to purge the transactions of the unsubscribed address")
+	}
+}
+
+func TestEthIndexerQueryTransactions(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0", testdata.NewRawTransaction("0xt1", sender, address, 100)))
+	node.SetBlock(testdata.NewRawBlock(2, "0xa2", "0xa1",
+		testdata.NewRawTransaction("0xt2", sender, address, 200), testdata.NewRawTransaction("0xt3", address, sender, 300)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1), eth.WithPollInterval(10*time.Millisecond))
+	ethIndexer.Start()
+
+	waitForBlock(t, ethIndexer, 2)
+
+	// paged by block and position in the block
+	page, err := ethIndexer.QueryTransactions(&m.TransactionQuery{Address: address, Limit: 2, Order: m.SortAscending})
+	if err != nil || len(page.Transactions) != 2 || page.Transactions[0].Hash != "0xt1" || page.Transactions[1].Hash != "0xt2" {
+		t.Fatalf("failed to query the first page: %+v, %v", page, err)
+	}
+	if page.Transactions[1].TransactionIndex == nil || page.Transactions[1].Timestamp == nil {
+		t.Errorf("failed to save the position and the time of the transaction: %+v", page.Transactions[1])
+	}
+
+	page, err = ethIndexer.QueryTransactions(&m.TransactionQuery{Address: address, Limit: 2, Order: m.SortAscending, Cursor: page.NextCursor})
+	if err != nil || len(page.Transactions) != 1 || page.Transactions[0].Hash != "0xt3" || page.NextCursor != "" {
+		t.Errorf("failed to query the last page: %+v, %v", page, err)
+	}
+
+	page, err = ethIndexer.QueryTransactions(&m.TransactionQuery{Address: address, Direction: m.DirectionOut})
+	if err != nil || len(page.Transactions) != 1 || page.Transactions[0].Hash != "0xt3" {
+		t.Errorf("failed to query the outgoing transactions: %+v, %v", page, err)
+	}
+
+	if _, err := ethIndexer.QueryTransactions(&m.TransactionQuery{Address: address, Limit: m.MaxQueryLimit + 1}); err == nil {
+		t.Errorf("failed to reject a limit over the maximum")
+	}
+}
+
+func TestEthIndexerWebhook(t *testing.T) {
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	sender := "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+
+	received := make(chan *http.Request, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer receiver.Close()
+
+	node := testdata.NewNode()
+	defer node.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage, _ := inmemorystorage.New()
+	_ = storage.SubscribeAddress(address)
+	// the receiver is served on the loopback
+	webhooks := notifier.NewWebhookNotifier(ctx, storage, notifier.WithWebhookPollInterval(10*time.Millisecond),
+		notifier.WithPrivateWebhooks(true))
+	webhooks.Start()
+	defer webhooks.Stop()
+	ethIndexer, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1),
+		eth.WithNotifier(webhooks), eth.WithPollInterval(10*time.Millisecond), eth.WithPrivateWebhooks(true))
+
+	strict, _ := eth.NewIndexer(ctx, node.URL, storage, big.NewInt(1))
+	if err := strict.SetWebhook(address, &m.Webhook{URL: receiver.URL}); !errors.Is(err, m.ErrPrivateWebhook) {
+		t.Errorf("failed to reject a webhook on the loopback: %v", err)
+	}
+
+	if err := ethIndexer.SetWebhook(address, &m.Webhook{URL: "ftp://example.com"}); err == nil {
+		t.Errorf("failed to reject a webhook url which is not http")
+	}
+	if err := ethIndexer.SetWebhook(address, &m.Webhook{URL: receiver.URL, Events: []m.EventType{"transaction.unknown"}}); err == nil {
+		t.Errorf("failed to reject an unknown event type")
+	}
+	if err := ethIndexer.SetWebhook(sender, &m.Webhook{URL: receiver.URL}); err == nil {
+		t.Errorf("failed to reject the webhook of an address not subscribed")
+	}
+	if err := ethIndexer.SetWebhook(address, &m.Webhook{URL: receiver.URL, Secret: "secret"}); err != nil {
+		t.Fatalf("failed to set webhook: %v", err)
+	}
+
+	node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0", testdata.NewRawTransaction("0xt1", sender, address, 100)))
+	ethIndexer.Start()
+	defer ethIndexer.Stop()
+
+	select {
+	case r := <-received:
+		if r.Header.Get(notifier.EventHeader) != string(m.EventTransactionIndexed) || r.Header.Get(notifier.SignatureHeader) == "" {
+			t.Errorf("failed to deliver the signed event: %v", r.Header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("failed to deliver the indexed transaction to the webhook")
+	}
+}
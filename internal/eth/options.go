@@ -0,0 +1,176 @@
+package eth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/notifier"
+	"github.com/hoangan/superwallet/pkg/httpclient"
+)
+
+// Option customizes the EthIndexer created by NewIndexer.
+type Option func(*EthIndexer)
+
+// WithNotifier sets the notifier used to tell subscribers about their address activity.
+// Defaults to printing the events to the console.
+func WithNotifier(n notifier.Notifier) Option {
+	return func(i *EthIndexer) {
+		i.notifier = n
+	}
+}
+
+// WithReorgWindow sets how many recent block hashes are kept to detect chain reorganizations.
+// A reorganization deeper than the window can not be fully rolled back.
+func WithReorgWindow(size int) Option {
+	return func(i *EthIndexer) {
+		if size > 0 {
+			i.recentBlocks = newBlockWindow(size)
+		}
+	}
+}
+
+// WithConfirmations sets the number of blocks, including the transaction block,
+// for a transaction to move from pending to confirmed.
+func WithConfirmations(confirmations uint64) Option {
+	return func(i *EthIndexer) {
+		if confirmations > 0 {
+			i.confirmations = confirmations
+		}
+	}
+}
+
+// WithFinalityDepth sets the number of blocks for a transaction to be finalized
+// when the finalized block tag is not in use.
+func WithFinalityDepth(finalityDepth uint64) Option {
+	return func(i *EthIndexer) {
+		if finalityDepth > 0 {
+			i.finalityDepth = finalityDepth
+		}
+	}
+}
+
+// WithBlockTags enables the safe and finalized block tags to confirm and finalize transactions,
+// enabled by default for the post-merge chains.
+func WithBlockTags(enabled bool) Option {
+	return func(i *EthIndexer) {
+		i.useBlockTags = enabled
+	}
+}
+
+// WithPrivateWebhooks allows the webhooks pointing at localhost, loopback, private or link-local addresses,
+// rejected by default since the API would have the indexer call the internal services, see m.ErrPrivateWebhook.
+func WithPrivateWebhooks(allowed bool) Option {
+	return func(i *EthIndexer) {
+		i.privateWebhooks = allowed
+	}
+}
+
+// WithWorkers sets the number of blocks fetched and parsed in parallel while catching up.
+func WithWorkers(workers int) Option {
+	return func(i *EthIndexer) {
+		if workers > 0 {
+			i.workers = workers
+		}
+	}
+}
+
+// WithPrefetchDepth sets how many fetched blocks can wait to be committed,
+// which bounds how far ahead of the committed block the blocks are fetched.
+func WithPrefetchDepth(prefetchDepth int) Option {
+	return func(i *EthIndexer) {
+		if prefetchDepth > 0 {
+			i.prefetchDepth = prefetchDepth
+		}
+	}
+}
+
+// WithTokens adds ERC-20 tokens to resolve the token transfers to their coin id and ticker,
+// on top of the DefaultTokens.
+func WithTokens(tokens ...*m.Token) Option {
+	return func(i *EthIndexer) {
+		for _, token := range tokens {
+			i.tokens[strings.ToLower(token.Contract)] = token
+		}
+	}
+}
+
+// WithInternalTransactions enables tracing the blocks to index the ETH transferred by contracts,
+// enabled by default when the node supports debug_traceBlockByNumber or trace_block.
+func WithInternalTransactions(enabled bool) Option {
+	return func(i *EthIndexer) {
+		if enabled {
+			i.traceMethod.Store(traceMethodUnknown)
+		} else {
+			i.traceMethod.Store(traceMethodNone)
+		}
+	}
+}
+
+// WithEndpoints sets the node endpoints, used in place of the endpoint given to NewIndexer.
+// The calls fail over to the next endpoint, the failing and lagging endpoints are ejected.
+func WithEndpoints(endpoints ...rpc.Endpoint) Option {
+	return func(i *EthIndexer) {
+		if len(endpoints) > 0 {
+			i.endpoints = endpoints
+		}
+	}
+}
+
+// WithHeadsSubscription subscribes to the new heads of the WebSocket endpoint to index the blocks as soon as they are produced.
+// The latest block number is polled while the subscription is down.
+func WithHeadsSubscription(wsEndpoint string) Option {
+	return func(i *EthIndexer) {
+		if wsEndpoint != "" {
+			i.heads = rpc.NewHeadsSubscriber(wsEndpoint)
+		}
+	}
+}
+
+// WithPollInterval sets how often the latest block number is polled without new heads subscription.
+// Defaults to the block time.
+func WithPollInterval(interval time.Duration) Option {
+	return func(i *EthIndexer) {
+		if interval > 0 {
+			i.pollInterval = interval
+		}
+	}
+}
+
+// WithCircuitStateHook sets the hook called on every change of the circuit breaker state of the node endpoints,
+// e.g: to export it as a metric. Defaults to logging the changes.
+func WithCircuitStateHook(hook httpclient.StateHook) Option {
+	return func(i *EthIndexer) {
+		if hook != nil {
+			i.circuitStateHook = hook
+		}
+	}
+}
+
+// WithMethodCosts sets the cost in credits of the node methods, counted by the rate limit of the endpoints,
+// on top of rpc.DefaultMethodCosts.
+func WithMethodCosts(costs map[string]int) Option {
+	return func(i *EthIndexer) {
+		i.methodCosts = costs
+	}
+}
+
+// WithBalanceReconcileInterval sets how often the balances of the subscribed addresses are checked against the node.
+func WithBalanceReconcileInterval(interval time.Duration) Option {
+	return func(i *EthIndexer) {
+		if interval > 0 {
+			i.balanceReconcileInterval = interval
+		}
+	}
+}
+
+// WithBalanceDriftHook sets the hook called for every computed balance differing from the node,
+// e.g: to alert about missed transfers. Defaults to logging the drifts.
+func WithBalanceDriftHook(hook BalanceDriftHook) Option {
+	return func(i *EthIndexer) {
+		if hook != nil {
+			i.balanceDriftHook = hook
+		}
+	}
+}
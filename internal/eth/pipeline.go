@@ -0,0 +1,121 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
+)
+
+// fetchedBlock is a block fetched and parsed ahead by a pipeline worker, waiting to be committed.
+type fetchedBlock struct {
+	number     *big.Int
+	hash       string
+	parentHash string
+	txs        []*m.Transaction
+	err        error
+}
+
+// prefetchBlocks fetches and parses the blocks from fromBlockNumber to toBlockNumber with a bounded pool of workers.
+// Each block is delivered through its own slot, the slots are sent in strict block order,
+// so the blocks can be committed in order while the following ones are still being fetched.
+// At most prefetchDepth slots are waiting to be read, which bounds how far ahead the blocks are fetched.
+func (i *EthIndexer) prefetchBlocks(ctx context.Context, fromBlockNumber *big.Int, toBlockNumber *big.Int) <-chan chan *fetchedBlock {
+	slots := make(chan chan *fetchedBlock, i.prefetchDepth)
+
+	go func() {
+		defer close(slots)
+
+		workers := make(chan struct{}, i.workers)
+		for blockNumber := new(big.Int).Set(fromBlockNumber); blockNumber.Cmp(toBlockNumber) <= 0; blockNumber.Add(blockNumber, big.NewInt(1)) {
+			select {
+			case <-ctx.Done():
+				return
+			case workers <- struct{}{}:
+			}
+
+			// buffered, so the worker never blocks when nobody reads the slot anymore
+			slot := make(chan *fetchedBlock, 1)
+			go func(blockNumber *big.Int) {
+				defer func() { <-workers }()
+				slot <- i.fetchBlock(ctx, blockNumber)
+			}(new(big.Int).Set(blockNumber))
+
+			select {
+			case <-ctx.Done():
+				return
+			case slots <- slot:
+			}
+		}
+	}()
+
+	return slots
+}
+
+// fetchBlock fetches the block by number and parses its transactions.
+func (i *EthIndexer) fetchBlock(ctx context.Context, blockNumber *big.Int) *fetchedBlock {
+	rawBlock, err := i.client.GetBlockByNumber(ctx, blockNumber)
+	// blocks are fetched up to the latest block, load balanced nodes could be behind the node which served it
+	if errors.Is(err, rpc.ErrNotFound) {
+		return &fetchedBlock{number: blockNumber, err: fmt.Errorf("block %s not found: %w: %w", blockNumber, rpc.ErrNodeBehind, err)}
+	}
+	if err != nil {
+		return &fetchedBlock{number: blockNumber, err: fmt.Errorf("failed to get block by number: %w", err)}
+	}
+
+	receipts, err := i.fetchReceipts(ctx, rawBlock)
+	if err != nil {
+		return &fetchedBlock{number: blockNumber, err: fmt.Errorf("failed to get receipts of block %s: %w", blockNumber, err)}
+	}
+
+	internalTransfers, err := i.fetchInternalTransfers(ctx, blockNumber)
+	if err != nil {
+		return &fetchedBlock{number: blockNumber, err: fmt.Errorf("failed to trace block %s: %w", blockNumber, err)}
+	}
+
+	timestamp, err := hexencoder.HexToDecimal(rawBlock.Timestamp)
+	if err != nil {
+		return &fetchedBlock{number: blockNumber, err: fmt.Errorf("failed to parse timestamp of block %s: %w", blockNumber, err)}
+	}
+
+	txs := make([]*m.Transaction, 0, len(rawBlock.Transactions))
+	for _, rawTx := range rawBlock.Transactions {
+		tx, err := i.ParseTransaction(rawTx)
+		if err != nil {
+			fmt.Printf("failed to parse transaction: %v\n", err)
+			continue
+		}
+		tx.Timestamp = timestamp
+
+		receipt, ok := receipts[tx.Hash]
+		if !ok {
+			return &fetchedBlock{number: blockNumber, err: fmt.Errorf("receipt of transaction %s not found", tx.Hash)}
+		}
+
+		// the transaction is not dropped from the block, the block is fetched again instead
+		if err := i.ParseReceipt(tx, receipt); err != nil {
+			return &fetchedBlock{number: blockNumber, err: fmt.Errorf("failed to parse receipt of transaction %s: %w", tx.Hash, err)}
+		}
+
+		// A reverted transaction emits no events and its internal calls are reverted as well
+		if !tx.Failed() {
+			tx.Transfers = append(tx.Transfers, internalTransfers[tx.Hash]...)
+
+			// Token transfers are only found in the Transfer events emitted by the token contracts
+			tx.Transfers = append(tx.Transfers, i.parseTransferLogs(receipt.Logs)...)
+		}
+
+		txs = append(txs, tx)
+	}
+
+	return &fetchedBlock{
+		number:     blockNumber,
+		hash:       rawBlock.Hash,
+		parentHash: rawBlock.ParentHash,
+		txs:        txs,
+	}
+}
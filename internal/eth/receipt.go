@@ -0,0 +1,131 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
+)
+
+// fetchReceipts fetches the receipts of the block transactions, by transaction hash.
+// eth_getBlockReceipts is used when the node supports it, eth_getTransactionReceipt of all the transactions in batches otherwise.
+func (i *EthIndexer) fetchReceipts(ctx context.Context, rawBlock *rpc.RawBlock) (map[string]*rpc.RawReceipt, error) {
+	receipts := make(map[string]*rpc.RawReceipt, len(rawBlock.Transactions))
+	if len(rawBlock.Transactions) == 0 {
+		return receipts, nil
+	}
+
+	var rawReceipts []*rpc.RawReceipt
+	if !i.blockReceiptsUnsupported.Load() {
+		blockNumber, err := hexencoder.HexToDecimal(rawBlock.Number)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse block number: %w", err)
+		}
+
+		rawReceipts, err = i.client.GetBlockReceipts(ctx, blockNumber)
+		if errors.Is(err, rpc.ErrMethodNotSupported) {
+			fmt.Printf("node does not support eth_getBlockReceipts, falling back to eth_getTransactionReceipt\n")
+			i.blockReceiptsUnsupported.Store(true)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if i.blockReceiptsUnsupported.Load() {
+		txHashes := make([]string, 0, len(rawBlock.Transactions))
+		for _, rawTx := range rawBlock.Transactions {
+			txHashes = append(txHashes, rawTx.Hash)
+		}
+
+		var err error
+		rawReceipts, err = i.client.GetTransactionReceipts(ctx, txHashes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, receipt := range rawReceipts {
+		// the chain could be reorganized between fetching the block and its receipts
+		if !strings.EqualFold(receipt.BlockHash, rawBlock.Hash) {
+			return nil, fmt.Errorf("receipt of transaction %s belongs to block %s instead of %s", receipt.TransactionHash, receipt.BlockHash, rawBlock.Hash)
+		}
+		receipts[receipt.TransactionHash] = receipt
+	}
+
+	return receipts, nil
+}
+
+// ParseReceipt sets the execution result of the receipt to the transaction.
+// A failed transaction has no value transferred, it is still recorded for the fee paid by the sender.
+func (i *EthIndexer) ParseReceipt(tx *m.Transaction, receipt *rpc.RawReceipt) error {
+	var err error
+
+	if receipt.Status == "" && receipt.Root != "" {
+		// receipts of the blocks before Byzantium have the state root instead of the status,
+		// the execution result is not told and the transaction is taken as successful
+		tx.Status = big.NewInt(1)
+	} else if tx.Status, err = hexencoder.HexToDecimal(receipt.Status); err != nil {
+		return fmt.Errorf("failed to parse status: %w", err)
+	}
+
+	if tx.GasUsed, err = hexencoder.HexToDecimal(receipt.GasUsed); err != nil {
+		return fmt.Errorf("failed to parse gas used: %w", err)
+	}
+
+	if tx.CumulativeGasUsed, err = hexencoder.HexToDecimal(receipt.CumulativeGasUsed); err != nil {
+		return fmt.Errorf("failed to parse cumulative gas used: %w", err)
+	}
+
+	if tx.EffectiveGasPrice, err = hexencoder.HexToDecimal(receipt.EffectiveGasPrice); err != nil {
+		// receipts of the blocks before London do not have this field, the gas price is paid as is
+		if tx.GasPrice == nil {
+			return fmt.Errorf("failed to parse effective gas price: %w", err)
+		}
+		tx.EffectiveGasPrice = tx.GasPrice
+	}
+
+	tx.Fee = new(big.Int).Mul(tx.GasUsed, tx.EffectiveGasPrice)
+
+	// blob transactions pay the blob gas on top of the execution gas
+	if receipt.BlobGasUsed != "" {
+		blobGasUsed, err := hexencoder.HexToDecimal(receipt.BlobGasUsed)
+		if err != nil {
+			return fmt.Errorf("failed to parse blob gas used: %w", err)
+		}
+
+		blobGasPrice, err := hexencoder.HexToDecimal(receipt.BlobGasPrice)
+		if err != nil {
+			return fmt.Errorf("failed to parse blob gas price: %w", err)
+		}
+
+		tx.Fee.Add(tx.Fee, new(big.Int).Mul(blobGasUsed, blobGasPrice))
+	}
+
+	tx.ContractAddress = receipt.ContractAddress
+
+	tx.Logs = make([]*m.Log, 0, len(receipt.Logs))
+	for _, rawLog := range receipt.Logs {
+		logIndex, err := hexencoder.HexToDecimal(rawLog.LogIndex)
+		if err != nil {
+			return fmt.Errorf("failed to parse log index: %w", err)
+		}
+
+		tx.Logs = append(tx.Logs, &m.Log{
+			Address:  strings.ToLower(rawLog.Address),
+			Topics:   rawLog.Topics,
+			Data:     rawLog.Data,
+			LogIndex: logIndex,
+		})
+	}
+
+	if tx.Failed() {
+		tx.Transfers = []*m.Transfer{}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hoangan/superwallet/pkg/httpclient"
+)
+
+// maxBatchSize is the number of calls sent in one request,
+// larger batches are split since providers limit the size of a batch.
+const maxBatchSize = 100
+
+// BatchElem is a call of a batch. Result is decoded the same way as a single call,
+// Error is set when the call fails, without failing the other calls of the batch.
+type BatchElem struct {
+	Method string
+	Params []interface{}
+	Result interface{}
+	Error  error
+}
+
+// BatchCall sends the calls in as few requests as possible, and matches the responses back by id,
+// in any order they arrive. The returned error is the failure of the whole batch, e.g: the node is unreachable,
+// the failure of each call is set to its Error. Nodes that reject batches get the calls one by one.
+func (c *EthClient) BatchCall(ctx context.Context, elems []BatchElem) error {
+	for start := 0; start < len(elems); start += maxBatchSize {
+		end := min(start+maxBatchSize, len(elems))
+
+		if !c.batchUnsupported.Load() {
+			err := c.batchCall(ctx, elems[start:end])
+			if !errors.Is(err, errBatchRejected) {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			fmt.Printf("node does not support batch requests, falling back to single calls\n")
+			c.batchUnsupported.Store(true)
+		}
+
+		for j := start; j < end; j++ {
+			elems[j].Error = c.call(ctx, elems[j].Method, elems[j].Params, elems[j].Result)
+		}
+	}
+
+	return nil
+}
+
+// errBatchRejected is returned when the node answers a batch with anything else than a list of responses.
+var errBatchRejected = errors.New("batch rejected")
+
+func (c *EthClient) batchCall(ctx context.Context, elems []BatchElem) error {
+	if len(elems) == 0 {
+		return nil
+	}
+
+	// the batch costs as much as its calls
+	cost := 0
+	requests := make([]*rpcRequest, len(elems))
+	byId := make(map[uint64]*BatchElem, len(elems))
+	for j := range elems {
+		requests[j] = c.newRequest(elems[j].Method, elems[j].Params)
+		byId[requests[j].Id] = &elems[j]
+		cost += c.cost(elems[j].Method)
+	}
+
+	payload, err := json.Marshal(requests)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch request: %w", err)
+	}
+
+	responseBodyBytes, err := c.client.Post(httpclient.WithRequestCost(ctx, cost), payload)
+	if err != nil {
+		// nodes without batch support answer with a client error, except for rate limiting
+		var statusErr *httpclient.StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode >= http.StatusBadRequest &&
+			statusErr.StatusCode < http.StatusInternalServerError && statusErr.StatusCode != http.StatusTooManyRequests {
+			return errBatchRejected
+		}
+		return newError("batch", err)
+	}
+
+	var responses []*rpcResponse
+	if err := json.Unmarshal(responseBodyBytes, &responses); err != nil {
+		// a single error object instead of the list of responses, the batch is not supported unless rate limited
+		var response rpcResponse
+		if json.Unmarshal(responseBodyBytes, &response) == nil && response.Error != nil {
+			if classifyRPCError(response.Error) == ErrRateLimited {
+				return newError("batch", response.Error)
+			}
+			return errBatchRejected
+		}
+		return newError("batch", fmt.Errorf("failed to unmarshal batch response: %w", err))
+	}
+
+	for _, response := range responses {
+		elem, ok := byId[response.Id]
+		if !ok {
+			continue
+		}
+		elem.Error = response.decode(elem.Method, elem.Result)
+		delete(byId, response.Id)
+	}
+
+	// the calls without response failed, e.g: dropped by the provider
+	for _, elem := range byId {
+		elem.Error = &Error{Method: elem.Method, Kind: ErrNodeUnavailable, Err: errors.New("missing response in batch")}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+)
+
+type batchRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	Id     uint64        `json:"id"`
+}
+
+func TestBatchCall(t *testing.T) {
+	t.Run("Out Of Order Responses", func(t *testing.T) {
+		var posts atomic.Int32
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			posts.Add(1)
+
+			var requests []batchRequest
+			if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			// answer in reverse order, the first call fails and the last call has no response
+			responses := []map[string]interface{}{}
+			for j := len(requests) - 2; j >= 0; j-- {
+				response := map[string]interface{}{"jsonrpc": "2.0", "id": requests[j].Id}
+				if j == 0 {
+					response["error"] = map[string]interface{}{"code": -32000, "message": "header not found"}
+				} else {
+					response["result"] = requests[j].Params[0]
+				}
+				responses = append(responses, response)
+			}
+			_ = json.NewEncoder(w).Encode(responses)
+		})
+
+		results := make([]string, 4)
+		elems := make([]rpc.BatchElem, 4)
+		for j := range elems {
+			elems[j] = rpc.BatchElem{Method: "echo", Params: []interface{}{fmt.Sprintf("0x%d", j)}, Result: &results[j]}
+		}
+
+		if err := client.BatchCall(context.Background(), elems); err != nil {
+			t.Fatalf("failed to send batch: %v", err)
+		}
+
+		if posts.Load() != 1 {
+			t.Errorf("failed to send the calls in one request, got %d requests", posts.Load())
+		}
+		if !errors.Is(elems[0].Error, rpc.ErrNodeBehind) {
+			t.Errorf("failed to set the error of the failed call, got %v", elems[0].Error)
+		}
+		for j := 1; j < 3; j++ {
+			if elems[j].Error != nil || results[j] != fmt.Sprintf("0x%d", j) {
+				t.Errorf("failed to match the response of call %d, got %s, %v", j, results[j], elems[j].Error)
+			}
+		}
+		if !errors.Is(elems[3].Error, rpc.ErrNodeUnavailable) {
+			t.Errorf("failed to set the error of the call without response, got %v", elems[3].Error)
+		}
+	})
+
+	t.Run("Fallback To Single Calls", func(t *testing.T) {
+		var batches, singles atomic.Int32
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			var request batchRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				batches.Add(1)
+				_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch requests are not supported"}}`))
+				return
+			}
+
+			singles.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": request.Id, "result": request.Params[0]})
+		})
+
+		for n := 0; n < 2; n++ {
+			results := make([]string, 3)
+			elems := make([]rpc.BatchElem, 3)
+			for j := range elems {
+				elems[j] = rpc.BatchElem{Method: "echo", Params: []interface{}{fmt.Sprintf("0x%d", j)}, Result: &results[j]}
+			}
+
+			if err := client.BatchCall(context.Background(), elems); err != nil {
+				t.Fatalf("failed to send batch: %v", err)
+			}
+
+			for j := range elems {
+				if elems[j].Error != nil || results[j] != fmt.Sprintf("0x%d", j) {
+					t.Errorf("failed to fall back to single call %d, got %s, %v", j, results[j], elems[j].Error)
+				}
+			}
+		}
+
+		if batches.Load() != 1 {
+			t.Errorf("failed to remember the node rejects batches, got %d batches", batches.Load())
+		}
+		if singles.Load() != 6 {
+			t.Errorf("failed to send single calls, expected 6, got %d", singles.Load())
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hoangan/superwallet/pkg/httpclient"
+)
+
+// Kinds of failed calls to the node, checked with errors.Is.
+var (
+	// ErrRateLimited is returned when the provider rejects the call for exceeding the plan limits.
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrNotFound is returned when the node has no result for the call, e.g: a block after the chain head.
+	ErrNotFound = errors.New("not found")
+
+	// ErrMethodNotSupported is returned when the node does not provide the method.
+	ErrMethodNotSupported = errors.New("method not supported")
+
+	// ErrNodeBehind is returned when the node has not synced the requested block yet,
+	// common for load balanced providers with nodes at different heights.
+	ErrNodeBehind = errors.New("node behind")
+
+	// ErrNodeUnavailable is returned when the node is not reachable or fails to serve the call.
+	ErrNodeUnavailable = errors.New("node unavailable")
+
+	// ErrCallRejected is returned when the node rejects the call itself, e.g: invalid params or a reverted eth_call,
+	// any other node would reject it the same way.
+	ErrCallRejected = errors.New("call rejected")
+)
+
+// JSON-RPC error codes
+const (
+	invalidRequestCode = -32600
+	methodNotFoundCode = -32601
+	invalidParamsCode  = -32602
+	limitExceededCode  = -32005 // EIP-1474, used by most providers for rate limiting
+	executionErrorCode = 3      // eth_call reverted, along with the revert data
+)
+
+// RPCError is the error object of a JSON-RPC response.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// Error is a failed call to the node, classified by its kind.
+// errors.Is matches both the kind, e.g: ErrRateLimited, and the underlying cause.
+type Error struct {
+	Method string
+	Kind   error
+	Err    error
+
+	// RetryAfter is how long the node asks to wait before retrying, zero when it does not tell.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %v", e.Method, e.Kind)
+	}
+	return fmt.Sprintf("%s: %v: %v", e.Method, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// RetryAfter returns how long the node asked to wait before retrying the failed call, zero when it did not tell.
+func RetryAfter(err error) time.Duration {
+	var rpcErr *Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.RetryAfter
+	}
+	return 0
+}
+
+// newError classifies the failure of a call, from the transport or from the JSON-RPC error object.
+func newError(method string, err error) *Error {
+	var statusErr *httpclient.StatusError
+	if errors.As(err, &statusErr) {
+		kind := ErrNodeUnavailable
+		switch {
+		case statusErr.StatusCode == http.StatusTooManyRequests:
+			kind = ErrRateLimited
+		case statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusMethodNotAllowed:
+			kind = ErrMethodNotSupported
+		}
+		return &Error{Method: method, Kind: kind, Err: err, RetryAfter: statusErr.RetryAfter}
+	}
+
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return &Error{Method: method, Kind: classifyRPCError(rpcErr), Err: err}
+	}
+
+	// the budget of the endpoint is spent, the calls are not even sent
+	if errors.Is(err, httpclient.ErrBudgetExceeded) {
+		return &Error{Method: method, Kind: ErrRateLimited, Err: err}
+	}
+
+	return &Error{Method: method, Kind: ErrNodeUnavailable, Err: err}
+}
+
+// classifyRPCError maps the JSON-RPC error to its kind.
+// Providers do not agree on the error codes, the messages are checked as well.
+func classifyRPCError(rpcErr *RPCError) error {
+	message := strings.ToLower(rpcErr.Message)
+
+	switch {
+	case rpcErr.Code == methodNotFoundCode ||
+		strings.Contains(message, "not supported") ||
+		strings.Contains(message, "does not exist") ||
+		strings.Contains(message, "not available"):
+		return ErrMethodNotSupported
+
+	case rpcErr.Code == invalidRequestCode || rpcErr.Code == invalidParamsCode || rpcErr.Code == executionErrorCode ||
+		strings.Contains(message, "execution reverted") ||
+		strings.Contains(message, "invalid argument") ||
+		// eth_getLogs over the limits of the provider
+		strings.Contains(message, "query returned more than") ||
+		strings.Contains(message, "response size") ||
+		strings.Contains(message, "block range"):
+		return ErrCallRejected
+
+	case rpcErr.Code == limitExceededCode ||
+		strings.Contains(message, "rate limit") ||
+		strings.Contains(message, "too many requests") ||
+		strings.Contains(message, "exceeded"):
+		return ErrRateLimited
+
+	// geth before the merge, the consensus layer has no safe and finalized blocks to tell
+	case strings.Contains(message, "safe block not found") ||
+		strings.Contains(message, "finalized block not found"):
+		return ErrNotFound
+
+	case strings.Contains(message, "header not found") ||
+		strings.Contains(message, "unknown block") ||
+		strings.Contains(message, "block not found") ||
+		strings.Contains(message, "not synced"):
+		return ErrNodeBehind
+	}
+
+	return ErrNodeUnavailable
+}
+
+// isNodeError reports whether the JSON-RPC error is a failure of the node rather than of the call,
+// the call may succeed on another node.
+func isNodeError(rpcErr *RPCError) bool {
+	switch classifyRPCError(rpcErr) {
+	case ErrRateLimited, ErrNodeUnavailable, ErrNodeBehind:
+		return true
+	}
+	return false
+}
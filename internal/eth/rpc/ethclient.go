@@ -1,102 +1,383 @@
 package rpc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/big"
+	"strings"
+	"sync/atomic"
 
 	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
 	"github.com/hoangan/superwallet/pkg/httpclient"
 )
 
+// Block tags accepted in place of a block number.
+// safe and finalized are available since the merge, set by the consensus layer.
+const (
+	BlockTagLatest    = "latest"
+	BlockTagSafe      = "safe"
+	BlockTagFinalized = "finalized"
+)
+
+// balanceOfSelector is the method id of the ERC-20 balanceOf(address).
+const balanceOfSelector = "0x70a08231"
+
 type EthClient struct {
-	client *httpclient.Client
+	client Transport
+
+	// ids of the requests, unique to match the responses of a batch
+	nextId atomic.Uint64
+
+	// set once the node rejects a batch, the batched calls are sent one by one
+	batchUnsupported atomic.Bool
+
+	// cost in credits of the calls by method, counted by the rate limit of the endpoints
+	methodCosts map[string]int
+}
+
+// DefaultMethodCosts are the relative costs of the methods, tracing and receipts of a whole block
+// are much heavier for the node than fetching a block. The methods not listed cost 1.
+var DefaultMethodCosts = map[string]int{
+	"eth_blockNumber":           1,
+	"eth_getBlockByNumber":      2,
+	"eth_getTransactionByHash":  2,
+	"eth_getTransactionReceipt": 2,
+	"eth_getLogs":               10,
+	"eth_getBlockReceipts":      20,
+	"debug_traceBlockByNumber":  40,
+	"trace_block":               40,
+}
+
+// ClientOption customizes the EthClient.
+type ClientOption func(*EthClient)
+
+// WithMethodCosts sets the cost in credits of the methods, on top of DefaultMethodCosts.
+func WithMethodCosts(costs map[string]int) ClientOption {
+	return func(c *EthClient) {
+		for method, cost := range costs {
+			c.methodCosts[method] = cost
+		}
+	}
 }
 
 func NewEthClient(url string) *EthClient {
-	return &EthClient{
-		client: httpclient.NewHttpClient(url),
+	return NewEthClientWithTransport(httpclient.NewHttpClient(url))
+}
+
+// NewEthClientWithTransport creates the client sending the calls through the transport, e.g: a Pool of endpoints.
+func NewEthClientWithTransport(transport Transport, opts ...ClientOption) *EthClient {
+	c := &EthClient{
+		client:      transport,
+		methodCosts: make(map[string]int, len(DefaultMethodCosts)),
 	}
+
+	for method, cost := range DefaultMethodCosts {
+		c.methodCosts[method] = cost
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// cost returns the cost in credits of the method.
+func (c *EthClient) cost(method string) int {
+	if cost, ok := c.methodCosts[method]; ok {
+		return cost
+	}
+	return 1
 }
 
-func (c *EthClient) GetLatestBlock() (*RawBlock, error) {
+func (c *EthClient) GetLatestBlock(ctx context.Context) (*RawBlock, error) {
 	// fetch the latest block
-	responseBodyBytes, err := c.client.Post(getRequestPayload("eth_getBlockByNumber", []interface{}{"latest", true}))
-	if err != nil {
+	var block RawBlock
+	if err := c.call(ctx, "eth_getBlockByNumber", []interface{}{BlockTagLatest, true}, &block); err != nil {
 		return nil, fmt.Errorf("failed to get latest block: %w", err)
 	}
 
-	var responseBody struct {
-		Block   RawBlock `json:"result"`
-		Jsonrpc string   `json:"jsonrpc"`
-		Id      int      `json:"id"`
+	return &block, nil
+}
+
+// GetBlockNumber fetches the number of the latest block, much cheaper than fetching the latest block.
+func (c *EthClient) GetBlockNumber(ctx context.Context) (*big.Int, error) {
+	var blockNumberHex string
+	if err := c.call(ctx, "eth_blockNumber", []interface{}{}, &blockNumberHex); err != nil {
+		return nil, fmt.Errorf("failed to get block number: %w", err)
 	}
-	if err := json.Unmarshal(responseBodyBytes, &responseBody); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal block response: %w", err)
+
+	blockNumber, err := hexencoder.HexToDecimal(blockNumberHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse block number %s: %w", blockNumberHex, err)
 	}
 
-	return &responseBody.Block, nil
+	return blockNumber, nil
 }
 
-func (c *EthClient) GetBlockByNumber(blockNumber *big.Int) (*RawBlock, error) {
+func (c *EthClient) GetBlockByNumber(ctx context.Context, blockNumber *big.Int) (*RawBlock, error) {
 	blockNumberHex := hexencoder.DecimalToHex(blockNumber)
 
 	// fetch the block by number with detailed transactions
-	responseBodyBytes, err := c.client.Post(getRequestPayload("eth_getBlockByNumber", []interface{}{blockNumberHex, true}))
-	if err != nil {
+	var block RawBlock
+	if err := c.call(ctx, "eth_getBlockByNumber", []interface{}{blockNumberHex, true}, &block); err != nil {
 		return nil, fmt.Errorf("failed to get block by number: %w", err)
 	}
 
-	var responseBody struct {
-		Block   RawBlock `json:"result"`
-		Jsonrpc string   `json:"jsonrpc"`
-		Id      int      `json:"id"`
-	}
-	if err := json.Unmarshal(responseBodyBytes, &responseBody); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal block response: %w", err)
+	return &block, nil
+}
+
+func (c *EthClient) GetBlockHeaderByNumber(ctx context.Context, blockNumber *big.Int) (*RawBlockHeader, error) {
+	return c.GetBlockHeaderByTag(ctx, hexencoder.DecimalToHex(blockNumber))
+}
+
+// GetBlockHeaderByTag fetches the block header by a hex block number or a block tag,
+// e.g: latest, safe, finalized.
+func (c *EthClient) GetBlockHeaderByTag(ctx context.Context, tag string) (*RawBlockHeader, error) {
+	// fetch the block without transactions, only hashes are needed
+	var header RawBlockHeader
+	if err := c.call(ctx, "eth_getBlockByNumber", []interface{}{tag, false}, &header); err != nil {
+		return nil, fmt.Errorf("failed to get block header: %w", err)
 	}
 
-	return &responseBody.Block, nil
+	return &header, nil
 }
 
-func (c *EthClient) GetTransactionByHash(txHash string) (*RawTransaction, error) {
+func (c *EthClient) GetTransactionByHash(ctx context.Context, txHash string) (*RawTransaction, error) {
 	// fetch the transaction by hash
-	responseBodyBytes, err := c.client.Post(getRequestPayload("eth_getTransactionByHash", []interface{}{txHash}))
-	if err != nil {
+	var transaction RawTransaction
+	if err := c.call(ctx, "eth_getTransactionByHash", []interface{}{txHash}, &transaction); err != nil {
 		return nil, fmt.Errorf("failed to get transaction by hash: %w", err)
 	}
 
-	var responseBody struct {
-		Transaction RawTransaction `json:"result"`
-		Jsonrpc     string         `json:"jsonrpc"`
-		Id          int            `json:"id"`
+	return &transaction, nil
+}
+
+// GetBlockReceipts fetches the receipts of all transactions of the block with eth_getBlockReceipts,
+// not provided by every node, see GetTransactionReceipt.
+func (c *EthClient) GetBlockReceipts(ctx context.Context, blockNumber *big.Int) ([]*RawReceipt, error) {
+	blockNumberHex := hexencoder.DecimalToHex(blockNumber)
+
+	var receipts []*RawReceipt
+	if err := c.call(ctx, "eth_getBlockReceipts", []interface{}{blockNumberHex}, &receipts); err != nil {
+		return nil, fmt.Errorf("failed to get block receipts: %w", err)
+	}
+
+	return receipts, nil
+}
+
+func (c *EthClient) GetTransactionReceipt(ctx context.Context, txHash string) (*RawReceipt, error) {
+	var receipt RawReceipt
+	if err := c.call(ctx, "eth_getTransactionReceipt", []interface{}{txHash}, &receipt); err != nil {
+		return nil, fmt.Errorf("failed to get transaction receipt: %w", err)
+	}
+
+	return &receipt, nil
+}
+
+// GetTransactionReceipts fetches the receipts of the transactions in batches, in the order of the hashes.
+func (c *EthClient) GetTransactionReceipts(ctx context.Context, txHashes []string) ([]*RawReceipt, error) {
+	receipts := make([]*RawReceipt, len(txHashes))
+	elems := make([]BatchElem, len(txHashes))
+	for j, txHash := range txHashes {
+		receipts[j] = &RawReceipt{}
+		elems[j] = BatchElem{
+			Method: "eth_getTransactionReceipt",
+			Params: []interface{}{txHash},
+			Result: receipts[j],
+		}
+	}
+
+	if err := c.BatchCall(ctx, elems); err != nil {
+		return nil, fmt.Errorf("failed to get transaction receipts: %w", err)
+	}
+
+	for j, elem := range elems {
+		if elem.Error != nil {
+			return nil, fmt.Errorf("failed to get transaction receipt %s: %w", txHashes[j], elem.Error)
+		}
+	}
+
+	return receipts, nil
+}
+
+// GetBlockLogs fetches the logs of the block by its hash, only the logs with any of the topics as first topic
+// when any topic is given. Filtering by block hash gets the logs of that exact block even during a reorganization.
+func (c *EthClient) GetBlockLogs(ctx context.Context, blockHash string, topics ...string) ([]*RawLog, error) {
+	filter := map[string]interface{}{
+		"blockHash": blockHash,
+	}
+	if len(topics) > 0 {
+		filter["topics"] = []interface{}{topics}
+	}
+
+	// a block without logs has an empty list, missing logs must not be taken as no transfers
+	var logs []*RawLog
+	if err := c.call(ctx, "eth_getLogs", []interface{}{filter}, &logs); err != nil {
+		return nil, fmt.Errorf("failed to get block logs: %w", err)
+	}
+
+	return logs, nil
+}
+
+// GetLogs fetches the logs of the blocks from fromBlockNumber to toBlockNumber, filtered by the topics by position,
+// a nil topic matching any topic. The providers limit the range or the number of logs returned,
+// a filter over their limits is rejected with ErrCallRejected and has to be split.
+func (c *EthClient) GetLogs(ctx context.Context, fromBlockNumber *big.Int, toBlockNumber *big.Int, topics ...interface{}) ([]*RawLog, error) {
+	filter := map[string]interface{}{
+		"fromBlock": hexencoder.DecimalToHex(fromBlockNumber),
+		"toBlock":   hexencoder.DecimalToHex(toBlockNumber),
+	}
+	if len(topics) > 0 {
+		filter["topics"] = topics
+	}
+
+	var logs []*RawLog
+	if err := c.call(ctx, "eth_getLogs", []interface{}{filter}, &logs); err != nil {
+		return nil, fmt.Errorf("failed to get logs: %w", err)
+	}
+
+	return logs, nil
+}
+
+// GetBalance fetches the balance in wei of the address at the block.
+func (c *EthClient) GetBalance(ctx context.Context, address string, blockNumber *big.Int) (*big.Int, error) {
+	var balanceHex string
+	if err := c.call(ctx, "eth_getBalance", []interface{}{address, hexencoder.DecimalToHex(blockNumber)}, &balanceHex); err != nil {
+		return nil, fmt.Errorf("failed to get balance: %w", err)
+	}
+
+	balance, err := hexencoder.HexToDecimal(balanceHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse balance %s: %w", balanceHex, err)
+	}
+
+	return balance, nil
+}
+
+// GetTransactionCount fetches the nonce of the address at the block, the number of transactions it sent.
+func (c *EthClient) GetTransactionCount(ctx context.Context, address string, blockNumber *big.Int) (*big.Int, error) {
+	var countHex string
+	if err := c.call(ctx, "eth_getTransactionCount", []interface{}{address, hexencoder.DecimalToHex(blockNumber)}, &countHex); err != nil {
+		return nil, fmt.Errorf("failed to get transaction count: %w", err)
+	}
+
+	count, err := hexencoder.HexToDecimal(countHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transaction count %s: %w", countHex, err)
+	}
+
+	return count, nil
+}
+
+// GetTokenBalance fetches the ERC-20 token balance of the address at the block,
+// calling balanceOf(address) of the token contract.
+func (c *EthClient) GetTokenBalance(ctx context.Context, contract string, address string, blockNumber *big.Int) (*big.Int, error) {
+	call := map[string]interface{}{
+		"to":   contract,
+		"data": balanceOfSelector + fmt.Sprintf("%064s", strings.TrimPrefix(strings.ToLower(address), "0x")),
+	}
+
+	var balanceHex string
+	if err := c.call(ctx, "eth_call", []interface{}{call, hexencoder.DecimalToHex(blockNumber)}, &balanceHex); err != nil {
+		return nil, fmt.Errorf("failed to get token balance: %w", err)
+	}
+
+	balance, err := hexencoder.HexToDecimal(balanceHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token balance %s: %w", balanceHex, err)
+	}
+
+	return balance, nil
+}
+
+// TraceBlockByNumber traces the transactions of the block with the callTracer of debug_traceBlockByNumber,
+// provided by Geth and most of the clients with the debug namespace enabled.
+func (c *EthClient) TraceBlockByNumber(ctx context.Context, blockNumber *big.Int) ([]*RawTransactionTrace, error) {
+	blockNumberHex := hexencoder.DecimalToHex(blockNumber)
+	tracerConfig := map[string]interface{}{"tracer": "callTracer"}
+
+	var traces []*RawTransactionTrace
+	if err := c.call(ctx, "debug_traceBlockByNumber", []interface{}{blockNumberHex, tracerConfig}, &traces); err != nil {
+		return nil, fmt.Errorf("failed to trace block by number: %w", err)
 	}
 
-	if err := json.Unmarshal(responseBodyBytes, &responseBody); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal block response: %w", err)
+	return traces, nil
+}
+
+// TraceBlock traces the transactions of the block with trace_block,
+// provided by Erigon, Nethermind and Reth with the trace namespace enabled.
+func (c *EthClient) TraceBlock(ctx context.Context, blockNumber *big.Int) ([]*RawTrace, error) {
+	blockNumberHex := hexencoder.DecimalToHex(blockNumber)
+
+	var traces []*RawTrace
+	if err := c.call(ctx, "trace_block", []interface{}{blockNumberHex}, &traces); err != nil {
+		return nil, fmt.Errorf("failed to trace block: %w", err)
 	}
 
-	return &responseBody.Transaction, nil
+	return traces, nil
 }
 
-// TODO: Implement function to fetch internal transactions
+// call sends the JSON-RPC request and decodes its result into result.
+// The failures are returned as *Error, a null result as ErrNotFound,
+// so callers never mistake a failed call for an empty result.
+func (c *EthClient) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	payload, err := json.Marshal(c.newRequest(method, params))
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	responseBodyBytes, err := c.client.Post(httpclient.WithRequestCost(ctx, c.cost(method)), payload)
+	if err != nil {
+		return newError(method, err)
+	}
 
-func getRequestPayload(method string, params []interface{}) []byte {
-	payload := struct {
-		Jsonrpc string        `json:"jsonrpc"`
-		Method  string        `json:"method"`
-		Params  []interface{} `json:"params"`
-		Id      int           `json:"id"`
-	}{
+	var response rpcResponse
+	if err := json.Unmarshal(responseBodyBytes, &response); err != nil {
+		return newError(method, fmt.Errorf("failed to unmarshal response: %w", err))
+	}
+
+	return response.decode(method, result)
+}
+
+// rpcRequest is a JSON-RPC request, the id is unique to match the response of a batch.
+type rpcRequest struct {
+	Jsonrpc string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+	Id      uint64        `json:"id"`
+}
+
+type rpcResponse struct {
+	Result  json.RawMessage `json:"result"`
+	Error   *RPCError       `json:"error"`
+	Jsonrpc string          `json:"jsonrpc"`
+	Id      uint64          `json:"id"`
+}
+
+func (c *EthClient) newRequest(method string, params []interface{}) *rpcRequest {
+	return &rpcRequest{
 		Jsonrpc: "2.0",
 		Method:  method,
 		Params:  params,
-		Id:      1,
+		Id:      c.nextId.Add(1),
+	}
+}
+
+// decode decodes the result of the response into result, or returns the error of the response.
+func (r *rpcResponse) decode(method string, result interface{}) error {
+	if r.Error != nil {
+		return newError(method, r.Error)
 	}
 
-	if payloadBytes, err := json.Marshal(payload); err != nil {
-		return nil
-	} else {
-		return payloadBytes
+	if len(r.Result) == 0 || string(r.Result) == "null" {
+		return &Error{Method: method, Kind: ErrNotFound}
 	}
+
+	if err := json.Unmarshal(r.Result, result); err != nil {
+		return newError(method, fmt.Errorf("failed to unmarshal result: %w", err))
+	}
+
+	return nil
 }
@@ -0,0 +1,144 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+)
+
+func newServer(t *testing.T, handler http.HandlerFunc) *rpc.EthClient {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return rpc.NewEthClient(server.URL)
+}
+
+func TestEthClientErrors(t *testing.T) {
+	t.Run("Rate Limited", func(t *testing.T) {
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+		})
+
+		_, err := client.GetLatestBlock(context.Background())
+		if !errors.Is(err, rpc.ErrRateLimited) {
+			t.Errorf("failed to classify HTTP 429, expected rate limited, got %v", err)
+		}
+		if rpc.RetryAfter(err) != 30*time.Second {
+			t.Errorf("failed to read Retry-After, expected 30s, got %v", rpc.RetryAfter(err))
+		}
+	})
+
+	t.Run("Server Error", func(t *testing.T) {
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		})
+
+		_, err := client.GetBlockByNumber(context.Background(), big.NewInt(1))
+		if !errors.Is(err, rpc.ErrNodeUnavailable) {
+			t.Errorf("failed to classify HTTP 502, expected node unavailable, got %v", err)
+		}
+	})
+
+	t.Run("Method Not Supported", func(t *testing.T) {
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"the method eth_getBlockReceipts does not exist/is not available"}}`))
+		})
+
+		_, err := client.GetBlockReceipts(context.Background(), big.NewInt(1))
+		if !errors.Is(err, rpc.ErrMethodNotSupported) {
+			t.Errorf("failed to classify error -32601, expected method not supported, got %v", err)
+		}
+
+		var rpcErr *rpc.RPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != -32601 {
+			t.Errorf("failed to keep the JSON-RPC error, got %v", err)
+		}
+	})
+
+	t.Run("Rate Limited Error Object", func(t *testing.T) {
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"daily request count exceeded, request rate limited"}}`))
+		})
+
+		_, err := client.GetLatestBlock(context.Background())
+		if !errors.Is(err, rpc.ErrRateLimited) {
+			t.Errorf("failed to classify error -32005, expected rate limited, got %v", err)
+		}
+	})
+
+	t.Run("Node Behind", func(t *testing.T) {
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"header not found"}}`))
+		})
+
+		_, err := client.GetBlockByNumber(context.Background(), big.NewInt(1))
+		if !errors.Is(err, rpc.ErrNodeBehind) {
+			t.Errorf("failed to classify header not found, expected node behind, got %v", err)
+		}
+	})
+
+	t.Run("Block Tag Not Found", func(t *testing.T) {
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"safe block not found"}}`))
+		})
+
+		_, err := client.GetBlockHeaderByTag(context.Background(), rpc.BlockTagSafe)
+		if !errors.Is(err, rpc.ErrNotFound) || errors.Is(err, rpc.ErrNodeBehind) {
+			t.Errorf("failed to classify safe block not found, expected not found, got %v", err)
+		}
+	})
+
+	t.Run("Logs Over The Limits", func(t *testing.T) {
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"query returned more than 10000 results"}}`))
+		})
+
+		_, err := client.GetLogs(context.Background(), big.NewInt(0), big.NewInt(1000000))
+		if !errors.Is(err, rpc.ErrCallRejected) {
+			t.Errorf("failed to classify too many logs, expected call rejected, got %v", err)
+		}
+	})
+
+	t.Run("Null Result", func(t *testing.T) {
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+		})
+
+		block, err := client.GetBlockByNumber(context.Background(), big.NewInt(1))
+		if !errors.Is(err, rpc.ErrNotFound) {
+			t.Errorf("failed to classify null result, expected not found, got %v", err)
+		}
+		if block != nil {
+			t.Errorf("failed to return nil block on null result, got %v", block)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+			// the node hangs until the request is cancelled
+			// the disconnection is only noticed once the body is read
+			_, _ = io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := client.GetBlockNumber(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("failed to interrupt the call, expected deadline exceeded, got %v", err)
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("failed to interrupt the call, took %v", time.Since(start))
+		}
+	})
+}
@@ -0,0 +1,449 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
+	"github.com/hoangan/superwallet/pkg/httpclient"
+)
+
+const (
+	// number of blocks an endpoint can be behind the highest head before it is ejected
+	defaultMaxLag = 5
+
+	// number of consecutive failures before an endpoint is ejected
+	defaultMaxFailures = 3
+
+	// average latency of the calls and probes above which an endpoint is ejected
+	defaultMaxLatency = 5 * time.Second
+
+	defaultProbeInterval = 15 * time.Second
+)
+
+// Transport sends the JSON-RPC payload to the node and returns the response body.
+type Transport interface {
+	Post(ctx context.Context, body []byte) ([]byte, error)
+}
+
+// Endpoint is a node of the pool. The endpoints of the lowest priority are used first,
+// the calls are shared among the endpoints of the same priority by their weight.
+type Endpoint struct {
+	URL      string
+	Priority int
+	Weight   int
+
+	// MaxLatency is the average latency above which the endpoint is ejected, defaultMaxLatency when not set.
+	MaxLatency time.Duration
+
+	// RateLimit bounds the calls to stay within the plan of the provider, no limit by default.
+	RateLimit httpclient.RateLimit
+
+	// Auth are the credentials of the endpoint, kept out of the URL.
+	Auth httpclient.Auth
+}
+
+// ParseEndpoints parses a comma separated list of endpoints. The priority, weight and rate limit are given
+// in the URL fragment, which is never sent to the node, e.g: https://node.example#priority=1&weight=2&maxLatency=2s&rps=10&burst=20&budget=100000.
+// Endpoints without priority are given the priority of their position in the list.
+// The credentials are loaded from the environment variables of the auth prefix, e.g: #auth=GETH, see httpclient.AuthFromEnv.
+func ParseEndpoints(endpoints string) ([]Endpoint, error) {
+	var parsed []Endpoint
+	for position, endpoint := range strings.Split(endpoints, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			continue
+		}
+
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse endpoint %s: %w", endpoint, err)
+		}
+
+		settings, err := url.ParseQuery(u.Fragment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse settings of endpoint %s: %w", endpoint, err)
+		}
+		u.Fragment = ""
+
+		e := Endpoint{URL: u.String(), Priority: position, Weight: 1}
+		if priority := settings.Get("priority"); priority != "" {
+			if e.Priority, err = strconv.Atoi(priority); err != nil {
+				return nil, fmt.Errorf("failed to parse priority of endpoint %s: %w", endpoint, err)
+			}
+		}
+		if weight := settings.Get("weight"); weight != "" {
+			if e.Weight, err = strconv.Atoi(weight); err != nil || e.Weight <= 0 {
+				return nil, fmt.Errorf("invalid weight of endpoint %s", endpoint)
+			}
+		}
+		if maxLatency := settings.Get("maxLatency"); maxLatency != "" {
+			if e.MaxLatency, err = time.ParseDuration(maxLatency); err != nil || e.MaxLatency <= 0 {
+				return nil, fmt.Errorf("invalid maxLatency of endpoint %s", endpoint)
+			}
+		}
+		if rps := settings.Get("rps"); rps != "" {
+			if e.RateLimit.RequestsPerSecond, err = strconv.ParseFloat(rps, 64); err != nil || e.RateLimit.RequestsPerSecond <= 0 {
+				return nil, fmt.Errorf("invalid rps of endpoint %s", endpoint)
+			}
+			// one second worth of requests by default
+			e.RateLimit.Burst = max(int(e.RateLimit.RequestsPerSecond), 1)
+		}
+		if burst := settings.Get("burst"); burst != "" {
+			if e.RateLimit.Burst, err = strconv.Atoi(burst); err != nil || e.RateLimit.Burst <= 0 {
+				return nil, fmt.Errorf("invalid burst of endpoint %s", endpoint)
+			}
+		}
+		if prefix := settings.Get("auth"); prefix != "" {
+			if e.Auth, err = httpclient.AuthFromEnv(prefix); err != nil {
+				return nil, fmt.Errorf("failed to load credentials of endpoint %s: %w", endpoint, err)
+			}
+		}
+		if budget := settings.Get("budget"); budget != "" {
+			if e.RateLimit.DailyBudget, err = strconv.ParseUint(budget, 10, 64); err != nil {
+				return nil, fmt.Errorf("failed to parse budget of endpoint %s: %w", endpoint, err)
+			}
+		}
+
+		parsed = append(parsed, e)
+	}
+
+	return parsed, nil
+}
+
+// EndpointStats are the statistics of an endpoint of the pool.
+type EndpointStats struct {
+	Endpoint
+	Healthy   bool
+	Requests  uint64
+	Failures  uint64
+	Latency   time.Duration // moving average of the calls and probes
+	HeadBlock uint64        // head block of the last successful probe
+	LastError string
+	Circuit   httpclient.CircuitState
+	Usage     httpclient.Usage // requests sent and credits spent, including the retries
+}
+
+type poolEndpoint struct {
+	Endpoint
+	client              *httpclient.Client
+	healthy             bool
+	consecutiveFailures int
+	requests            uint64
+	failures            uint64
+	latency             time.Duration
+	headBlock           uint64
+	lastError           error
+}
+
+// Pool is a Transport spreading the calls over several endpoints. Endpoints failing, lagging behind
+// the highest head or slower than their max latency are ejected, and admitted again once the health probe
+// finds them caught up and fast enough.
+// A failed call is retried on the next endpoint.
+type Pool struct {
+	lock          sync.Mutex
+	endpoints     []*poolEndpoint
+	maxLag        uint64
+	maxFailures   int
+	probeInterval time.Duration
+}
+
+// NewPool creates the pool of the endpoints, the options customize the HTTP client of every endpoint,
+// e.g: the retry policy and the circuit breaker.
+func NewPool(endpoints []Endpoint, opts ...httpclient.Option) *Pool {
+	pool := &Pool{
+		maxLag:        defaultMaxLag,
+		maxFailures:   defaultMaxFailures,
+		probeInterval: defaultProbeInterval,
+	}
+
+	for _, endpoint := range endpoints {
+		if endpoint.Weight <= 0 {
+			endpoint.Weight = 1
+		}
+		if endpoint.MaxLatency <= 0 {
+			endpoint.MaxLatency = defaultMaxLatency
+		}
+		pool.endpoints = append(pool.endpoints, &poolEndpoint{
+			Endpoint: endpoint,
+			client:   httpclient.NewHttpClient(endpoint.URL, append(opts[:len(opts):len(opts)], httpclient.WithRateLimit(endpoint.RateLimit), httpclient.WithAuth(endpoint.Auth))...),
+			healthy:  true,
+		})
+	}
+
+	return pool
+}
+
+// Post sends the payload to the healthy endpoints by priority and weight, until one of them succeeds.
+// When every endpoint is ejected, all of them are tried anyway.
+// A response with a JSON-RPC error of the node, e.g: rate limited or header not found, is a failure of the endpoint
+// as much as a server error. When every endpoint answers so, the last response is returned for the caller to decode.
+func (p *Pool) Post(ctx context.Context, body []byte) ([]byte, error) {
+	var lastErr error
+	var lastResponse []byte
+	for _, endpoint := range p.candidates() {
+		start := time.Now()
+		response, err := endpoint.client.Post(ctx, body)
+		if ctx.Err() != nil {
+			// cancelled by the caller, the endpoint is not to blame
+			return nil, ctx.Err()
+		}
+		if err != nil && !isNodeFailure(err) {
+			// the node is fine, the call is rejected, e.g: a method or a batch not supported
+			p.record(endpoint, time.Since(start), nil)
+			return nil, err
+		}
+		if err == nil {
+			err = responseFailure(response)
+		}
+
+		p.record(endpoint, time.Since(start), err)
+		if err == nil {
+			return response, nil
+		}
+		lastErr, lastResponse = err, response
+	}
+
+	if lastResponse != nil {
+		return lastResponse, nil
+	}
+	if lastErr == nil {
+		return nil, errors.New("no endpoint in the pool")
+	}
+
+	return nil, lastErr
+}
+
+// Run probes the endpoints every probe interval until the context is done.
+func (p *Pool) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		p.Probe(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Probe fetches the head block of every endpoint, ejects the endpoints failing, lagging behind the highest head
+// or whose average latency is above their max latency, and admits again the ones caught up and fast enough.
+// The ejected endpoints get no calls, their average latency is brought back down by the probes only.
+func (p *Pool) Probe(ctx context.Context) {
+	p.lock.Lock()
+	endpoints := append([]*poolEndpoint(nil), p.endpoints...)
+	p.lock.Unlock()
+
+	heads := make([]uint64, len(endpoints))
+	errs := make([]error, len(endpoints))
+	latencies := make([]time.Duration, len(endpoints))
+
+	var wg sync.WaitGroup
+	for j, endpoint := range endpoints {
+		wg.Add(1)
+		go func(j int, endpoint *poolEndpoint) {
+			defer wg.Done()
+			start := time.Now()
+			heads[j], errs[j] = probeHead(ctx, endpoint.client)
+			latencies[j] = time.Since(start)
+		}(j, endpoint)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	var highest uint64
+	for j := range endpoints {
+		if errs[j] == nil && heads[j] > highest {
+			highest = heads[j]
+		}
+	}
+
+	for j, endpoint := range endpoints {
+		p.record(endpoint, latencies[j], errs[j])
+
+		p.lock.Lock()
+		if errs[j] == nil {
+			endpoint.headBlock = heads[j]
+			lagging := heads[j]+p.maxLag < highest
+			slow := endpoint.latency > endpoint.MaxLatency
+			if lagging && endpoint.healthy {
+				fmt.Printf("endpoint %s ejected, head %d behind %d\n", endpoint.URL, heads[j], highest)
+			} else if slow && endpoint.healthy {
+				fmt.Printf("endpoint %s ejected, latency %v above %v\n", endpoint.URL, endpoint.latency, endpoint.MaxLatency)
+			} else if !lagging && !slow && !endpoint.healthy {
+				fmt.Printf("endpoint %s admitted again, head %d, latency %v\n", endpoint.URL, heads[j], endpoint.latency)
+			}
+			endpoint.healthy = !lagging && !slow
+		}
+		p.lock.Unlock()
+	}
+}
+
+// Stats returns the statistics of the endpoints, in the order they were given.
+func (p *Pool) Stats() []EndpointStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	stats := make([]EndpointStats, 0, len(p.endpoints))
+	for _, endpoint := range p.endpoints {
+		stat := EndpointStats{
+			Endpoint:  endpoint.Endpoint,
+			Healthy:   endpoint.healthy,
+			Requests:  endpoint.requests,
+			Failures:  endpoint.failures,
+			Latency:   endpoint.latency,
+			HeadBlock: endpoint.headBlock,
+			Circuit:   endpoint.client.CircuitState(),
+			Usage:     endpoint.client.Usage(),
+		}
+		if endpoint.lastError != nil {
+			stat.LastError = endpoint.lastError.Error()
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats
+}
+
+// candidates orders the endpoints to try, the healthy ones by priority,
+// shuffled by weight among the same priority.
+func (p *Pool) candidates() []*poolEndpoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	candidates := make([]*poolEndpoint, 0, len(p.endpoints))
+	for _, endpoint := range p.endpoints {
+		if endpoint.healthy {
+			candidates = append(candidates, endpoint)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, p.endpoints...)
+	}
+
+	// weighted random order: the lower the key, the sooner the endpoint is tried
+	keys := make(map[*poolEndpoint]float64, len(candidates))
+	for _, endpoint := range candidates {
+		keys[endpoint] = rand.ExpFloat64() / float64(endpoint.Weight)
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		if candidates[a].Priority != candidates[b].Priority {
+			return candidates[a].Priority < candidates[b].Priority
+		}
+		return keys[candidates[a]] < keys[candidates[b]]
+	})
+
+	return candidates
+}
+
+// record updates the statistics of the endpoint after a call, and ejects it after too many consecutive failures.
+func (p *Pool) record(endpoint *poolEndpoint, latency time.Duration, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	endpoint.requests++
+	if endpoint.latency == 0 {
+		endpoint.latency = latency
+	} else {
+		endpoint.latency = (endpoint.latency*4 + latency) / 5
+	}
+
+	if err == nil {
+		endpoint.consecutiveFailures = 0
+		return
+	}
+
+	endpoint.failures++
+	endpoint.consecutiveFailures++
+	endpoint.lastError = err
+	if endpoint.healthy && endpoint.consecutiveFailures >= p.maxFailures {
+		fmt.Printf("endpoint %s ejected after %d consecutive failures: %v\n", endpoint.URL, endpoint.consecutiveFailures, err)
+		endpoint.healthy = false
+	}
+}
+
+// isNodeFailure reports whether the call failed because of the node, e.g: unreachable, rate limited or server errors,
+// rather than because of the call itself.
+func isNodeFailure(err error) bool {
+	var statusErr *httpclient.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// responseFailure returns the JSON-RPC error of the node in the response, or in any response of a batch.
+// Most responses have no error key at all, they are not decoded.
+func responseFailure(body []byte) error {
+	if !bytes.Contains(body, []byte(`"error"`)) {
+		return nil
+	}
+
+	// the result is skipped, an error key in the result, e.g: of a trace, is not the error of the response
+	type responseError struct {
+		Error *RPCError `json:"error"`
+	}
+	var responses []*responseError
+	if err := json.Unmarshal(body, &responses); err != nil {
+		var response responseError
+		if json.Unmarshal(body, &response) != nil {
+			return nil
+		}
+		responses = []*responseError{&response}
+	}
+
+	for _, response := range responses {
+		if response != nil && response.Error != nil && isNodeError(response.Error) {
+			return newError("response", response.Error)
+		}
+	}
+
+	return nil
+}
+
+func probeHead(ctx context.Context, transport Transport) (uint64, error) {
+	payload, err := json.Marshal(&rpcRequest{Jsonrpc: "2.0", Method: "eth_blockNumber", Params: []interface{}{}, Id: 1})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	responseBodyBytes, err := transport.Post(ctx, payload)
+	if err != nil {
+		return 0, newError("eth_blockNumber", err)
+	}
+
+	var response rpcResponse
+	if err := json.Unmarshal(responseBodyBytes, &response); err != nil {
+		return 0, newError("eth_blockNumber", fmt.Errorf("failed to unmarshal response: %w", err))
+	}
+
+	var headHex string
+	if err := response.decode("eth_blockNumber", &headHex); err != nil {
+		return 0, err
+	}
+
+	head, err := hexencoder.HexToDecimal(headHex)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse head block: %w", err)
+	}
+
+	return head.Uint64(), nil
+}
@@ -0,0 +1,227 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	"github.com/hoangan/superwallet/pkg/httpclient"
+)
+
+// newEndpoint serves eth_blockNumber with the head and a null result for the other methods,
+// or fails every call with the status code when it is not zero.
+func newEndpoint(t *testing.T, head *atomic.Int64, statusCode *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code := statusCode.Load(); code != 0 {
+			http.Error(w, "unavailable", int(code))
+			return
+		}
+
+		var request batchRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		response := map[string]interface{}{"jsonrpc": "2.0", "id": request.Id, "result": nil}
+		if request.Method == "eth_blockNumber" {
+			response["result"] = fmt.Sprintf("0x%x", head.Load())
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestPool(t *testing.T) {
+	t.Run("Parse Endpoints", func(t *testing.T) {
+		t.Setenv("NODE_B_BEARER_TOKEN", "secret")
+		endpoints, err := rpc.ParseEndpoints("https://a.example/v1, https://b.example#priority=0&weight=3&maxLatency=2s&rps=2.5&budget=1000&auth=NODE_B")
+		if err != nil {
+			t.Fatalf("failed to parse endpoints: %v", err)
+		}
+
+		expected := []rpc.Endpoint{
+			{URL: "https://a.example/v1", Priority: 0, Weight: 1},
+			{URL: "https://b.example", Priority: 0, Weight: 3, MaxLatency: 2 * time.Second, RateLimit: httpclient.RateLimit{RequestsPerSecond: 2.5, Burst: 2, DailyBudget: 1000}, Auth: httpclient.Auth{BearerToken: "secret"}},
+		}
+		if len(endpoints) != len(expected) {
+			t.Fatalf("failed to parse endpoints, expected %d, got %d", len(expected), len(endpoints))
+		}
+		for j := range expected {
+			if !reflect.DeepEqual(endpoints[j], expected[j]) {
+				t.Errorf("failed to parse endpoint %d, expected %+v, got %+v", j, expected[j], endpoints[j])
+			}
+		}
+	})
+
+	t.Run("Failover And Ejection", func(t *testing.T) {
+		var primaryHead, secondaryHead atomic.Int64
+		var primaryStatus, secondaryStatus atomic.Int32
+		primary := newEndpoint(t, &primaryHead, &primaryStatus)
+		secondary := newEndpoint(t, &secondaryHead, &secondaryStatus)
+
+		pool := rpc.NewPool([]rpc.Endpoint{
+			{URL: primary.URL, Priority: 0},
+			{URL: secondary.URL, Priority: 1},
+		}, httpclient.WithRetryPolicy(httpclient.RetryPolicy{}))
+		client := rpc.NewEthClientWithTransport(pool)
+
+		primaryStatus.Store(http.StatusBadGateway)
+		for j := 0; j < 3; j++ {
+			if _, err := client.GetTransactionByHash(context.Background(), "0x1"); err == nil {
+				t.Errorf("failed to report the null result of the secondary endpoint")
+			}
+		}
+
+		stats := pool.Stats()
+		if stats[0].Healthy || stats[0].Failures != 3 {
+			t.Errorf("failed to eject the failing endpoint, got %+v", stats[0])
+		}
+		if !stats[1].Healthy || stats[1].Requests != 3 {
+			t.Errorf("failed to fail over to the secondary endpoint, got %+v", stats[1])
+		}
+
+		// the primary endpoint is back but lagging behind
+		primaryStatus.Store(0)
+		primaryHead.Store(90)
+		secondaryHead.Store(100)
+		pool.Probe(context.Background())
+		if stats := pool.Stats(); stats[0].Healthy || stats[0].HeadBlock != 90 {
+			t.Errorf("failed to keep the lagging endpoint ejected, got %+v", stats[0])
+		}
+
+		primaryHead.Store(100)
+		pool.Probe(context.Background())
+		if stats := pool.Stats(); !stats[0].Healthy {
+			t.Errorf("failed to admit the endpoint caught up, got %+v", stats[0])
+		}
+
+		_, _ = client.GetTransactionByHash(context.Background(), "0x1")
+		if stats := pool.Stats(); stats[0].Requests != 6 || stats[1].Requests != 5 {
+			t.Errorf("failed to route by priority, got %d and %d requests", stats[0].Requests, stats[1].Requests)
+		}
+	})
+
+	t.Run("Latency Ejection", func(t *testing.T) {
+		var delay atomic.Int64
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Duration(delay.Load()))
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x64"}`))
+		}))
+		defer slow.Close()
+
+		var head atomic.Int64
+		var status atomic.Int32
+		head.Store(100)
+		fast := newEndpoint(t, &head, &status)
+
+		pool := rpc.NewPool([]rpc.Endpoint{
+			{URL: slow.URL, Priority: 0, MaxLatency: 20 * time.Millisecond},
+			{URL: fast.URL, Priority: 1},
+		}, httpclient.WithRetryPolicy(httpclient.RetryPolicy{}))
+
+		delay.Store(int64(100 * time.Millisecond))
+		pool.Probe(context.Background())
+		if stats := pool.Stats(); stats[0].Healthy || stats[0].Latency < 20*time.Millisecond {
+			t.Errorf("failed to eject the slow endpoint, got %+v", stats[0])
+		}
+
+		// the calls go to the fast endpoint despite its lower priority
+		if _, err := rpc.NewEthClientWithTransport(pool).GetBlockNumber(context.Background()); err != nil {
+			t.Errorf("failed to call the fast endpoint: %v", err)
+		}
+		if stats := pool.Stats(); stats[0].Requests != 1 || stats[1].Requests != 2 {
+			t.Errorf("failed to route around the slow endpoint, got %d and %d requests", stats[0].Requests, stats[1].Requests)
+		}
+
+		// admitted again once the probes bring its average latency back down
+		delay.Store(0)
+		for j := 0; j < 20 && !pool.Stats()[0].Healthy; j++ {
+			pool.Probe(context.Background())
+		}
+		if stats := pool.Stats(); !stats[0].Healthy {
+			t.Errorf("failed to admit the endpoint fast again, got %+v", stats[0])
+		}
+	})
+
+	t.Run("JSON-RPC Errors", func(t *testing.T) {
+		// answers every call with 200 and the error object
+		newErrorEndpoint := func(rpcError string) *httptest.Server {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":` + rpcError + `}`))
+			}))
+			t.Cleanup(server.Close)
+			return server
+		}
+		limited := newErrorEndpoint(`{"code":-32005,"message":"daily request count exceeded, request rate limited"}`)
+		reverted := newErrorEndpoint(`{"code":3,"message":"execution reverted"}`)
+
+		var head atomic.Int64
+		var status atomic.Int32
+		healthy := newEndpoint(t, &head, &status)
+
+		pool := rpc.NewPool([]rpc.Endpoint{
+			{URL: limited.URL, Priority: 0},
+			{URL: healthy.URL, Priority: 1},
+		}, httpclient.WithRetryPolicy(httpclient.RetryPolicy{}))
+		client := rpc.NewEthClientWithTransport(pool)
+
+		for j := 0; j < 3; j++ {
+			if _, err := client.GetTransactionByHash(context.Background(), "0x1"); !errors.Is(err, rpc.ErrNotFound) {
+				t.Errorf("failed to fail over to the healthy endpoint, got %v", err)
+			}
+		}
+		stats := pool.Stats()
+		if stats[0].Healthy || stats[0].Failures != 3 {
+			t.Errorf("failed to eject the rate limited endpoint, got %+v", stats[0])
+		}
+		if stats[1].Requests != 3 || stats[1].Failures != 0 {
+			t.Errorf("failed to fail over to the healthy endpoint, got %+v", stats[1])
+		}
+
+		// every endpoint rate limited, the error is returned to the caller
+		limitedPool := rpc.NewPool([]rpc.Endpoint{{URL: limited.URL}}, httpclient.WithRetryPolicy(httpclient.RetryPolicy{}))
+		if _, err := rpc.NewEthClientWithTransport(limitedPool).GetBlockNumber(context.Background()); !errors.Is(err, rpc.ErrRateLimited) {
+			t.Errorf("failed to report the rate limited call, got %v", err)
+		}
+
+		// a reverted call is not a failure of the node
+		revertedPool := rpc.NewPool([]rpc.Endpoint{{URL: reverted.URL}}, httpclient.WithRetryPolicy(httpclient.RetryPolicy{}))
+		for j := 0; j < 3; j++ {
+			_, err := rpc.NewEthClientWithTransport(revertedPool).GetTokenBalance(context.Background(), "0x1", "0x2", big.NewInt(1))
+			if !errors.Is(err, rpc.ErrCallRejected) {
+				t.Errorf("failed to classify the reverted call, got %v", err)
+			}
+		}
+		if stats := revertedPool.Stats(); !stats[0].Healthy || stats[0].Failures != 0 {
+			t.Errorf("failed to keep the endpoint rejecting the call healthy, got %+v", stats[0])
+		}
+	})
+
+	t.Run("Method Costs", func(t *testing.T) {
+		var head atomic.Int64
+		var status atomic.Int32
+		server := newEndpoint(t, &head, &status)
+
+		pool := rpc.NewPool([]rpc.Endpoint{{URL: server.URL}})
+		client := rpc.NewEthClientWithTransport(pool, rpc.WithMethodCosts(map[string]int{"eth_getTransactionByHash": 7}))
+
+		_, _ = client.GetTransactionByHash(context.Background(), "0x1")
+		_, _ = client.GetBlockNumber(context.Background())
+
+		if usage := pool.Stats()[0].Usage; usage.Requests != 2 || usage.Credits != 8 {
+			t.Errorf("failed to count the method costs, got %+v", usage)
+		}
+	})
+}
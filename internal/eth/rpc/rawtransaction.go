@@ -23,6 +23,15 @@ type RawBlock struct {
 	TransactionsRoot string            `json:"transactionsRoot"`
 }
 
+// RawBlockHeader is the block without its transactions,
+// enough to follow the chain by the block and parent hashes.
+type RawBlockHeader struct {
+	Hash       string `json:"hash"`
+	Number     string `json:"number"`
+	ParentHash string `json:"parentHash"`
+	Timestamp  string `json:"timestamp"`
+}
+
 type RawTransaction struct {
 	Type                 string `json:"type"`
 	BlockHash            string `json:"blockHash"`
@@ -42,7 +51,107 @@ type RawTransaction struct {
 	MaxFeePerGas         string `json:"maxFeePerGas"`
 	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas"`
 	ChainId              string `json:"chainId"`
+
+	// EIP-2930 access list, since type 1
+	AccessList []*RawAccessTuple `json:"accessList"`
+
+	// EIP-4844 blob fields, type 3 only
+	MaxFeePerBlobGas    string   `json:"maxFeePerBlobGas"`
+	BlobVersionedHashes []string `json:"blobVersionedHashes"`
+
+	// EIP-7702 authorization list, type 4 only
+	AuthorizationList []*RawAuthorization `json:"authorizationList"`
+}
+
+// RawAccessTuple is an address and its storage keys the transaction plans to access.
+type RawAccessTuple struct {
+	Address     string   `json:"address"`
+	StorageKeys []string `json:"storageKeys"`
+}
+
+// RawAuthorization is a signed delegation of an account code to a contract.
+type RawAuthorization struct {
+	ChainId string `json:"chainId"`
+	Address string `json:"address"`
+	Nonce   string `json:"nonce"`
+	YParity string `json:"yParity"`
+	R       string `json:"r"`
+	S       string `json:"s"`
 }
 
-// InternalTransactionDetail is for transaction with internal transfer
-type InternalTransactionDetail struct{}
+// RawLog is an event emitted by a contract during a transaction execution.
+type RawLog struct {
+	Address          string   `json:"address"`
+	Topics           []string `json:"topics"`
+	Data             string   `json:"data"`
+	BlockNumber      string   `json:"blockNumber"`
+	BlockHash        string   `json:"blockHash"`
+	TransactionHash  string   `json:"transactionHash"`
+	TransactionIndex string   `json:"transactionIndex"`
+	LogIndex         string   `json:"logIndex"`
+	Removed          bool     `json:"removed"`
+}
+
+// RawReceipt is the result of a transaction execution.
+type RawReceipt struct {
+	Type              string    `json:"type"`
+	TransactionHash   string    `json:"transactionHash"`
+	TransactionIndex  string    `json:"transactionIndex"`
+	BlockHash         string    `json:"blockHash"`
+	BlockNumber       string    `json:"blockNumber"`
+	From              string    `json:"from"`
+	To                string    `json:"to"`
+	Status            string    `json:"status"`
+	Root              string    `json:"root"` // post-transaction state root, instead of the status before Byzantium
+	GasUsed           string    `json:"gasUsed"`
+	CumulativeGasUsed string    `json:"cumulativeGasUsed"`
+	EffectiveGasPrice string    `json:"effectiveGasPrice"`
+	BlobGasUsed       string    `json:"blobGasUsed"`
+	BlobGasPrice      string    `json:"blobGasPrice"`
+	ContractAddress   string    `json:"contractAddress"`
+	Logs              []*RawLog `json:"logs"`
+	LogsBloom         string    `json:"logsBloom"`
+}
+
+// RawCallFrame is a call frame of the debug callTracer, nested by the sub calls it made.
+// The top level frame is the transaction itself.
+type RawCallFrame struct {
+	Type    string          `json:"type"`
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Value   string          `json:"value"`
+	Gas     string          `json:"gas"`
+	GasUsed string          `json:"gasUsed"`
+	Input   string          `json:"input"`
+	Output  string          `json:"output"`
+	Error   string          `json:"error"`
+	Calls   []*RawCallFrame `json:"calls"`
+}
+
+// RawTransactionTrace is the callTracer result of a transaction in debug_traceBlockByNumber.
+type RawTransactionTrace struct {
+	TxHash string        `json:"txHash"`
+	Result *RawCallFrame `json:"result"`
+	Error  string        `json:"error"`
+}
+
+// RawTrace is a flat trace of trace_block, the position of the call in the call tree
+// is given by the trace address, empty for the transaction itself.
+type RawTrace struct {
+	Action struct {
+		CallType      string `json:"callType"`
+		From          string `json:"from"`
+		To            string `json:"to"`
+		Value         string `json:"value"`
+		Address       string `json:"address"`       // selfdestructed contract
+		RefundAddress string `json:"refundAddress"` // selfdestruct beneficiary
+		Balance       string `json:"balance"`       // selfdestructed balance
+	} `json:"action"`
+	Result *struct {
+		Address string `json:"address"` // created contract
+	} `json:"result"`
+	Error           string `json:"error"`
+	TraceAddress    []int  `json:"traceAddress"`
+	TransactionHash string `json:"transactionHash"`
+	Type            string `json:"type"` // call, create, suicide, reward
+}
@@ -0,0 +1,155 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// the connection is considered dead without any new head for this long,
+	// mainnet produces a block every 12 seconds
+	headsReadTimeout = 60 * time.Second
+
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// HeadsSubscriber subscribes to the new heads of the node with eth_subscribe over WebSocket,
+// reconnecting and subscribing again whenever the connection drops.
+// The WebSocket only wakes up the indexer, the calls are sent over HTTP through the Pool.
+type HeadsSubscriber struct {
+	url       string
+	heads     chan *RawBlockHeader
+	connected atomic.Bool
+}
+
+func NewHeadsSubscriber(url string) *HeadsSubscriber {
+	return &HeadsSubscriber{
+		url: url,
+		// only the latest head matters to wake up the indexer, a head not received yet is replaced by the newer one
+		heads: make(chan *RawBlockHeader, 1),
+	}
+}
+
+// Heads returns the channel receiving the new heads.
+func (s *HeadsSubscriber) Heads() <-chan *RawBlockHeader {
+	return s.heads
+}
+
+// Connected reports whether the subscription is up, the new heads are not received otherwise.
+func (s *HeadsSubscriber) Connected() bool {
+	return s.connected.Load()
+}
+
+// Run keeps the subscription up until the context is done.
+func (s *HeadsSubscriber) Run(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		err := s.subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if s.connected.Swap(false) {
+			// the subscription was up, start over with the shortest delay
+			delay = minReconnectDelay
+		}
+		fmt.Printf("new heads subscription to %s failed: %v. Reconnect in %v...\n", s.url, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// subscribe connects to the node and forwards the new heads until the connection fails.
+func (s *HeadsSubscriber) subscribe(ctx context.Context) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	// unblock the read when the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	request := &rpcRequest{Jsonrpc: "2.0", Method: "eth_subscribe", Params: []interface{}{"newHeads"}, Id: 1}
+	if err := conn.WriteJSON(request); err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	var response rpcResponse
+	if err := s.read(conn, &response); err != nil {
+		return fmt.Errorf("failed to read subscription: %w", err)
+	}
+
+	var subscriptionId string
+	if err := response.decode("eth_subscribe", &subscriptionId); err != nil {
+		return err
+	}
+
+	s.connected.Store(true)
+
+	for {
+		var notification struct {
+			Method string `json:"method"`
+			Params struct {
+				Subscription string          `json:"subscription"`
+				Result       *RawBlockHeader `json:"result"`
+			} `json:"params"`
+		}
+		if err := s.read(conn, &notification); err != nil {
+			return fmt.Errorf("failed to read new head: %w", err)
+		}
+
+		if notification.Method != "eth_subscription" || notification.Params.Subscription != subscriptionId || notification.Params.Result == nil {
+			continue
+		}
+
+		s.publish(notification.Params.Result)
+	}
+}
+
+// publish sends the head without blocking, the head still waiting in the channel is dropped for the newer one.
+// subscribe is the only sender, so the channel has room once drained.
+func (s *HeadsSubscriber) publish(head *RawBlockHeader) {
+	select {
+	case <-s.heads:
+	default:
+	}
+
+	select {
+	case s.heads <- head:
+	default:
+	}
+}
+
+func (s *HeadsSubscriber) read(conn *websocket.Conn, message interface{}) error {
+	if err := conn.SetReadDeadline(time.Now().Add(headsReadTimeout)); err != nil {
+		return err
+	}
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, message)
+}
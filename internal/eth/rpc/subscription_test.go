@@ -0,0 +1,51 @@
+package rpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	"github.com/hoangan/superwallet/internal/testdata"
+)
+
+func TestHeadsSubscriber(t *testing.T) {
+	node := testdata.NewNode()
+	defer node.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriber := rpc.NewHeadsSubscriber(node.WSURL())
+	go subscriber.Run(ctx)
+
+	deadline := time.Now().Add(time.Second)
+	for !subscriber.Connected() || node.Subscriptions() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to subscribe to new heads")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Run("Latest Head Kept", func(t *testing.T) {
+		// the heads are not received meanwhile, the newer one replaces the older one
+		node.SetBlock(testdata.NewRawBlock(1, "0xa1", "0xa0"))
+		node.SetBlock(testdata.NewRawBlock(2, "0xa2", "0xa1"))
+		time.Sleep(100 * time.Millisecond)
+
+		select {
+		case head := <-subscriber.Heads():
+			if head.Hash != "0xa2" {
+				t.Errorf("failed to keep the latest head, got %s", head.Hash)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("failed to receive the new head")
+		}
+
+		select {
+		case head := <-subscriber.Heads():
+			t.Errorf("failed to drop the older head, got %s", head.Hash)
+		default:
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package eth
+
+import (
+	"math/big"
+
+	m "github.com/hoangan/superwallet/internal/models"
+)
+
+// GetSubscription returns the subscription of the address, storage.ErrSubscriptionNotFound if none.
+func (i *EthIndexer) GetSubscription(address string) (*m.Subscription, error) {
+	return i.storage.GetSubscription(address)
+}
+
+// SetWebhook sets the webhook the events of the subscribed address are delivered to, removed when nil.
+// The webhooks pointing at non-public hosts are rejected with m.ErrPrivateWebhook unless allowed.
+func (i *EthIndexer) SetWebhook(address string, webhook *m.Webhook) error {
+	if webhook != nil {
+		if err := webhook.Validate(); err != nil {
+			return err
+		}
+		if !i.privateWebhooks {
+			if err := webhook.CheckPublicHost(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return i.storage.SetWebhook(address, webhook)
+}
+
+// PauseAddress stops recording the new transactions of the address, its history is kept.
+// The backfill jobs of the address are canceled, the gap is backfilled once resumed.
+func (i *EthIndexer) PauseAddress(address string) error {
+	// The block in flight is recorded for the address or not at all, the last block is the gap start.
+	i.commitLock.Lock()
+	defer i.commitLock.Unlock()
+
+	if err := i.storage.PauseAddress(address, i.GetCurrentBlock()); err != nil {
+		return err
+	}
+	i.cancelBackfills(address, errAddressPaused)
+
+	return nil
+}
+
+// UnsubscribeAddress stops watching the address, its history is purged when purge is set, kept otherwise.
+// The backfill jobs of the address are canceled.
+func (i *EthIndexer) UnsubscribeAddress(address string, purge bool) error {
+	i.commitLock.Lock()
+	defer i.commitLock.Unlock()
+
+	if err := i.storage.UnsubscribeAddress(address, i.GetCurrentBlock(), purge); err != nil {
+		return err
+	}
+	i.cancelBackfills(address, errAddressUnsubscribed)
+
+	return nil
+}
+
+// ResumeAddress records the new transactions of a paused or unsubscribed address again.
+// The transactions of the blocks indexed meanwhile are backfilled when backfill is set, the job is returned then.
+// The balances are seeded again from the node either way.
+func (i *EthIndexer) ResumeAddress(address string, backfill bool) (*m.BackfillJob, error) {
+	subscription, err := i.storage.GetSubscription(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if !backfill || subscription.State == m.SubscriptionStateActive || subscription.LastBlock == nil {
+		return nil, i.SubscribeAddress(address)
+	}
+
+	return i.SubscribeAddressFrom(address, new(big.Int).Add(subscription.LastBlock, big.NewInt(1)))
+}
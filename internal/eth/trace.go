@@ -0,0 +1,227 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
+)
+
+// tracing methods of the node, detected on the first traced block
+const (
+	traceMethodUnknown int32 = iota
+	traceMethodDebug         // debug_traceBlockByNumber with callTracer
+	traceMethodParity        // trace_block
+	traceMethodNone          // the node does not support tracing
+)
+
+// fetchInternalTransfers traces the block to find the ETH transferred by contracts,
+// e.g: multisig wallets or exchange batch withdrawals, grouped by transaction hash.
+// The tracing method is detected on the first call, in case the node supports none of them
+// the internal transfers are not indexed.
+func (i *EthIndexer) fetchInternalTransfers(ctx context.Context, blockNumber *big.Int) (map[string][]*m.Transfer, error) {
+	switch i.traceMethod.Load() {
+	case traceMethodDebug:
+		return i.fetchCallTraces(ctx, blockNumber)
+	case traceMethodParity:
+		return i.fetchParityTraces(ctx, blockNumber)
+	case traceMethodNone:
+		return nil, nil
+	}
+
+	if transfers, err := i.fetchCallTraces(ctx, blockNumber); !errors.Is(err, rpc.ErrMethodNotSupported) {
+		if err == nil {
+			i.traceMethod.Store(traceMethodDebug)
+		}
+		return transfers, err
+	}
+
+	if transfers, err := i.fetchParityTraces(ctx, blockNumber); !errors.Is(err, rpc.ErrMethodNotSupported) {
+		if err == nil {
+			i.traceMethod.Store(traceMethodParity)
+		}
+		return transfers, err
+	}
+
+	fmt.Printf("node does not support debug_traceBlockByNumber nor trace_block, internal transactions are not indexed\n")
+	i.traceMethod.Store(traceMethodNone)
+
+	return nil, nil
+}
+
+func (i *EthIndexer) fetchCallTraces(ctx context.Context, blockNumber *big.Int) (map[string][]*m.Transfer, error) {
+	traces, err := i.client.TraceBlockByNumber(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := make(map[string][]*m.Transfer)
+	for _, trace := range traces {
+		txTransfers, err := i.ParseCallTrace(trace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse call trace of transaction %s: %w", trace.TxHash, err)
+		}
+
+		if len(txTransfers) > 0 {
+			transfers[trace.TxHash] = txTransfers
+		}
+	}
+
+	return transfers, nil
+}
+
+func (i *EthIndexer) fetchParityTraces(ctx context.Context, blockNumber *big.Int) (map[string][]*m.Transfer, error) {
+	traces, err := i.client.TraceBlock(ctx, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.ParseParityTraces(traces)
+}
+
+// ParseCallTrace decodes the nested call frames of a transaction traced by the callTracer
+// into the internal transfers of ETH. The top level frame is the transaction itself, already parsed
+// as the native transfer. Reverted frames and all their sub calls do not transfer any value.
+func (i *EthIndexer) ParseCallTrace(trace *rpc.RawTransactionTrace) ([]*m.Transfer, error) {
+	transfers := []*m.Transfer{}
+	if trace.Result == nil || trace.Result.Error != "" {
+		return transfers, nil
+	}
+
+	var walk func(frames []*rpc.RawCallFrame) error
+	walk = func(frames []*rpc.RawCallFrame) error {
+		for _, frame := range frames {
+			if frame.Error != "" {
+				continue
+			}
+
+			// DELEGATECALL, STATICCALL and CALLCODE run code in the context of the caller, no value moves
+			switch strings.ToUpper(frame.Type) {
+			case "CALL", "CREATE", "CREATE2", "SELFDESTRUCT":
+				transfer, err := newInternalTransfer(frame.From, frame.To, frame.Value)
+				if err != nil {
+					return err
+				}
+				if transfer != nil {
+					transfers = append(transfers, transfer)
+				}
+			}
+
+			if err := walk(frame.Calls); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := walk(trace.Result.Calls); err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+// ParseParityTraces decodes the flat traces of a block from trace_block into the internal transfers of ETH,
+// grouped by transaction hash. The traces with an empty trace address are the transactions themselves,
+// already parsed as the native transfers. Reverted traces and all their sub traces do not transfer any value.
+func (i *EthIndexer) ParseParityTraces(traces []*rpc.RawTrace) (map[string][]*m.Transfer, error) {
+	transfers := make(map[string][]*m.Transfer)
+
+	// trace addresses of the reverted traces, by transaction hash
+	reverted := make(map[string][]string)
+	for _, trace := range traces {
+		// block and uncle rewards of the pre-merge blocks are not part of any transaction
+		if trace.TransactionHash == "" {
+			continue
+		}
+
+		traceAddress := traceAddressKey(trace.TraceAddress)
+		if isRevertedTrace(reverted[trace.TransactionHash], traceAddress) {
+			continue
+		}
+
+		if trace.Error != "" {
+			reverted[trace.TransactionHash] = append(reverted[trace.TransactionHash], traceAddress)
+			continue
+		}
+
+		if len(trace.TraceAddress) == 0 {
+			continue
+		}
+
+		var transfer *m.Transfer
+		var err error
+		switch trace.Type {
+		case "call":
+			if trace.Action.CallType == "call" {
+				transfer, err = newInternalTransfer(trace.Action.From, trace.Action.To, trace.Action.Value)
+			}
+		case "create":
+			if trace.Result != nil {
+				transfer, err = newInternalTransfer(trace.Action.From, trace.Result.Address, trace.Action.Value)
+			}
+		case "suicide":
+			transfer, err = newInternalTransfer(trace.Action.Address, trace.Action.RefundAddress, trace.Action.Balance)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trace of transaction %s: %w", trace.TransactionHash, err)
+		}
+
+		if transfer != nil {
+			transfers[trace.TransactionHash] = append(transfers[trace.TransactionHash], transfer)
+		}
+	}
+
+	return transfers, nil
+}
+
+// newInternalTransfer creates the internal transfer of ETH, nil when no value is transferred.
+func newInternalTransfer(from string, to string, valueHex string) (*m.Transfer, error) {
+	if valueHex == "" {
+		return nil, nil
+	}
+
+	value, err := hexencoder.HexToDecimal(valueHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse internal transfer value: %w", err)
+	}
+
+	if value.Sign() == 0 {
+		return nil, nil
+	}
+
+	return &m.Transfer{
+		CoinID:   coinId,
+		Ticker:   coinTicker,
+		From:     strings.ToLower(from),
+		To:       strings.ToLower(to),
+		Value:    value,
+		Internal: true,
+	}, nil
+}
+
+// traceAddressKey formats the trace address as a path, e.g: [0 2 1] as "0/2/1/".
+func traceAddressKey(traceAddress []int) string {
+	var key strings.Builder
+	for _, index := range traceAddress {
+		fmt.Fprintf(&key, "%d/", index)
+	}
+	return key.String()
+}
+
+// isRevertedTrace reports whether the trace is a sub trace of any of the reverted traces.
+func isRevertedTrace(revertedTraceAddresses []string, traceAddress string) bool {
+	for _, reverted := range revertedTraceAddresses {
+		if strings.HasPrefix(traceAddress, reverted) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package eth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
+)
+
+// transaction types
+const (
+	legacyTxType     = 0 // pre EIP-2718
+	accessListTxType = 1 // EIP-2930
+	dynamicFeeTxType = 2 // EIP-1559
+	blobTxType       = 3 // EIP-4844
+	setCodeTxType    = 4 // EIP-7702
+)
+
+// parseTypedFields parses the fee market and the type specific fields of the transaction by its type.
+// Every field a type requires must be there, unknown types get the fields they have.
+func parseTypedFields(tx *m.Transaction, rawTxn *rpc.RawTransaction) error {
+	var err error
+
+	switch tx.Type.Int64() {
+	case legacyTxType:
+		if tx.GasPrice, err = hexencoder.HexToDecimal(rawTxn.GasPrice); err != nil {
+			return fmt.Errorf("failed to parse gas price: %w", err)
+		}
+
+	case accessListTxType:
+		if tx.GasPrice, err = hexencoder.HexToDecimal(rawTxn.GasPrice); err != nil {
+			return fmt.Errorf("failed to parse gas price: %w", err)
+		}
+		tx.AccessList = parseAccessList(rawTxn.AccessList)
+
+	case dynamicFeeTxType, blobTxType, setCodeTxType:
+		if err := parseDynamicFee(tx, rawTxn); err != nil {
+			return err
+		}
+		tx.AccessList = parseAccessList(rawTxn.AccessList)
+
+		if tx.Type.Int64() == blobTxType {
+			if tx.MaxFeePerBlobGas, err = hexencoder.HexToDecimal(rawTxn.MaxFeePerBlobGas); err != nil {
+				return fmt.Errorf("failed to parse max fee per blob gas: %w", err)
+			}
+			tx.BlobVersionedHashes = rawTxn.BlobVersionedHashes
+		}
+
+		if tx.Type.Int64() == setCodeTxType {
+			if tx.AuthorizationList, err = parseAuthorizationList(rawTxn.AuthorizationList); err != nil {
+				return err
+			}
+		}
+
+	default:
+		// a type not known yet, or a chain specific one, e.g: L2 deposit transactions
+		tx.GasPrice, _ = hexencoder.HexToDecimal(rawTxn.GasPrice)
+		tx.MaxFeePerGas, _ = hexencoder.HexToDecimal(rawTxn.MaxFeePerGas)
+		tx.MaxPriorityFeePerGas, _ = hexencoder.HexToDecimal(rawTxn.MaxPriorityFeePerGas)
+		tx.AccessList = parseAccessList(rawTxn.AccessList)
+	}
+
+	return nil
+}
+
+// parseDynamicFee parses the EIP-1559 fee fields.
+// The gas price of a dynamic fee transaction is the effective gas price, only known once it is in a block.
+func parseDynamicFee(tx *m.Transaction, rawTxn *rpc.RawTransaction) error {
+	var err error
+
+	if tx.MaxFeePerGas, err = hexencoder.HexToDecimal(rawTxn.MaxFeePerGas); err != nil {
+		return fmt.Errorf("failed to parse max fee per gas: %w", err)
+	}
+
+	if tx.MaxPriorityFeePerGas, err = hexencoder.HexToDecimal(rawTxn.MaxPriorityFeePerGas); err != nil {
+		return fmt.Errorf("failed to parse max priority fee per gas: %w", err)
+	}
+
+	if rawTxn.GasPrice != "" {
+		if tx.GasPrice, err = hexencoder.HexToDecimal(rawTxn.GasPrice); err != nil {
+			return fmt.Errorf("failed to parse gas price: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func parseAccessList(rawAccessList []*rpc.RawAccessTuple) []*m.AccessTuple {
+	accessList := make([]*m.AccessTuple, 0, len(rawAccessList))
+	for _, rawTuple := range rawAccessList {
+		accessList = append(accessList, &m.AccessTuple{
+			Address:     strings.ToLower(rawTuple.Address),
+			StorageKeys: rawTuple.StorageKeys,
+		})
+	}
+
+	return accessList
+}
+
+func parseAuthorizationList(rawAuthorizationList []*rpc.RawAuthorization) ([]*m.Authorization, error) {
+	authorizationList := make([]*m.Authorization, 0, len(rawAuthorizationList))
+	for _, rawAuthorization := range rawAuthorizationList {
+		chainId, err := hexencoder.HexToDecimal(rawAuthorization.ChainId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse authorization chain id: %w", err)
+		}
+
+		nonce, err := hexencoder.HexToDecimal(rawAuthorization.Nonce)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse authorization nonce: %w", err)
+		}
+
+		authorizationList = append(authorizationList, &m.Authorization{
+			ChainId: chainId,
+			Address: strings.ToLower(rawAuthorization.Address),
+			Nonce:   nonce,
+		})
+	}
+
+	return authorizationList, nil
+}
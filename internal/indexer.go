@@ -18,6 +18,40 @@ type Indexer interface {
 	// add address to observer
 	SubscribeAddress(address string) error
 
-	// list of inbound or outbound transactions for an address
-	GetTransactions(address string) ([]*m.Transaction, error)
+	// add address to observer and backfill its transactions of the blocks already indexed from the block,
+	// from its first activity when the block is nil
+	SubscribeAddressFrom(address string, fromBlockNumber *big.Int) (*m.BackfillJob, error)
+
+	// backfill jobs along with their progress
+	GetBackfillJobs() []*m.BackfillJob
+
+	// subscription state of an address
+	GetSubscription(address string) (*m.Subscription, error)
+
+	// set the webhook the events of a subscribed address are delivered to, removed when nil
+	SetWebhook(address string, webhook *m.Webhook) error
+
+	// stop recording the new transactions of an address, its history is kept
+	PauseAddress(address string) error
+
+	// record the new transactions of a paused or unsubscribed address again,
+	// optionally backfilling the transactions of the blocks indexed meanwhile
+	ResumeAddress(address string, backfill bool) (*m.BackfillJob, error)
+
+	// remove address from observer, its history is purged or kept
+	UnsubscribeAddress(address string, purge bool) error
+
+	// list of inbound or outbound transactions for an address,
+	// filtered by the confirmation states when any is given
+	GetTransactions(address string, states ...m.TransactionState) ([]*m.Transaction, error)
+
+	// indexed transaction by its hash
+	GetTransaction(hash string) (*m.Transaction, error)
+
+	// page of the transactions of an address matching the query, filtered by block and time range,
+	// direction, coin, minimum value and confirmation state
+	QueryTransactions(query *m.TransactionQuery) (*m.TransactionPage, error)
+
+	// balances of an address by coin, computed from the indexed transactions
+	GetBalances(address string) ([]*m.Balance, error)
 }
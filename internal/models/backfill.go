@@ -0,0 +1,67 @@
+package models
+
+import (
+	"math/big"
+	"time"
+)
+
+// BackfillState is the state of a backfill job, from queued to completed, failed or canceled.
+type BackfillState string
+
+const (
+	// BackfillStateQueued is a job waiting for the running job to be done, the jobs are run one at a time.
+	BackfillStateQueued BackfillState = "queued"
+
+	// BackfillStateRunning is a job scanning its blocks.
+	BackfillStateRunning BackfillState = "running"
+
+	// BackfillStateCompleted is a job which scanned all its blocks.
+	BackfillStateCompleted BackfillState = "completed"
+
+	// BackfillStateFailed is a job given up after too many failures, see Error.
+	BackfillStateFailed BackfillState = "failed"
+
+	// BackfillStateCanceled is a job interrupted by the indexer being stopped, or by its address being paused
+	// or unsubscribed, see Error.
+	BackfillStateCanceled BackfillState = "canceled"
+)
+
+// BackfillJob scans the blocks indexed before an address was subscribed for its transactions.
+type BackfillJob struct {
+	ID      int64  `json:"id"`
+	Address string `json:"address"`
+
+	// FromBlock is nil until the first activity of the address is found, when no start block is given.
+	FromBlock *big.Int `json:"fromBlock"`
+	ToBlock   *big.Int `json:"toBlock"`
+
+	// ScannedBlock is the last block scanned, the blocks are scanned in order.
+	ScannedBlock *big.Int      `json:"scannedBlock"`
+	Transactions int           `json:"transactions"`
+	State        BackfillState `json:"state"`
+	Error        string        `json:"error,omitempty"`
+	CreatedAt    time.Time     `json:"createdAt"`
+	FinishedAt   time.Time     `json:"finishedAt,omitempty"`
+}
+
+// Progress returns the share of the blocks scanned, from 0 to 1.
+func (j *BackfillJob) Progress() float64 {
+	if j.State == BackfillStateCompleted {
+		return 1
+	}
+	if j.FromBlock == nil || j.ScannedBlock == nil {
+		return 0
+	}
+
+	total := new(big.Int).Sub(j.ToBlock, j.FromBlock)
+	total.Add(total, big.NewInt(1))
+	if total.Sign() <= 0 {
+		return 1
+	}
+
+	scanned := new(big.Int).Sub(j.ScannedBlock, j.FromBlock)
+	scanned.Add(scanned, big.NewInt(1))
+
+	progress, _ := new(big.Float).Quo(new(big.Float).SetInt(scanned), new(big.Float).SetInt(total)).Float64()
+	return progress
+}
@@ -0,0 +1,22 @@
+package models
+
+import "math/big"
+
+const (
+	// NativeCoinID is the coin of the chain, ethereum, the fees are paid in.
+	NativeCoinID = 1
+
+	// UnknownCoinID is the coin of the transfers of the tokens not known by the indexer,
+	// their balances are not tracked.
+	UnknownCoinID = 0
+)
+
+// Balance is the balance of a subscribed address in a coin, computed from its indexed transactions.
+type Balance struct {
+	CoinID  int64    `json:"coinId"`
+	Balance *big.Int `json:"balance"`
+
+	// Seeded once the balance held before the subscription is loaded from the node,
+	// until then the balance only counts the transactions indexed since the subscription.
+	Seeded bool `json:"seeded"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "math/big"
+
+// Checkpoint is the last block fully processed by the indexer,
+// the indexer resumes from the block after it on restart.
+type Checkpoint struct {
+	BlockNumber *big.Int `json:"blockNumber"`
+	BlockHash   string   `json:"blockHash"`
+}
@@ -0,0 +1,43 @@
+package models
+
+type EventType string
+
+const (
+	// EventTransactionIndexed is emitted when a transaction of a subscribed address is indexed.
+	EventTransactionIndexed EventType = "transaction.indexed"
+
+	// EventTransactionConfirmed is emitted when a transaction of a subscribed address reaches the confirmation threshold.
+	EventTransactionConfirmed EventType = "transaction.confirmed"
+
+	// EventTransactionFinalized is emitted when a transaction of a subscribed address is in a finalized block.
+	EventTransactionFinalized EventType = "transaction.finalized"
+
+	// EventTransactionReorged is emitted when a block containing a transaction of a subscribed address
+	// is dropped from the canonical chain by a chain reorganization.
+	EventTransactionReorged EventType = "transaction.reorged"
+)
+
+// IsValid reports whether the type is one of the known event types.
+func (t EventType) IsValid() bool {
+	switch t {
+	case EventTransactionIndexed, EventTransactionConfirmed, EventTransactionFinalized, EventTransactionReorged:
+		return true
+	}
+	return false
+}
+
+// StateEventType returns the type of the event emitted when a transaction moves forward to the state.
+func StateEventType(state TransactionState) EventType {
+	if state == TransactionStateFinalized {
+		return EventTransactionFinalized
+	}
+	return EventTransactionConfirmed
+}
+
+// Event describes a change of a transaction of a subscribed address
+// which subscribers should be notified about.
+type Event struct {
+	Type        EventType    `json:"type"`
+	Address     string       `json:"address"`
+	Transaction *Transaction `json:"transaction"`
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultQueryLimit is the page size of a query without limit.
+	DefaultQueryLimit = 100
+
+	// MaxQueryLimit is the largest page size of a query.
+	MaxQueryLimit = 1000
+)
+
+// ErrInvalidCursor is returned for a cursor not returned by a previous page.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Direction is the direction of the transfers of an address.
+type Direction string
+
+const (
+	// DirectionIn is the transfers to the address.
+	DirectionIn Direction = "in"
+
+	// DirectionOut is the transfers from the address, and the transactions it sent.
+	DirectionOut Direction = "out"
+)
+
+// SortOrder is the order of the transactions of a query, by block and position in the block.
+type SortOrder string
+
+const (
+	SortAscending  SortOrder = "asc"
+	SortDescending SortOrder = "desc"
+)
+
+// TransactionQuery is a query of the transactions of an address, a page at a time.
+// The zero value of a filter matches every transaction.
+type TransactionQuery struct {
+	Address string
+
+	// Cursor is the NextCursor of the previous page, empty for the first page.
+	// The other fields must be the same for all the pages.
+	Cursor string
+
+	// Limit is the page size, DefaultQueryLimit when zero, up to MaxQueryLimit.
+	Limit int
+
+	// FromBlock and ToBlock are the block range, both included.
+	FromBlock *big.Int
+	ToBlock   *big.Int
+
+	// FromTime and ToTime are the range of the block time, FromTime included and ToTime excluded.
+	FromTime time.Time
+	ToTime   time.Time
+
+	// Direction, CoinIDs and MinValue filter the transactions by their transfers of the address:
+	// a transaction matches when any of its transfers matches all of them.
+	// A transaction sent by the address goes out, for the fee it paid, even without any transfer.
+	Direction Direction
+	CoinIDs   []int64
+	MinValue  *big.Int
+
+	States []TransactionState
+
+	// Order is SortDescending when empty, the most recent transactions first.
+	Order SortOrder
+}
+
+// TransactionPage is a page of the transactions of a query.
+type TransactionPage struct {
+	Transactions []*Transaction `json:"transactions"`
+
+	// NextCursor is the cursor of the next page, empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Validate checks the query and sets the defaults of the limit and the order.
+func (q *TransactionQuery) Validate() error {
+	if q.Limit == 0 {
+		q.Limit = DefaultQueryLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxQueryLimit {
+		return fmt.Errorf("invalid limit %d, expected up to %d", q.Limit, MaxQueryLimit)
+	}
+
+	switch q.Order {
+	case "":
+		q.Order = SortDescending
+	case SortAscending, SortDescending:
+	default:
+		return fmt.Errorf("invalid order %s", q.Order)
+	}
+
+	switch q.Direction {
+	case "", DirectionIn, DirectionOut:
+	default:
+		return fmt.Errorf("invalid direction %s", q.Direction)
+	}
+
+	for _, state := range q.States {
+		if !state.IsValid() {
+			return fmt.Errorf("invalid transaction state %s", state)
+		}
+	}
+
+	if q.Cursor != "" {
+		if _, err := DecodeCursor(q.Cursor); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FiltersTransfers reports whether the query filters the transactions by their transfers.
+func (q *TransactionQuery) FiltersTransfers() bool {
+	return q.Direction != "" || len(q.CoinIDs) > 0 || q.MinValue != nil
+}
+
+// MatchesTransfer reports whether the transfer of the address matches the transfer filters of the query.
+func (q *TransactionQuery) MatchesTransfer(coinID int64, value *big.Int, in bool, out bool) bool {
+	if (q.Direction == DirectionIn && !in) || (q.Direction == DirectionOut && !out) || (!in && !out) {
+		return false
+	}
+
+	if len(q.CoinIDs) > 0 {
+		found := false
+		for _, id := range q.CoinIDs {
+			found = found || id == coinID
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return q.MinValue == nil || (value != nil && value.Cmp(q.MinValue) >= 0)
+}
+
+// MatchesSent reports whether a transaction sent by the address matches the transfer filters of the query
+// without any of its transfers, for the fee it paid.
+func (q *TransactionQuery) MatchesSent() bool {
+	return q.Direction == DirectionOut && len(q.CoinIDs) == 0 && q.MinValue == nil
+}
+
+// MatchesState reports whether the state is one of the states of the query, any state when none is given.
+func (q *TransactionQuery) MatchesState(state TransactionState) bool {
+	if len(q.States) == 0 {
+		return true
+	}
+
+	for _, s := range q.States {
+		if s == state {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Cursor is the position of a transaction in the transactions of an address,
+// ordered by block, position in the block and hash.
+type Cursor struct {
+	BlockNumber      int64
+	TransactionIndex int64
+	Hash             string
+}
+
+// CursorOf returns the position of the transaction, a transaction without index comes first in its block.
+func CursorOf(tx *Transaction) *Cursor {
+	cursor := &Cursor{BlockNumber: tx.BlockNumber.Int64(), TransactionIndex: -1, Hash: tx.Hash}
+	if tx.TransactionIndex != nil {
+		cursor.TransactionIndex = tx.TransactionIndex.Int64()
+	}
+	return cursor
+}
+
+// Compare returns -1, 0 or 1 whether the cursor comes before, at or after the other cursor.
+func (c *Cursor) Compare(other *Cursor) int {
+	switch {
+	case c.BlockNumber != other.BlockNumber:
+		return compareInt64(c.BlockNumber, other.BlockNumber)
+	case c.TransactionIndex != other.TransactionIndex:
+		return compareInt64(c.TransactionIndex, other.TransactionIndex)
+	default:
+		return strings.Compare(c.Hash, other.Hash)
+	}
+}
+
+func compareInt64(a int64, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// Encode returns the opaque cursor of the position, given to the clients.
+func (c *Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d:%s", c.BlockNumber, c.TransactionIndex, c.Hash)))
+}
+
+// DecodeCursor returns the position of the opaque cursor, ErrInvalidCursor if malformed.
+func DecodeCursor(cursor string) (*Cursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(decoded), ":", 3)
+	if len(parts) != 3 {
+		return nil, ErrInvalidCursor
+	}
+
+	blockNumber, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	transactionIndex, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{BlockNumber: blockNumber, TransactionIndex: transactionIndex, Hash: parts[2]}, nil
+}
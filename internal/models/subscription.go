@@ -0,0 +1,30 @@
+package models
+
+import "math/big"
+
+// SubscriptionState is the state of the subscription of an address.
+type SubscriptionState string
+
+const (
+	// SubscriptionStateActive is an address whose new transactions are recorded.
+	SubscriptionStateActive SubscriptionState = "active"
+
+	// SubscriptionStatePaused is an address whose new transactions are not recorded until it is resumed.
+	SubscriptionStatePaused SubscriptionState = "paused"
+
+	// SubscriptionStateUnsubscribed is an address no longer watched, whose history has been kept.
+	SubscriptionStateUnsubscribed SubscriptionState = "unsubscribed"
+)
+
+// Subscription is the subscription of an address.
+type Subscription struct {
+	Address string            `json:"address"`
+	State   SubscriptionState `json:"state"`
+
+	// LastBlock is the last block indexed while the address was active, set once paused or unsubscribed.
+	// The blocks after it are the gap to backfill when the address is subscribed again.
+	LastBlock *big.Int `json:"lastBlock,omitempty"`
+
+	// Webhook is the endpoint the events of the address are delivered to, nil if none.
+	Webhook *Webhook `json:"webhook,omitempty"`
+}
@@ -0,0 +1,10 @@
+package models
+
+// Token is a coin issued by a contract on top of a chain, e.g: ERC-20 tokens on Ethereum.
+type Token struct {
+	// Unique coinID across the system, see Transfer
+	CoinID   int64  `json:"coinId"`
+	Ticker   string `json:"ticker"`
+	Contract string `json:"contract"`
+	Decimals int    `json:"decimals"`
+}
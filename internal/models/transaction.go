@@ -2,6 +2,39 @@ package models
 
 import "math/big"
 
+// TransactionState is the confirmation state of a transaction,
+// moving from pending to confirmed to finalized as new blocks are built on top of its block.
+type TransactionState string
+
+const (
+	// TransactionStatePending is a transaction in a block without enough confirmations yet.
+	TransactionStatePending TransactionState = "pending"
+
+	// TransactionStateConfirmed is a transaction in a block with enough confirmations,
+	// or a block considered safe by the consensus layer.
+	TransactionStateConfirmed TransactionState = "confirmed"
+
+	// TransactionStateFinalized is a transaction in a finalized block, which can not be reorged out anymore.
+	TransactionStateFinalized TransactionState = "finalized"
+)
+
+var transactionStateOrder = map[TransactionState]int{
+	TransactionStatePending:   1,
+	TransactionStateConfirmed: 2,
+	TransactionStateFinalized: 3,
+}
+
+// Before reports whether the state comes before the other state in the confirmation progress.
+func (s TransactionState) Before(other TransactionState) bool {
+	return transactionStateOrder[s] < transactionStateOrder[other]
+}
+
+// IsValid reports whether the state is one of the known transaction states.
+func (s TransactionState) IsValid() bool {
+	_, ok := transactionStateOrder[s]
+	return ok
+}
+
 type Transfer struct {
 	// Unique cointID across the system
 	// Simplify the complication of different coins within the same chain,
@@ -11,6 +44,14 @@ type Transfer struct {
 	From   string   `json:"from"`
 	To     string   `json:"to"`
 	Value  *big.Int `json:"value"`
+
+	// Token contract address and the log index of the transfer event,
+	// empty for the native coin transfers
+	Token    string   `json:"token,omitempty"`
+	LogIndex *big.Int `json:"logIndex,omitempty"`
+
+	// Internal transfer made by a contract during the transaction execution
+	Internal bool `json:"internal,omitempty"`
 }
 
 type Transaction struct {
@@ -25,11 +66,115 @@ type Transaction struct {
 	To               string   `json:"to"`
 	ChainId          *big.Int `json:"chainId"`
 	TransactionIndex *big.Int `json:"transactionIndex"`
+	Timestamp        *big.Int `json:"timestamp,omitempty"` // unix time of the block in seconds
 	Value            *big.Int `json:"value"`
 	GasPrice         *big.Int `json:"gasPrice"`
 
+	// Fee market fields of the typed transactions.
+	// Legacy (type 0) and access list (type 1) transactions pay the gas price,
+	// dynamic fee (type 2), blob (type 3) and set code (type 4) transactions pay up to the max fee per gas.
+	MaxFeePerGas         *big.Int `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *big.Int `json:"maxPriorityFeePerGas,omitempty"`
+
+	// Addresses and storage keys the transaction plans to access, since type 1
+	AccessList []*AccessTuple `json:"accessList,omitempty"`
+
+	// Blobs carried by the blob transactions, type 3 only
+	MaxFeePerBlobGas    *big.Int `json:"maxFeePerBlobGas,omitempty"`
+	BlobVersionedHashes []string `json:"blobVersionedHashes,omitempty"`
+
+	// Delegations of the account code to contracts, type 4 only
+	AuthorizationList []*Authorization `json:"authorizationList,omitempty"`
+
+	// Confirmation state of the transaction, updated as the indexer moves ahead
+	State TransactionState `json:"state"`
+
+	// Execution result from the transaction receipt.
+	// Status is 1 for success and 0 for failure, a failed transaction transfers no value
+	// but the sender still pays the fee.
+	Status            *big.Int `json:"status"`
+	GasUsed           *big.Int `json:"gasUsed"`
+	CumulativeGasUsed *big.Int `json:"cumulativeGasUsed"`
+	EffectiveGasPrice *big.Int `json:"effectiveGasPrice"`
+	Fee               *big.Int `json:"fee"`
+	ContractAddress   string   `json:"contractAddress,omitempty"`
+	Logs              []*Log   `json:"logs"`
+
 	// Batch transfers of coins in single transaction
 	// Any values transferred recorded here
 	// In the case of contract call without value transfer, the value is 0
 	Transfers []*Transfer `json:"transfers"`
 }
+
+type AccessTuple struct {
+	Address     string   `json:"address"`
+	StorageKeys []string `json:"storageKeys"`
+}
+
+type Authorization struct {
+	ChainId *big.Int `json:"chainId"`
+	Address string   `json:"address"`
+	Nonce   *big.Int `json:"nonce"`
+}
+
+// Failed reports whether the transaction has been reverted.
+func (t *Transaction) Failed() bool {
+	return t.Status != nil && t.Status.Sign() == 0
+}
+
+// Log is an event emitted by a contract during the transaction execution.
+type Log struct {
+	Address  string   `json:"address"`
+	Topics   []string `json:"topics"`
+	Data     string   `json:"data"`
+	LogIndex *big.Int `json:"logIndex"`
+}
+
+// BalanceChanges returns the changes of the balances of the address by coin made by the transaction:
+// the fee paid by the sender in the native coin, and the values transferred from and to the address.
+// A failed transaction transfers nothing, only the fee is paid. The transfers of unknown tokens are left out.
+func (t *Transaction) BalanceChanges(address string) map[int64]*big.Int {
+	changes := make(map[int64]*big.Int)
+	add := func(coinID int64, value *big.Int) {
+		if changes[coinID] == nil {
+			changes[coinID] = new(big.Int)
+		}
+		changes[coinID].Add(changes[coinID], value)
+	}
+
+	if t.From == address && t.Fee != nil {
+		add(NativeCoinID, new(big.Int).Neg(t.Fee))
+	}
+
+	if !t.Failed() {
+		for _, transfer := range t.Transfers {
+			if transfer.CoinID == UnknownCoinID || transfer.Value == nil {
+				continue
+			}
+			if transfer.From == address {
+				add(transfer.CoinID, new(big.Int).Neg(transfer.Value))
+			}
+			if transfer.To == address {
+				add(transfer.CoinID, transfer.Value)
+			}
+		}
+	}
+
+	for coinID, change := range changes {
+		if change.Sign() == 0 {
+			delete(changes, coinID)
+		}
+	}
+
+	return changes
+}
+
+// AddressTransaction links a transaction to the subscribed address it was saved for.
+type AddressTransaction struct {
+	Address     string       `json:"address"`
+	Transaction *Transaction `json:"transaction"`
+
+	// BalanceChanges are the changes of the balances of the address by coin made by the transaction,
+	// applied when the transaction is saved and reverted when it is removed.
+	BalanceChanges map[int64]*big.Int `json:"balanceChanges,omitempty"`
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrPrivateWebhook is returned for a webhook pointing at a loopback, private or link-local host,
+// which would have the indexer call the internal services on behalf of the callers of the API.
+var ErrPrivateWebhook = errors.New("webhook host is not public")
+
+// carrier-grade NAT, shared by the hosts of a provider and not routable on the internet
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Webhook is the endpoint the events of a subscribed address are delivered to.
+type Webhook struct {
+	URL string `json:"url"`
+
+	// Secret is the key of the HMAC-SHA256 signature of the payloads, shared with the receiver.
+	Secret string `json:"secret,omitempty"`
+
+	// Events are the types of the events delivered, all of them when empty.
+	Events []EventType `json:"events,omitempty"`
+}
+
+// Matches reports whether the events of the type are delivered to the webhook.
+func (w *Webhook) Matches(eventType EventType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, event := range w.Events {
+		if event == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks the URL is an absolute http or https URL and the event types are known.
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url %q, expected an http or https url", w.URL)
+	}
+
+	for _, event := range w.Events {
+		if !event.IsValid() {
+			return fmt.Errorf("invalid event type %s", event)
+		}
+	}
+
+	return nil
+}
+
+// CheckPublicHost checks the host of the URL is neither localhost nor an IP address which is not public.
+// A host name may still resolve to a private address, the addresses are checked again when delivering.
+func (w *Webhook) CheckPublicHost() error {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url %q, expected an http or https url", w.URL)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrPrivateWebhook, host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !IsPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateWebhook, host)
+	}
+
+	return nil
+}
+
+// IsPublicIP reports whether the IP address is routable on the internet: not a loopback, private, link-local,
+// shared, unspecified or multicast address.
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil && (ip4[0] == 0 || sharedAddressSpace.Contains(ip4)) {
+		return false
+	}
+
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// DeliveryState is the state of the delivery of an event to a webhook.
+type DeliveryState string
+
+const (
+	// DeliveryStatePending is a delivery waiting for its first attempt, or for its retry after a failed attempt.
+	DeliveryStatePending DeliveryState = "pending"
+
+	// DeliveryStateDelivered is a delivery acknowledged by the receiver with a 2xx response.
+	DeliveryStateDelivered DeliveryState = "delivered"
+
+	// DeliveryStateDead is a delivery given up after too many failed attempts, kept in the dead letters
+	// until delivered again.
+	DeliveryStateDead DeliveryState = "dead"
+)
+
+// Delivery is the delivery of an event to the webhook of the subscribed address, along with its attempts.
+// The ID is kept by the retries, for the receiver to drop the events delivered more than once.
+type Delivery struct {
+	ID int64 `json:"id"`
+
+	// URL is the url of the webhook when the delivery was created, then of its last attempt.
+	URL       string        `json:"url"`
+	Event     *Event        `json:"event"`
+	State     DeliveryState `json:"state"`
+	CreatedAt time.Time     `json:"createdAt"`
+
+	// Failures is the number of attempts failed in a row since the delivery was created or redelivered.
+	Failures int `json:"failures"`
+
+	// NextAttemptAt is the time of the next attempt of a pending delivery.
+	NextAttemptAt time.Time          `json:"nextAttemptAt,omitempty"`
+	Attempts      []*DeliveryAttempt `json:"attempts"`
+}
+
+// DeliveryAttempt is an attempt to deliver an event, failed with an error or a non 2xx status code.
+type DeliveryAttempt struct {
+	Time       time.Time     `json:"time"`
+	StatusCode int           `json:"statusCode,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+}
@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+
+	m "github.com/hoangan/superwallet/internal/models"
+)
+
+// Notifier is the interface that wraps the method to notify subscribers
+// about the activity of their subscribed addresses.
+type Notifier interface {
+	Notify(event *m.Event) error
+}
+
+// Multi notifies the events to every notifier, e.g: printed to the console and delivered to the webhooks.
+func Multi(notifiers ...Notifier) Notifier {
+	return multiNotifier(notifiers)
+}
+
+type multiNotifier []Notifier
+
+func (n multiNotifier) Notify(event *m.Event) error {
+	var errs []error
+	for _, notifier := range n {
+		if err := notifier.Notify(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ConsoleNotifier prints the events to the standard output.
+// Useful for the command line usage and debugging.
+type ConsoleNotifier struct{}
+
+func NewConsoleNotifier() *ConsoleNotifier {
+	return &ConsoleNotifier{}
+}
+
+func (n *ConsoleNotifier) Notify(event *m.Event) error {
+	switch event.Type {
+	case m.EventTransactionIndexed:
+		fmt.Printf("saved transaction for subscribed address: %s hash: %s\n", event.Address, event.Transaction.Hash)
+	case m.EventTransactionReorged:
+		fmt.Printf("transaction reorged out for subscribed address: %s hash: %s block: %s\n", event.Address, event.Transaction.Hash, event.Transaction.BlockNumber)
+	default:
+		fmt.Printf("%s for subscribed address: %s hash: %s\n", event.Type, event.Address, event.Transaction.Hash)
+	}
+
+	return nil
+}
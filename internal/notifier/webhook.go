@@ -0,0 +1,516 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/storage"
+	"github.com/hoangan/superwallet/pkg/httpclient"
+)
+
+// Headers of the webhook requests. The signature is the HMAC-SHA256 of the timestamp, a dot and the body,
+// keyed by the secret of the webhook, see Sign.
+const (
+	SignatureHeader = "X-Superwallet-Signature"
+	TimestampHeader = "X-Superwallet-Timestamp"
+	EventHeader     = "X-Superwallet-Event"
+	DeliveryHeader  = "X-Superwallet-Delivery"
+)
+
+// DefaultDeliveryHistory is the number of delivered deliveries kept for debugging,
+// the pending and dead deliveries are always kept.
+const DefaultDeliveryHistory = 1000
+
+// DefaultWebhookPollInterval is how often the due deliveries are looked for, on top of the notified events.
+const DefaultWebhookPollInterval = time.Second
+
+// deliveryPruneInterval is how often the delivered deliveries beyond the history are deleted.
+const deliveryPruneInterval = time.Minute
+
+var (
+	// ErrDeliveryNotFound is returned for a delivery not in the history.
+	ErrDeliveryNotFound = storage.ErrDeliveryNotFound
+
+	// ErrNoWebhook is returned when redelivering to an address without webhook anymore.
+	ErrNoWebhook = errors.New("address has no webhook")
+
+	// ErrDeliveryNotDead is returned when redelivering a delivery pending or delivered.
+	ErrDeliveryNotDead = errors.New("only the dead deliveries are redelivered")
+)
+
+// WebhookResolver returns the webhook of the subscribed address, nil if none.
+type WebhookResolver func(address string) (*m.Webhook, error)
+
+// SubscriptionWebhooks resolves the webhooks from the subscriptions saved to the storage.
+func SubscriptionWebhooks(store storage.Storage) WebhookResolver {
+	return func(address string) (*m.Webhook, error) {
+		subscription, err := store.GetSubscription(address)
+		if errors.Is(err, storage.ErrSubscriptionNotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return subscription.Webhook, nil
+	}
+}
+
+// WebhookPolicy tells how the deliveries are retried. The delay between the attempts grows exponentially
+// from BaseDelay up to MaxDelay, with a random jitter. A delivery failing MaxAttempts times in a row is dead.
+type WebhookPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	// Timeout is the time the receiver has to answer an attempt.
+	Timeout time.Duration
+}
+
+// DefaultWebhookPolicy attempts a delivery 12 times over up to about 45 minutes before giving up on it.
+var DefaultWebhookPolicy = WebhookPolicy{
+	MaxAttempts: 12,
+	BaseDelay:   time.Second,
+	MaxDelay:    15 * time.Minute,
+	Timeout:     10 * time.Second,
+}
+
+// WebhookPayload is the body of the webhook requests.
+// The delivery id is kept by the retries, for the receiver to drop the events delivered more than once.
+type WebhookPayload struct {
+	DeliveryID int64     `json:"deliveryId"`
+	CreatedAt  time.Time `json:"createdAt"`
+	*m.Event
+}
+
+// WebhookNotifier delivers the events of the subscribed addresses to their webhook, signed with its secret.
+// The deliveries are the outbox of the storage: they are created along with the changes of the transactions,
+// see storage.Storage.GetDueDeliveries, and the notifier attempts the due ones, so every event is delivered
+// at least once, after a restart as well. The failed attempts are retried with an exponential backoff,
+// and the deliveries failing too many times are moved to the dead letters, to be redelivered by hand.
+// The webhook is resolved at every attempt, a failure to resolve it is a failed attempt retried as well.
+type WebhookNotifier struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	store        storage.Storage
+	resolve      WebhookResolver
+	client       *http.Client
+	private      bool
+	policy       WebhookPolicy
+	history      int
+	pollInterval time.Duration
+
+	// one slot per attempt in flight
+	slots chan struct{}
+
+	// wakes the dispatcher up when an event is notified, or an attempt is done
+	wake chan struct{}
+
+	lock     sync.Mutex
+	inFlight map[int64]bool
+
+	// the dispatcher and the attempts in flight, waited for by Stop
+	wg sync.WaitGroup
+}
+
+type WebhookOption func(*WebhookNotifier)
+
+// WithWebhookPolicy sets how the deliveries are retried, DefaultWebhookPolicy by default.
+func WithWebhookPolicy(policy WebhookPolicy) WebhookOption {
+	return func(n *WebhookNotifier) {
+		if policy.MaxAttempts > 0 {
+			n.policy = policy
+		}
+	}
+}
+
+// WithWebhookClient sets the http client sending the webhook requests,
+// used as is: the addresses it connects to are not checked, see WithPrivateWebhooks.
+func WithWebhookClient(client *http.Client) WebhookOption {
+	return func(n *WebhookNotifier) {
+		n.client = client
+	}
+}
+
+// WithPrivateWebhooks allows the deliveries to localhost, loopback, private or link-local addresses.
+// By default the addresses the webhook hosts resolve to are checked when connecting, the redirects included,
+// and the attempts to non-public ones fail with m.ErrPrivateWebhook.
+func WithPrivateWebhooks(allowed bool) WebhookOption {
+	return func(n *WebhookNotifier) {
+		n.private = allowed
+	}
+}
+
+// WithWebhookWorkers sets the number of attempts in flight, 8 by default.
+func WithWebhookWorkers(workers int) WebhookOption {
+	return func(n *WebhookNotifier) {
+		if workers > 0 {
+			n.slots = make(chan struct{}, workers)
+		}
+	}
+}
+
+// WithDeliveryHistory sets the number of delivered deliveries kept, DefaultDeliveryHistory by default.
+func WithDeliveryHistory(size int) WebhookOption {
+	return func(n *WebhookNotifier) {
+		if size >= 0 {
+			n.history = size
+		}
+	}
+}
+
+// WithWebhookResolver sets how the webhooks of the addresses are resolved, SubscriptionWebhooks of the storage by default.
+func WithWebhookResolver(resolve WebhookResolver) WebhookOption {
+	return func(n *WebhookNotifier) {
+		n.resolve = resolve
+	}
+}
+
+// WithWebhookPollInterval sets how often the due deliveries are looked for, DefaultWebhookPollInterval by default.
+// The retries are attempted once due and polled, a retry delay shorter than the interval is rounded up to it.
+func WithWebhookPollInterval(interval time.Duration) WebhookOption {
+	return func(n *WebhookNotifier) {
+		if interval > 0 {
+			n.pollInterval = interval
+		}
+	}
+}
+
+// NewWebhookNotifier creates the notifier delivering the events saved to the outbox of the storage,
+// once started and until the context is done or it is stopped.
+func NewWebhookNotifier(ctx context.Context, store storage.Storage, opts ...WebhookOption) *WebhookNotifier {
+	ctx, cancel := context.WithCancel(ctx)
+	n := &WebhookNotifier{
+		ctx:          ctx,
+		cancel:       cancel,
+		store:        store,
+		resolve:      SubscriptionWebhooks(store),
+		policy:       DefaultWebhookPolicy,
+		history:      DefaultDeliveryHistory,
+		pollInterval: DefaultWebhookPollInterval,
+		slots:        make(chan struct{}, 8),
+		wake:         make(chan struct{}, 1),
+		inFlight:     make(map[int64]bool),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	if n.client == nil {
+		n.client = &http.Client{}
+		if !n.private {
+			n.client = newPublicClient()
+		}
+	}
+
+	return n
+}
+
+// Start delivers the due deliveries in the background until the context is done or it is stopped,
+// starting with the deliveries left pending by the previous run, at the time of their next attempt.
+func (n *WebhookNotifier) Start() {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.run()
+	}()
+}
+
+// Stop interrupts the attempts in flight and waits for the notifier to be done with the storage,
+// it must be stopped before the storage is closed. The interrupted attempts are not counted,
+// their deliveries are attempted again by the next run.
+func (n *WebhookNotifier) Stop() {
+	n.cancel()
+	n.wg.Wait()
+}
+
+// Notify wakes the dispatcher up to deliver the event. The delivery of the event is already saved,
+// created by the storage within the change of its transaction.
+func (n *WebhookNotifier) Notify(*m.Event) error {
+	n.wakeUp()
+	return nil
+}
+
+func (n *WebhookNotifier) wakeUp() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run dispatches the due deliveries when woken up and at every poll interval,
+// and prunes the history of the deliveries every deliveryPruneInterval.
+func (n *WebhookNotifier) run() {
+	ticker := time.NewTicker(n.pollInterval)
+	defer ticker.Stop()
+
+	pruneTicker := time.NewTicker(deliveryPruneInterval)
+	defer pruneTicker.Stop()
+
+	for {
+		n.dispatch()
+
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.wake:
+		case <-ticker.C:
+		case <-pruneTicker.C:
+			if err := n.store.PruneDeliveries(n.history); err != nil {
+				fmt.Printf("failed to prune webhook deliveries: %v\n", err)
+			}
+		}
+	}
+}
+
+// dispatch attempts the due deliveries not in flight, as many as there are free slots.
+func (n *WebhookNotifier) dispatch() {
+	n.lock.Lock()
+	limit := cap(n.slots) + len(n.inFlight)
+	n.lock.Unlock()
+
+	due, err := n.store.GetDueDeliveries(time.Now(), limit)
+	if err != nil {
+		fmt.Printf("failed to get due webhook deliveries: %v\n", err)
+		return
+	}
+
+	for _, d := range due {
+		if n.ctx.Err() != nil {
+			return
+		}
+
+		n.lock.Lock()
+		if n.inFlight[d.ID] {
+			n.lock.Unlock()
+			continue
+		}
+
+		select {
+		case n.slots <- struct{}{}:
+		default:
+			// every slot is taken, the others are dispatched once an attempt is done
+			n.lock.Unlock()
+			return
+		}
+		n.inFlight[d.ID] = true
+		n.lock.Unlock()
+
+		n.wg.Add(1)
+		go func(d *m.Delivery) {
+			defer n.wg.Done()
+			n.deliver(d)
+		}(d)
+	}
+}
+
+// deliver attempts the delivery once and saves the attempt, along with the next attempt, or the delivery done or dead.
+// A delivery failing to be saved is attempted again, the receiver drops the duplicates by the delivery id.
+func (n *WebhookNotifier) deliver(d *m.Delivery) {
+	defer func() {
+		n.lock.Lock()
+		delete(n.inFlight, d.ID)
+		n.lock.Unlock()
+		<-n.slots
+		n.wakeUp()
+	}()
+
+	attempt, dead := n.attempt(d)
+	if n.ctx.Err() != nil {
+		// stopped while attempting, the delivery stays pending as it was saved
+		return
+	}
+
+	switch {
+	case dead:
+		d.State = m.DeliveryStateDead
+		d.NextAttemptAt = time.Time{}
+	case attempt.Error == "":
+		d.State = m.DeliveryStateDelivered
+		d.Failures = 0
+		d.NextAttemptAt = time.Time{}
+	default:
+		d.Failures++
+		if d.Failures >= n.policy.MaxAttempts {
+			d.State = m.DeliveryStateDead
+			d.NextAttemptAt = time.Time{}
+		} else {
+			d.NextAttemptAt = time.Now().Add(httpclient.Backoff(d.Failures, n.policy.BaseDelay, n.policy.MaxDelay))
+		}
+	}
+
+	if d.State == m.DeliveryStateDead {
+		fmt.Printf("webhook delivery %d of %s to %s dead after %d attempts: %s\n",
+			d.ID, d.Event.Type, d.URL, d.Failures, attempt.Error)
+	}
+
+	if err := n.store.UpdateDelivery(d, attempt); err != nil {
+		fmt.Printf("failed to save webhook delivery %d attempt: %v\n", d.ID, err)
+	}
+}
+
+// attempt resolves the webhook of the address and sends the signed payload, failed with an error
+// unless answered with a 2xx status code. The delivery is dead when the address has no matching webhook anymore.
+func (n *WebhookNotifier) attempt(d *m.Delivery) (*m.DeliveryAttempt, bool) {
+	start := time.Now()
+	attempt := &m.DeliveryAttempt{Time: start}
+
+	webhook, err := n.resolve(d.Event.Address)
+	if err != nil {
+		attempt.Error = fmt.Sprintf("failed to get webhook of %s: %v", d.Event.Address, err)
+		return attempt, false
+	}
+	if webhook == nil || !webhook.Matches(d.Event.Type) {
+		attempt.Error = ErrNoWebhook.Error()
+		return attempt, true
+	}
+	d.URL = webhook.URL
+
+	// the same body for every attempt, built from the saved delivery
+	body, err := json.Marshal(&WebhookPayload{DeliveryID: d.ID, CreatedAt: d.CreatedAt, Event: d.Event})
+	if err != nil {
+		attempt.Error = fmt.Sprintf("failed to marshal webhook payload: %v", err)
+		return attempt, true
+	}
+
+	ctx, cancel := context.WithTimeout(n.ctx, n.policy.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+
+	timestamp := start.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(d.Event.Type))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := n.client.Do(req)
+	attempt.Duration = time.Since(start)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, false
+	}
+	defer resp.Body.Close()
+	// drained for the connection to be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = "unexpected status code " + strconv.Itoa(resp.StatusCode)
+	}
+
+	return attempt, false
+}
+
+// Redeliver delivers the dead delivery again, to the current webhook of its address,
+// with as many attempts as a new delivery.
+func (n *WebhookNotifier) Redeliver(id int64) (*m.Delivery, error) {
+	d, err := n.store.GetDelivery(id)
+	if err != nil {
+		return nil, err
+	}
+	if d.State != m.DeliveryStateDead {
+		return nil, fmt.Errorf("delivery %d is %s: %w", id, d.State, ErrDeliveryNotDead)
+	}
+
+	webhook, err := n.resolve(d.Event.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook of %s: %w", d.Event.Address, err)
+	}
+	if webhook == nil {
+		return nil, ErrNoWebhook
+	}
+
+	d.State = m.DeliveryStatePending
+	d.URL = webhook.URL
+	d.Failures = 0
+	d.NextAttemptAt = time.Now()
+	if err := n.store.UpdateDelivery(d, nil); err != nil {
+		return nil, err
+	}
+	n.wakeUp()
+
+	return d, nil
+}
+
+// GetDeliveries returns the deliveries of the address, of all the addresses when empty,
+// in the state when given, in the order they were created, along with their attempts.
+func (n *WebhookNotifier) GetDeliveries(address string, state m.DeliveryState) ([]*m.Delivery, error) {
+	return n.store.GetDeliveries(address, state)
+}
+
+// DeadLetters returns the deliveries given up after too many failed attempts.
+func (n *WebhookNotifier) DeadLetters() ([]*m.Delivery, error) {
+	return n.store.GetDeliveries("", m.DeliveryStateDead)
+}
+
+// Sign returns the signature of the body sent at the timestamp in unix seconds, sha256=<hex of the HMAC-SHA256>.
+// The receiver signs the timestamp and body it received the same way and compares the signatures,
+// and drops the old timestamps to prevent the requests from being replayed.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether the signature is the signature of the body sent at the timestamp,
+// in constant time.
+func VerifySignature(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// NewWebhookSecret returns a random secret for a webhook, 32 bytes hex encoded.
+func NewWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+// newPublicClient returns the http client connecting to the public addresses only. The addresses are checked
+// once the host is resolved, so a host name resolving to a private address is rejected along with the redirects
+// to them. The proxy of the environment is not used, the addresses would be the ones of the proxy.
+func newPublicClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !m.IsPublicIP(ip) {
+				return fmt.Errorf("%w: %s", m.ErrPrivateWebhook, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Transport: transport}
+}
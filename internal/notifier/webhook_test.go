@@ -0,0 +1,357 @@
+package notifier_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/notifier"
+	"github.com/hoangan/superwallet/internal/storage"
+	"github.com/hoangan/superwallet/internal/storage/inmemorystorage"
+)
+
+const address = "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+
+// receiver is a webhook endpoint answering the status codes in order, the last one once exhausted.
+type receiver struct {
+	lock     sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := r.statuses[0]
+	if len(r.statuses) > 1 {
+		r.statuses = r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return len(r.requests)
+}
+
+func (r *receiver) setStatuses(statuses ...int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.statuses = statuses
+}
+
+// newTransaction returns a pending transaction of the address in the block.
+func newTransaction(hash string, blockNumber int64) *m.Transaction {
+	return &m.Transaction{Hash: hash, BlockHash: "0xb" + strconv.FormatInt(blockNumber, 10), BlockNumber: big.NewInt(blockNumber),
+		From: address, To: address, Value: big.NewInt(0), State: m.TransactionStatePending}
+}
+
+// index commits the block of the transaction of the address, the transaction.indexed delivery is created along.
+func index(t *testing.T, store storage.Storage, txn *m.Transaction) {
+	t.Helper()
+
+	checkpoint := &m.Checkpoint{BlockNumber: txn.BlockNumber, BlockHash: txn.BlockHash}
+	if err := store.CommitBlock(checkpoint, []*m.AddressTransaction{{Address: address, Transaction: txn}}); err != nil {
+		t.Fatalf("failed to commit block: %v", err)
+	}
+}
+
+func waitForState(t *testing.T, webhooks *notifier.WebhookNotifier, state m.DeliveryState) *m.Delivery {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := webhooks.GetDeliveries(address, state)
+		if err != nil {
+			t.Fatalf("failed to get deliveries: %v", err)
+		}
+		if len(deliveries) > 0 {
+			return deliveries[0]
+		}
+		if time.Now().After(deadline) {
+			all, _ := webhooks.GetDeliveries("", "")
+			t.Fatalf("no delivery %s, got %+v", state, all)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	policy := notifier.WebhookPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Timeout: time.Second}
+
+	// newStore returns a storage with the address subscribed, along with the webhook when given,
+	// and the receiver of the webhook answering the status codes.
+	newStore := func(t *testing.T, webhook *m.Webhook, statuses ...int) (storage.Storage, *receiver) {
+		r := &receiver{statuses: statuses}
+		server := httptest.NewServer(r)
+		t.Cleanup(server.Close)
+
+		store, err := inmemorystorage.New()
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		if err := store.SubscribeAddress(address); err != nil {
+			t.Fatalf("failed to subscribe address: %v", err)
+		}
+		if webhook != nil {
+			webhook.URL = server.URL
+			if err := store.SetWebhook(address, webhook); err != nil {
+				t.Fatalf("failed to set webhook: %v", err)
+			}
+		}
+
+		return store, r
+	}
+
+	start := func(t *testing.T, store storage.Storage, opts ...notifier.WebhookOption) *notifier.WebhookNotifier {
+		// the receivers are served on the loopback
+		opts = append([]notifier.WebhookOption{notifier.WithWebhookPolicy(policy), notifier.WithWebhookPollInterval(5 * time.Millisecond),
+			notifier.WithPrivateWebhooks(true)}, opts...)
+		webhooks := notifier.NewWebhookNotifier(context.Background(), store, opts...)
+		webhooks.Start()
+		t.Cleanup(webhooks.Stop)
+		return webhooks
+	}
+
+	t.Run("Signed Payload", func(t *testing.T) {
+		store, r := newStore(t, &m.Webhook{Secret: "secret"}, http.StatusOK)
+		webhooks := start(t, store)
+
+		index(t, store, newTransaction("0xt1", 1))
+		if err := webhooks.Notify(&m.Event{Type: m.EventTransactionIndexed, Address: address}); err != nil {
+			t.Fatalf("failed to notify event: %v", err)
+		}
+		delivery := waitForState(t, webhooks, m.DeliveryStateDelivered)
+		if len(delivery.Attempts) != 1 || delivery.Attempts[0].StatusCode != http.StatusOK {
+			t.Errorf("failed to record the attempt: %+v", delivery.Attempts)
+		}
+
+		req, body := r.requests[0], r.bodies[0]
+		timestamp, _ := strconv.ParseInt(req.Header.Get(notifier.TimestampHeader), 10, 64)
+		if !notifier.VerifySignature("secret", timestamp, body, req.Header.Get(notifier.SignatureHeader)) {
+			t.Errorf("failed to sign the payload: %s", req.Header.Get(notifier.SignatureHeader))
+		}
+		if notifier.VerifySignature("other", timestamp, body, req.Header.Get(notifier.SignatureHeader)) {
+			t.Errorf("failed to reject the signature of another secret")
+		}
+
+		var payload notifier.WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("failed to decode payload: %v", err)
+		}
+		if payload.DeliveryID != delivery.ID || payload.Type != m.EventTransactionIndexed || payload.Address != address ||
+			payload.Transaction.Hash != "0xt1" || req.Header.Get(notifier.EventHeader) != string(m.EventTransactionIndexed) {
+			t.Errorf("failed to deliver the event: %s", body)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		store, r := newStore(t, &m.Webhook{Secret: "secret"}, http.StatusInternalServerError, http.StatusOK)
+		webhooks := start(t, store)
+
+		index(t, store, newTransaction("0xt1", 1))
+		delivery := waitForState(t, webhooks, m.DeliveryStateDelivered)
+		if len(delivery.Attempts) != 2 || delivery.Attempts[0].StatusCode != http.StatusInternalServerError || delivery.Attempts[0].Error == "" {
+			t.Errorf("failed to retry the failed attempt: %+v", delivery.Attempts)
+		}
+
+		// the same body for the receiver to drop the duplicates by the delivery id
+		if string(r.bodies[0]) != string(r.bodies[1]) {
+			t.Errorf("failed to keep the payload of the retries: %s, %s", r.bodies[0], r.bodies[1])
+		}
+	})
+
+	t.Run("Resolver Failure", func(t *testing.T) {
+		store, r := newStore(t, &m.Webhook{Secret: "secret"}, http.StatusOK)
+
+		// the lookup of the webhook fails once, the event is delivered by the retry
+		var failed atomic.Bool
+		resolve := notifier.SubscriptionWebhooks(store)
+		webhooks := start(t, store, notifier.WithWebhookResolver(func(address string) (*m.Webhook, error) {
+			if failed.CompareAndSwap(false, true) {
+				return nil, errors.New("database unavailable")
+			}
+			return resolve(address)
+		}))
+
+		index(t, store, newTransaction("0xt1", 1))
+		delivery := waitForState(t, webhooks, m.DeliveryStateDelivered)
+		if len(delivery.Attempts) != 2 || delivery.Attempts[0].Error == "" || delivery.Attempts[1].StatusCode != http.StatusOK || r.count() != 1 {
+			t.Errorf("failed to retry the failed lookup of the webhook: %+v", delivery.Attempts)
+		}
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		store, r := newStore(t, &m.Webhook{Secret: "secret"}, http.StatusOK)
+
+		// committed before the notifier stopped, the first attempt failed and the retry was due later
+		index(t, store, newTransaction("0xt1", 1))
+		pending, err := store.GetDueDeliveries(time.Now(), 10)
+		if err != nil || len(pending) != 1 {
+			t.Fatalf("failed to save the delivery along with the block: %+v, %v", pending, err)
+		}
+		retry := time.Now().Add(100 * time.Millisecond)
+		pending[0].Failures = 1
+		pending[0].NextAttemptAt = retry
+		if err := store.UpdateDelivery(pending[0], &m.DeliveryAttempt{Time: time.Now(), Error: "connection refused"}); err != nil {
+			t.Fatalf("failed to update delivery: %v", err)
+		}
+
+		// the pending delivery is resumed by the next notifier, at the time of its retry
+		webhooks := start(t, store)
+		delivery := waitForState(t, webhooks, m.DeliveryStateDelivered)
+		if len(delivery.Attempts) != 2 || r.count() != 1 || delivery.Attempts[1].Time.Before(retry) {
+			t.Errorf("failed to resume the pending delivery: %+v", delivery.Attempts)
+		}
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		store, _ := newStore(t, &m.Webhook{Secret: "secret"}, http.StatusOK)
+
+		// the receiver hangs until the attempt is interrupted
+		attempting := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the disconnection is only noticed once the body is read
+			_, _ = io.Copy(io.Discard, r.Body)
+			close(attempting)
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+		if err := store.SetWebhook(address, &m.Webhook{URL: server.URL, Secret: "secret"}); err != nil {
+			t.Fatalf("failed to set webhook: %v", err)
+		}
+
+		webhooks := notifier.NewWebhookNotifier(context.Background(), store, notifier.WithWebhookPolicy(policy),
+			notifier.WithWebhookPollInterval(5*time.Millisecond), notifier.WithPrivateWebhooks(true))
+		webhooks.Start()
+
+		index(t, store, newTransaction("0xt1", 1))
+		select {
+		case <-attempting:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("failed to attempt the delivery")
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			webhooks.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("failed to stop the notifier")
+		}
+
+		// the interrupted attempt is not counted, the delivery is attempted again by the next run
+		deliveries, err := store.GetDeliveries(address, m.DeliveryStatePending)
+		if err != nil || len(deliveries) != 1 || len(deliveries[0].Attempts) != 0 || deliveries[0].Failures != 0 {
+			t.Errorf("failed to keep the interrupted delivery pending: %+v, %v", deliveries, err)
+		}
+	})
+
+	t.Run("Private Host", func(t *testing.T) {
+		store, r := newStore(t, &m.Webhook{Secret: "secret"}, http.StatusOK)
+		webhooks := notifier.NewWebhookNotifier(context.Background(), store,
+			notifier.WithWebhookPolicy(policy), notifier.WithWebhookPollInterval(5*time.Millisecond))
+		webhooks.Start()
+		t.Cleanup(webhooks.Stop)
+
+		// the receiver on the loopback is never called
+		index(t, store, newTransaction("0xt1", 1))
+		delivery := waitForState(t, webhooks, m.DeliveryStateDead)
+		if r.count() != 0 || !strings.Contains(delivery.Attempts[0].Error, m.ErrPrivateWebhook.Error()) {
+			t.Errorf("failed to reject the private host: %d requests, %+v", r.count(), delivery.Attempts[0])
+		}
+	})
+
+	t.Run("Dead Letters", func(t *testing.T) {
+		store, r := newStore(t, &m.Webhook{Secret: "secret"}, http.StatusBadGateway)
+		webhooks := start(t, store)
+
+		index(t, store, newTransaction("0xt1", 1))
+		delivery := waitForState(t, webhooks, m.DeliveryStateDead)
+		if len(delivery.Attempts) != policy.MaxAttempts || r.count() != policy.MaxAttempts {
+			t.Errorf("failed to give up after %d attempts: %+v", policy.MaxAttempts, delivery.Attempts)
+		}
+		if dead, err := webhooks.DeadLetters(); err != nil || len(dead) != 1 || dead[0].ID != delivery.ID {
+			t.Errorf("failed to list the dead letters: %+v, %v", dead, err)
+		}
+
+		// redelivered once the receiver is fixed
+		r.setStatuses(http.StatusNoContent)
+		if _, err := webhooks.Redeliver(delivery.ID); err != nil {
+			t.Fatalf("failed to redeliver: %v", err)
+		}
+		delivery = waitForState(t, webhooks, m.DeliveryStateDelivered)
+		if dead, _ := webhooks.DeadLetters(); len(delivery.Attempts) != policy.MaxAttempts+1 || len(dead) != 0 {
+			t.Errorf("failed to redeliver the dead letter: %+v", delivery)
+		}
+
+		if _, err := webhooks.Redeliver(delivery.ID); !errors.Is(err, notifier.ErrDeliveryNotDead) {
+			t.Errorf("failed to reject redelivering a delivered delivery")
+		}
+		if _, err := webhooks.Redeliver(1000); !errors.Is(err, notifier.ErrDeliveryNotFound) {
+			t.Errorf("failed to report the delivery is not found: %v", err)
+		}
+	})
+
+	t.Run("Event Types", func(t *testing.T) {
+		store, r := newStore(t, &m.Webhook{Secret: "secret", Events: []m.EventType{m.EventTransactionConfirmed}}, http.StatusOK)
+		webhooks := start(t, store)
+
+		txn := newTransaction("0xt1", 1)
+		index(t, store, txn)
+		if _, err := store.UpdateBlockTransactionsState(txn.BlockNumber, m.TransactionStateConfirmed); err != nil {
+			t.Fatalf("failed to update block transactions state: %v", err)
+		}
+
+		delivery := waitForState(t, webhooks, m.DeliveryStateDelivered)
+		if deliveries, _ := webhooks.GetDeliveries(address, ""); len(deliveries) != 1 || r.count() != 1 ||
+			delivery.Event.Type != m.EventTransactionConfirmed || delivery.Event.Transaction.State != m.TransactionStateConfirmed {
+			t.Errorf("failed to deliver the matching events only: %+v", deliveries)
+		}
+	})
+
+	t.Run("No Webhook", func(t *testing.T) {
+		store, r := newStore(t, nil, http.StatusOK)
+		webhooks := start(t, store)
+
+		index(t, store, newTransaction("0xt1", 1))
+		if deliveries, err := webhooks.GetDeliveries("", ""); err != nil || len(deliveries) != 0 {
+			t.Errorf("failed to skip the address without webhook: %+v, %v", deliveries, err)
+		}
+
+		// removed once the delivery is created
+		_ = store.SetWebhook(address, &m.Webhook{URL: "http://localhost/hook"})
+		index(t, store, newTransaction("0xt2", 2))
+		_ = store.SetWebhook(address, nil)
+		delivery := waitForState(t, webhooks, m.DeliveryStateDead)
+		if len(delivery.Attempts) != 1 || delivery.Attempts[0].Error != notifier.ErrNoWebhook.Error() || r.count() != 0 {
+			t.Errorf("failed to give up the delivery of the address without webhook: %+v", delivery.Attempts)
+		}
+		if _, err := webhooks.Redeliver(delivery.ID); !errors.Is(err, notifier.ErrNoWebhook) {
+			t.Errorf("failed to reject redelivering to the address without webhook: %v", err)
+		}
+	})
+}
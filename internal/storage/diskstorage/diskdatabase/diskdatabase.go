@@ -0,0 +1,552 @@
+package diskdatabase
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/hoangan/superwallet/internal/storage/kvstorage"
+)
+
+const (
+	// headerSize is the size of the record header: the payload length and its CRC-32C checksum.
+	headerSize = 8
+
+	// maxRecordSize bounds the payload of a record, a larger length read back is a corrupted header.
+	maxRecordSize = 1 << 30
+
+	// compactMinGarbage is the size of the overwritten and deleted values from which the log is compacted,
+	// once they also outweigh the live values.
+	compactMinGarbage = 16 << 20
+
+	opSet    byte = 1
+	opDelete byte = 2
+)
+
+var (
+	// ErrNotFound is returned when the key is not found in the database.
+	ErrNotFound = kvstorage.ErrNotFound
+
+	// ErrDBClosed is returned when the database is closed.
+	ErrDBClosed = kvstorage.ErrDBClosed
+
+	// ErrTxDone is returned when the transaction is used after being committed or rolled back.
+	ErrTxDone = kvstorage.ErrTxDone
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// valueLocation is where the value of a key is in the log file.
+type valueLocation struct {
+	offset int64
+	size   int
+}
+
+// op is a change of a key, a set or a delete.
+type op struct {
+	kind  byte
+	key   string
+	value []byte
+}
+
+// DiskDatabase is a key-value database saved to an append-only log file, along with an in-memory index
+// of the location of the values in the file.
+//
+// Every change is appended as a record with its length and checksum, and synced to the disk before returning.
+// A record partially written by a crash is detected by its checksum and truncated when the database is opened
+// again, the changes written before it are kept. The writes of a transaction are appended as a single record,
+// a crash keeps either all or none of them. The log is compacted, rewriting only the live values,
+// once the overwritten values outweigh them.
+type DiskDatabase struct {
+	path  string
+	file  *os.File
+	index map[string]valueLocation
+
+	// size of the log file, the next record is written at that offset
+	size int64
+
+	// bytes of the overwritten and deleted values
+	garbage int64
+
+	lock sync.RWMutex
+
+	// held by the transaction in progress, the transactions are serialized
+	writer sync.Mutex
+}
+
+// Open opens the database of the log file at the path, created when missing,
+// and rebuilds the index from the records of the log.
+func Open(path string) (*DiskDatabase, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	// a compaction interrupted by a crash, the log is still the previous one
+	if err := os.Remove(compactPath(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove interrupted compaction: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database file: %w", err)
+	}
+
+	d := &DiskDatabase{
+		path:  path,
+		file:  file,
+		index: make(map[string]valueLocation),
+	}
+
+	if err := d.recover(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func compactPath(path string) string {
+	return path + ".compact"
+}
+
+// recover replays the records of the log to rebuild the index, the log is truncated
+// after the last complete record.
+func (d *DiskDatabase) recover() error {
+	if _, err := d.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read database file: %w", err)
+	}
+
+	reader := bufio.NewReader(d.file)
+	var offset int64
+	for {
+		ops, size, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Printf("database %s: dropping the changes after offset %d, %v\n", d.path, offset, err)
+			if err := d.file.Truncate(offset); err != nil {
+				return fmt.Errorf("failed to truncate the database file: %w", err)
+			}
+			if err := d.file.Sync(); err != nil {
+				return fmt.Errorf("failed to truncate the database file: %w", err)
+			}
+			break
+		}
+
+		d.apply(offset, ops)
+		offset += size
+	}
+
+	d.size = offset
+
+	return nil
+}
+
+// readRecord reads the next record, io.EOF when there is none,
+// another error when the record is incomplete or corrupted.
+func readRecord(reader *bufio.Reader) ([]*op, int64, error) {
+	header := make([]byte, headerSize)
+	if n, err := io.ReadFull(reader, header); err == io.EOF {
+		return nil, 0, io.EOF
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("incomplete record header of %d bytes", n)
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return nil, 0, fmt.Errorf("invalid record length %d", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, fmt.Errorf("incomplete record of %d bytes", length)
+	}
+
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+
+	ops, err := decodeOps(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ops, headerSize + int64(length), nil
+}
+
+// encodeRecord encodes the ops as a record: the header then the ops one after the other,
+// the kind, the key length and key, then the value length and value of the sets.
+func encodeRecord(ops []*op) []byte {
+	record := make([]byte, headerSize)
+	for _, o := range ops {
+		record = append(record, o.kind)
+		record = binary.AppendUvarint(record, uint64(len(o.key)))
+		record = append(record, o.key...)
+		if o.kind == opSet {
+			record = binary.AppendUvarint(record, uint64(len(o.value)))
+			record = append(record, o.value...)
+		}
+	}
+
+	payload := record[headerSize:]
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+
+	return record
+}
+
+func decodeOps(payload []byte) ([]*op, error) {
+	var ops []*op
+	for pos := 0; pos < len(payload); {
+		o := &op{kind: payload[pos]}
+		pos++
+
+		key, n, err := decodeBytes(payload[pos:])
+		if err != nil {
+			return nil, err
+		}
+		o.key = string(key)
+		pos += n
+
+		switch o.kind {
+		case opSet:
+			if o.value, n, err = decodeBytes(payload[pos:]); err != nil {
+				return nil, err
+			}
+			pos += n
+		case opDelete:
+		default:
+			return nil, fmt.Errorf("invalid record op %d", o.kind)
+		}
+
+		ops = append(ops, o)
+	}
+
+	return ops, nil
+}
+
+// decodeBytes decodes a length prefixed byte slice, returns the number of bytes read.
+func decodeBytes(buf []byte) ([]byte, int, error) {
+	length, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < length {
+		return nil, 0, errors.New("invalid record encoding")
+	}
+
+	return buf[n : n+int(length)], n + int(length), nil
+}
+
+// apply updates the index with the ops of the record written at the offset.
+func (d *DiskDatabase) apply(offset int64, ops []*op) {
+	// the values are located by walking the record the same way it is encoded
+	pos := offset + headerSize
+	for _, o := range ops {
+		pos += 1 + int64(uvarintSize(uint64(len(o.key)))+len(o.key))
+
+		if previous, ok := d.index[o.key]; ok {
+			d.garbage += int64(previous.size)
+			delete(d.index, o.key)
+		}
+
+		if o.kind == opSet {
+			pos += int64(uvarintSize(uint64(len(o.value))))
+			d.index[o.key] = valueLocation{offset: pos, size: len(o.value)}
+			pos += int64(len(o.value))
+		}
+	}
+}
+
+func uvarintSize(x uint64) int {
+	return len(binary.AppendUvarint(nil, x))
+}
+
+// write appends the ops as a single record and syncs it to the disk,
+// a failed write is truncated so the log ends with a complete record.
+func (d *DiskDatabase) write(ops []*op) error {
+	record := encodeRecord(ops)
+	if _, err := d.file.WriteAt(record, d.size); err != nil {
+		_ = d.file.Truncate(d.size)
+		return fmt.Errorf("failed to write database record: %w", err)
+	}
+
+	if err := d.file.Sync(); err != nil {
+		_ = d.file.Truncate(d.size)
+		return fmt.Errorf("failed to sync database record: %w", err)
+	}
+
+	d.apply(d.size, ops)
+	d.size += int64(len(record))
+
+	if d.garbage > compactMinGarbage && d.garbage > d.size-d.garbage {
+		if err := d.compact(); err != nil {
+			// the log is still complete, compacted again on the next write
+			fmt.Printf("failed to compact database %s: %v\n", d.path, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *DiskDatabase) Get(key string) ([]byte, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	if d.file == nil {
+		return nil, ErrDBClosed
+	}
+
+	location, ok := d.index[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	value := make([]byte, location.size)
+	if _, err := d.file.ReadAt(value, location.offset); err != nil {
+		return nil, fmt.Errorf("failed to read value of %s: %w", key, err)
+	}
+
+	return value, nil
+}
+
+func (d *DiskDatabase) Set(key string, value []byte) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.file == nil {
+		return ErrDBClosed
+	}
+
+	return d.write([]*op{{kind: opSet, key: key, value: value}})
+}
+
+func (d *DiskDatabase) Delete(key string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.file == nil {
+		return ErrDBClosed
+	}
+
+	if _, ok := d.index[key]; !ok {
+		return nil
+	}
+
+	return d.write([]*op{{kind: opDelete, key: key}})
+}
+
+func (d *DiskDatabase) Keys() ([]string, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	if d.file == nil {
+		return nil, ErrDBClosed
+	}
+
+	keys := make([]string, 0, len(d.index))
+	for key := range d.index {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Compact rewrites the log with only the live values, reclaiming the space of the overwritten and deleted ones.
+func (d *DiskDatabase) Compact() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.file == nil {
+		return ErrDBClosed
+	}
+
+	return d.compact()
+}
+
+// compact writes the live values to a new log file, then replaces the log with it.
+// The rename is atomic, a crash leaves either the previous or the compacted log.
+func (d *DiskDatabase) compact() error {
+	path := compactPath(d.path)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted log: %w", err)
+	}
+
+	index := make(map[string]valueLocation, len(d.index))
+	writer := bufio.NewWriter(file)
+	var size int64
+	for key, location := range d.index {
+		value := make([]byte, location.size)
+		if _, err := d.file.ReadAt(value, location.offset); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("failed to read value of %s: %w", key, err)
+		}
+
+		record := encodeRecord([]*op{{kind: opSet, key: key, value: value}})
+		if _, err := writer.Write(record); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("failed to write compacted log: %w", err)
+		}
+
+		index[key] = valueLocation{offset: int64(len(record)-len(value)) + size, size: len(value)}
+		size += int64(len(record))
+	}
+
+	if err := writer.Flush(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write compacted log: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to sync compacted log: %w", err)
+	}
+
+	if err := os.Rename(path, d.path); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to replace the log: %w", err)
+	}
+	syncDir(filepath.Dir(d.path))
+
+	_ = d.file.Close()
+	d.file = file
+	d.index = index
+	d.size = size
+	d.garbage = 0
+
+	return nil
+}
+
+// syncDir makes the rename of a file in the directory durable, best effort as not every platform supports it.
+func syncDir(dir string) {
+	if f, err := os.Open(dir); err == nil {
+		_ = f.Sync()
+		_ = f.Close()
+	}
+}
+
+// Begin starts a transaction once the transaction in progress is done.
+// The transaction must be committed or rolled back for the next one to start.
+func (d *DiskDatabase) Begin() (kvstorage.Tx, error) {
+	d.writer.Lock()
+
+	d.lock.RLock()
+	closed := d.file == nil
+	d.lock.RUnlock()
+
+	if closed {
+		d.writer.Unlock()
+		return nil, ErrDBClosed
+	}
+
+	return &Tx{db: d, writes: make(map[string]*op)}, nil
+}
+
+// Tx buffers its writes until committed, serialized with the other transactions of the database.
+type Tx struct {
+	db     *DiskDatabase
+	writes map[string]*op
+	done   bool
+}
+
+func (t *Tx) Get(key string) ([]byte, error) {
+	if t.done {
+		return nil, ErrTxDone
+	}
+
+	if o, ok := t.writes[key]; ok {
+		if o.kind == opDelete {
+			return nil, ErrNotFound
+		}
+		return o.value, nil
+	}
+
+	return t.db.Get(key)
+}
+
+func (t *Tx) Set(key string, value []byte) error {
+	if t.done {
+		return ErrTxDone
+	}
+
+	t.writes[key] = &op{kind: opSet, key: key, value: value}
+
+	return nil
+}
+
+func (t *Tx) Delete(key string) error {
+	if t.done {
+		return ErrTxDone
+	}
+
+	t.writes[key] = &op{kind: opDelete, key: key}
+
+	return nil
+}
+
+// Commit appends the writes of the transaction as a single record.
+func (t *Tx) Commit() error {
+	if t.done {
+		return ErrTxDone
+	}
+	t.done = true
+	defer t.db.writer.Unlock()
+
+	t.db.lock.Lock()
+	defer t.db.lock.Unlock()
+
+	if t.db.file == nil {
+		return ErrDBClosed
+	}
+
+	ops := make([]*op, 0, len(t.writes))
+	for key, o := range t.writes {
+		if _, ok := t.db.index[key]; o.kind == opDelete && !ok {
+			continue
+		}
+		ops = append(ops, o)
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	// written in the same order every time
+	sort.Slice(ops, func(i, j int) bool { return ops[i].key < ops[j].key })
+
+	return t.db.write(ops)
+}
+
+// Rollback drops the writes of the transaction.
+func (t *Tx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	t.db.writer.Unlock()
+
+	return nil
+}
+
+// Close syncs and closes the log file.
+func (d *DiskDatabase) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.file == nil {
+		return nil
+	}
+
+	file := d.file
+	d.file = nil
+	d.index = nil
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to sync database file: %w", err)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,171 @@
+package diskdatabase_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hoangan/superwallet/internal/storage/diskstorage/diskdatabase"
+)
+
+func TestDiskDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db, err := diskdatabase.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	for _, value := range []string{"v1", "v2", "v3"} {
+		if err := db.Set("key", []byte(value)); err != nil {
+			t.Fatalf("failed to set value: %v", err)
+		}
+	}
+	if err := db.Set("deleted", []byte("value")); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	if err := db.Delete("deleted"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+
+	t.Run("Compact", func(t *testing.T) {
+		before, _ := os.Stat(path)
+		if err := db.Compact(); err != nil {
+			t.Fatalf("failed to compact database: %v", err)
+		}
+		after, _ := os.Stat(path)
+		if after.Size() >= before.Size() {
+			t.Errorf("failed to reclaim space, %d bytes before, %d after", before.Size(), after.Size())
+		}
+
+		if value, err := db.Get("key"); err != nil || string(value) != "v3" {
+			t.Errorf("failed to get value after compaction: %s, %v", value, err)
+		}
+		if err := db.Set("other", []byte("value")); err != nil {
+			t.Errorf("failed to set value after compaction: %v", err)
+		}
+	})
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+	if _, err := db.Get("key"); !errors.Is(err, diskdatabase.ErrDBClosed) {
+		t.Errorf("failed to report closed database: %v", err)
+	}
+
+	t.Run("Reopen", func(t *testing.T) {
+		db, err := diskdatabase.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		defer db.Close()
+
+		if value, err := db.Get("key"); err != nil || string(value) != "v3" {
+			t.Errorf("failed to get the last value: %s, %v", value, err)
+		}
+		if value, err := db.Get("other"); err != nil || string(value) != "value" {
+			t.Errorf("failed to get the value set after compaction: %s, %v", value, err)
+		}
+		if _, err := db.Get("deleted"); !errors.Is(err, diskdatabase.ErrNotFound) {
+			t.Errorf("failed to keep the value deleted: %v", err)
+		}
+		if keys, _ := db.Keys(); len(keys) != 2 {
+			t.Errorf("failed to get the keys, got %v", keys)
+		}
+	})
+
+	t.Run("Corrupted Record", func(t *testing.T) {
+		info, _ := os.Stat(path)
+
+		db, err := diskdatabase.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		if err := db.Set("key", []byte("v4")); err != nil {
+			t.Fatalf("failed to set value: %v", err)
+		}
+		_ = db.Close()
+
+		// flip the last byte of the value, the checksum does not match anymore
+		data, _ := os.ReadFile(path)
+		data[len(data)-1] ^= 0xff
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("failed to corrupt the log: %v", err)
+		}
+
+		db, err = diskdatabase.Open(path)
+		if err != nil {
+			t.Fatalf("failed to recover database: %v", err)
+		}
+		defer db.Close()
+
+		if value, err := db.Get("key"); err != nil || string(value) != "v3" {
+			t.Errorf("failed to drop the corrupted record: %s, %v", value, err)
+		}
+		if recovered, _ := os.Stat(path); recovered.Size() != info.Size() {
+			t.Errorf("failed to truncate the corrupted record, %d bytes, expected %d", recovered.Size(), info.Size())
+		}
+	})
+}
+
+func TestDiskDatabaseTx(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+
+	db, err := diskdatabase.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	_ = tx.Set("key1", []byte("value1"))
+	_ = tx.Set("key2", []byte("value2"))
+	if _, err := db.Get("key1"); !errors.Is(err, diskdatabase.ErrNotFound) {
+		t.Errorf("failed to isolate the write of the transaction: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit transaction: %v", err)
+	}
+
+	rolledBack, _ := db.Begin()
+	_ = rolledBack.Set("key3", []byte("value3"))
+	_ = rolledBack.Rollback()
+	_ = db.Close()
+
+	t.Run("Torn Transaction", func(t *testing.T) {
+		// the transaction record cut short by a crash, none of its writes are kept
+		info, _ := os.Stat(path)
+		db, _ := diskdatabase.Open(path)
+		tx, _ := db.Begin()
+		_ = tx.Set("key1", []byte("other1"))
+		_ = tx.Set("key2", []byte("other2"))
+		_ = tx.Commit()
+		_ = db.Close()
+
+		committed, _ := os.Stat(path)
+		if err := os.Truncate(path, committed.Size()-3); err != nil {
+			t.Fatalf("failed to truncate the log: %v", err)
+		}
+
+		db, err := diskdatabase.Open(path)
+		if err != nil {
+			t.Fatalf("failed to recover database: %v", err)
+		}
+		defer db.Close()
+
+		for key, expected := range map[string]string{"key1": "value1", "key2": "value2"} {
+			if value, err := db.Get(key); err != nil || string(value) != expected {
+				t.Errorf("failed to keep the previous value of %s: %s, %v", key, value, err)
+			}
+		}
+		if _, err := db.Get("key3"); !errors.Is(err, diskdatabase.ErrNotFound) {
+			t.Errorf("failed to drop the rolled back write: %v", err)
+		}
+		if recovered, _ := os.Stat(path); recovered.Size() != info.Size() {
+			t.Errorf("failed to truncate the torn record, %d bytes, expected %d", recovered.Size(), info.Size())
+		}
+	})
+}
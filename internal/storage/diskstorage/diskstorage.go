@@ -0,0 +1,35 @@
+package diskstorage
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/hoangan/superwallet/internal/storage/diskstorage/diskdatabase"
+	"github.com/hoangan/superwallet/internal/storage/kvstorage"
+)
+
+// LogFile is the name of the database log file within the data directory.
+const LogFile = "superwallet.log"
+
+// DiskStorage saves everything to the data directory, the indexer resumes from it after a restart or a crash.
+type DiskStorage struct {
+	*kvstorage.KVStorage
+}
+
+// New opens the storage of the data directory, created when missing.
+// The changes partially written by a crash are dropped, the indexer processes their block again
+// from the saved checkpoint.
+func New(dataDir string) (*DiskStorage, error) {
+	db, err := diskdatabase.Open(filepath.Join(dataDir, LogFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open disk storage: %w", err)
+	}
+
+	store, err := kvstorage.New(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to open disk storage: %w", err)
+	}
+
+	return &DiskStorage{KVStorage: store}, nil
+}
@@ -0,0 +1,140 @@
+package diskstorage_test
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/storage"
+	"github.com/hoangan/superwallet/internal/storage/diskstorage"
+	"github.com/hoangan/superwallet/internal/storage/diskstorage/diskdatabase"
+	"github.com/hoangan/superwallet/internal/storage/kvstorage"
+	"github.com/hoangan/superwallet/internal/storage/storagetest"
+)
+
+func TestDiskStorageSuite(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		store, err := diskstorage.New(t.TempDir())
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		return store
+	})
+}
+
+func TestDiskStorageReopen(t *testing.T) {
+	dataDir := t.TempDir()
+	address := "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	txn := storagetest.NewTransaction("0xt1", 7)
+
+	store, err := diskstorage.New(dataDir)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.SubscribeAddress(address); err != nil {
+		t.Fatalf("failed to subscribe address: %v", err)
+	}
+	if err := store.SetWebhook(address, &m.Webhook{URL: "https://example.com/hook"}); err != nil {
+		t.Fatalf("failed to set webhook: %v", err)
+	}
+	checkpoint := &m.Checkpoint{BlockNumber: big.NewInt(7), BlockHash: txn.BlockHash}
+	if err := store.CommitBlock(checkpoint, []*m.AddressTransaction{{Address: address, Transaction: txn}}); err != nil {
+		t.Fatalf("failed to commit block: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close storage: %v", err)
+	}
+
+	t.Run("Resume After Restart", func(t *testing.T) {
+		store, err := diskstorage.New(dataDir)
+		if err != nil {
+			t.Fatalf("failed to reopen storage: %v", err)
+		}
+		defer store.Close()
+
+		if !store.IsSubscribedAddress(address) {
+			t.Errorf("failed to keep the subscribed address")
+		}
+		if saved, err := store.GetCheckpoint(); err != nil || saved.BlockNumber.Int64() != 7 {
+			t.Errorf("failed to keep the checkpoint: %v, %v", saved, err)
+		}
+		if transactions, err := store.GetTransactionsByAddress(address); err != nil || len(transactions) != 1 || transactions[0].Hash != txn.Hash {
+			t.Errorf("failed to keep the transactions: %v, %v", transactions, err)
+		}
+
+		// delivered by the next run
+		if due, err := store.GetDueDeliveries(time.Now(), 10); err != nil || len(due) != 1 || due[0].Event.Transaction.Hash != txn.Hash {
+			t.Errorf("failed to keep the pending delivery: %+v, %v", due, err)
+		}
+	})
+
+	t.Run("Index Saved Transactions", func(t *testing.T) {
+		// saved before the address indexes were added
+		db, err := diskdatabase.Open(filepath.Join(dataDir, diskstorage.LogFile))
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		keys, err := db.Keys()
+		if err != nil {
+			t.Fatalf("failed to get keys: %v", err)
+		}
+		for _, key := range keys {
+			if strings.HasPrefix(key, kvstorage.AddressIndexPrefix) || key == kvstorage.AddressIndexVersion {
+				if err := db.Delete(key); err != nil {
+					t.Fatalf("failed to delete key: %v", err)
+				}
+			}
+		}
+		// the transactions of the address were listed by their hashes
+		if err := db.Set(address, []byte(`["`+txn.Hash+`"]`)); err != nil {
+			t.Fatalf("failed to save the transaction hashes: %v", err)
+		}
+		_ = db.Close()
+
+		store, err := diskstorage.New(dataDir)
+		if err != nil {
+			t.Fatalf("failed to reopen storage: %v", err)
+		}
+		defer store.Close()
+
+		page, err := store.QueryTransactions(&m.TransactionQuery{Address: address})
+		if err != nil || len(page.Transactions) != 1 || page.Transactions[0].Hash != txn.Hash {
+			t.Errorf("failed to index the saved transactions: %+v, %v", page, err)
+		}
+	})
+
+	t.Run("Recover After Crash", func(t *testing.T) {
+		// a record partially written when the process crashed
+		path := filepath.Join(dataDir, diskstorage.LogFile)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			t.Fatalf("failed to open log file: %v", err)
+		}
+		_, _ = file.Write([]byte{0x40, 0, 0, 0, 0x12, 0x34, 0x56, 0x78, 1, 5, 'h'})
+		_ = file.Close()
+
+		store, err := diskstorage.New(dataDir)
+		if err != nil {
+			t.Fatalf("failed to recover storage: %v", err)
+		}
+		defer store.Close()
+
+		if saved, err := store.GetCheckpoint(); err != nil || saved.BlockNumber.Int64() != 7 {
+			t.Errorf("failed to keep the checkpoint: %v, %v", saved, err)
+		}
+
+		// the next changes are written after the last complete record
+		next := storagetest.NewTransaction("0xt2", 8)
+		if err := store.CommitBlock(&m.Checkpoint{BlockNumber: big.NewInt(8), BlockHash: next.BlockHash},
+			[]*m.AddressTransaction{{Address: address, Transaction: next}}); err != nil {
+			t.Fatalf("failed to commit block: %v", err)
+		}
+		if transactions, err := store.GetTransactionsByAddress(address); err != nil || len(transactions) != 2 {
+			t.Errorf("failed to commit block after recovery: %v, %v", transactions, err)
+		}
+	})
+}
@@ -1,16 +1,20 @@
 package inmemorydatabase
 
 import (
-	"errors"
 	"sync"
+
+	"github.com/hoangan/superwallet/internal/storage/kvstorage"
 )
 
 var (
 	// ErrNotFound is returned when the key is not found in the database.
-	ErrNotFound = errors.New("key not found")
+	ErrNotFound = kvstorage.ErrNotFound
 
 	// ErrDBClosed is returned when the database is closed.
-	ErrDBClosed = errors.New("database closed")
+	ErrDBClosed = kvstorage.ErrDBClosed
+
+	// ErrTxDone is returned when the transaction is used after being committed or rolled back.
+	ErrTxDone = kvstorage.ErrTxDone
 )
 
 // Simple in-memory key-value database.
@@ -18,6 +22,9 @@ var (
 type InMemoryDatabase struct {
 	db   map[string][]byte
 	lock sync.RWMutex
+
+	// held by the transaction in progress, the transactions are serialized
+	writer sync.Mutex
 }
 
 func New() *InMemoryDatabase {
@@ -27,13 +34,13 @@ func New() *InMemoryDatabase {
 }
 
 func (d *InMemoryDatabase) Get(key string) ([]byte, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
 	if d.db == nil {
 		return nil, ErrDBClosed
 	}
 
-	d.lock.RLock()
-	defer d.lock.RUnlock()
-
 	if value, ok := d.db[key]; ok {
 		return value, nil
 	}
@@ -42,38 +49,39 @@ func (d *InMemoryDatabase) Get(key string) ([]byte, error) {
 }
 
 func (d *InMemoryDatabase) Set(key string, value []byte) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
 	if d.db == nil {
 		return ErrDBClosed
 	}
 
-	d.lock.Lock()
-	defer d.lock.Unlock()
-
 	d.db[key] = value
 
 	return nil
 }
 
 func (d *InMemoryDatabase) Delete(key string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
 	if d.db == nil {
 		return ErrDBClosed
 	}
 
-	d.lock.Lock()
-	defer d.lock.Unlock()
-
 	delete(d.db, key)
 
 	return nil
 }
 
 func (d *InMemoryDatabase) Keys() ([]string, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
 	if d.db == nil {
 		return nil, ErrDBClosed
 	}
 
-	d.lock.RLock()
-	defer d.lock.RUnlock()
 	keys := make([]string, 0, len(d.db))
 	for key := range d.db {
 		keys = append(keys, key)
@@ -81,9 +89,113 @@ func (d *InMemoryDatabase) Keys() ([]string, error) {
 	return keys, nil
 }
 
-func (d *InMemoryDatabase) Close() {
+func (d *InMemoryDatabase) Close() error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
 	d.db = nil
+
+	return nil
+}
+
+// Begin starts a transaction once the transaction in progress is done.
+// The transaction must be committed or rolled back for the next one to start.
+func (d *InMemoryDatabase) Begin() (kvstorage.Tx, error) {
+	d.writer.Lock()
+
+	d.lock.RLock()
+	closed := d.db == nil
+	d.lock.RUnlock()
+
+	if closed {
+		d.writer.Unlock()
+		return nil, ErrDBClosed
+	}
+
+	return &Tx{db: d, writes: make(map[string]*write)}, nil
+}
+
+// write is a value set by a transaction, or a deleted key.
+type write struct {
+	value   []byte
+	deleted bool
+}
+
+// Tx buffers its writes until committed, serialized with the other transactions of the database.
+type Tx struct {
+	db     *InMemoryDatabase
+	writes map[string]*write
+	done   bool
+}
+
+func (t *Tx) Get(key string) ([]byte, error) {
+	if t.done {
+		return nil, ErrTxDone
+	}
+
+	if w, ok := t.writes[key]; ok {
+		if w.deleted {
+			return nil, ErrNotFound
+		}
+		return w.value, nil
+	}
+
+	return t.db.Get(key)
+}
+
+func (t *Tx) Set(key string, value []byte) error {
+	if t.done {
+		return ErrTxDone
+	}
+
+	t.writes[key] = &write{value: value}
+
+	return nil
+}
+
+func (t *Tx) Delete(key string) error {
+	if t.done {
+		return ErrTxDone
+	}
+
+	t.writes[key] = &write{deleted: true}
+
+	return nil
+}
+
+// Commit applies the writes of the transaction all at once, the readers see either none or all of them.
+func (t *Tx) Commit() error {
+	if t.done {
+		return ErrTxDone
+	}
+	t.done = true
+	defer t.db.writer.Unlock()
+
+	t.db.lock.Lock()
+	defer t.db.lock.Unlock()
+
+	if t.db.db == nil {
+		return ErrDBClosed
+	}
+
+	for key, w := range t.writes {
+		if w.deleted {
+			delete(t.db.db, key)
+		} else {
+			t.db.db[key] = w.value
+		}
+	}
+
+	return nil
+}
+
+// Rollback drops the writes of the transaction.
+func (t *Tx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	t.db.writer.Unlock()
+
+	return nil
 }
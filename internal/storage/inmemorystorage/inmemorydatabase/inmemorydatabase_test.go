@@ -0,0 +1,92 @@
+package inmemorydatabase_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hoangan/superwallet/internal/storage/inmemorystorage/inmemorydatabase"
+)
+
+func TestInMemoryDatabaseTx(t *testing.T) {
+	db := inmemorydatabase.New()
+	if err := db.Set("deleted", []byte("value")); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+
+	t.Run("Commit", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("failed to begin transaction: %v", err)
+		}
+		_ = tx.Set("key", []byte("value"))
+		_ = tx.Delete("deleted")
+
+		// the transaction sees its own writes, the others only once committed
+		if value, err := tx.Get("key"); err != nil || string(value) != "value" {
+			t.Errorf("failed to read the write of the transaction: %s, %v", value, err)
+		}
+		if _, err := db.Get("key"); !errors.Is(err, inmemorydatabase.ErrNotFound) {
+			t.Errorf("failed to isolate the write of the transaction: %v", err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("failed to commit transaction: %v", err)
+		}
+		if value, err := db.Get("key"); err != nil || string(value) != "value" {
+			t.Errorf("failed to commit the write: %s, %v", value, err)
+		}
+		if _, err := db.Get("deleted"); !errors.Is(err, inmemorydatabase.ErrNotFound) {
+			t.Errorf("failed to commit the delete: %v", err)
+		}
+		if err := tx.Commit(); !errors.Is(err, inmemorydatabase.ErrTxDone) {
+			t.Errorf("failed to reject a second commit: %v", err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("failed to begin transaction: %v", err)
+		}
+		_ = tx.Set("key", []byte("other"))
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("failed to roll back transaction: %v", err)
+		}
+
+		if value, err := db.Get("key"); err != nil || string(value) != "value" {
+			t.Errorf("failed to drop the write: %s, %v", value, err)
+		}
+	})
+
+	t.Run("Serialized", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("failed to begin transaction: %v", err)
+		}
+
+		started := make(chan struct{})
+		go func() {
+			defer close(started)
+			next, err := db.Begin()
+			if err != nil {
+				t.Errorf("failed to begin transaction: %v", err)
+				return
+			}
+			_ = next.Rollback()
+		}()
+
+		select {
+		case <-started:
+			t.Fatalf("failed to wait for the transaction in progress")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		_ = tx.Commit()
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("failed to start the next transaction once done")
+		}
+	})
+}
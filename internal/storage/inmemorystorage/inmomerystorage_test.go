@@ -1,9 +1,14 @@
 package inmemorystorage_test
 
 import (
+	"errors"
+	"math/big"
 	"testing"
 
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/storage"
 	"github.com/hoangan/superwallet/internal/storage/inmemorystorage"
+	"github.com/hoangan/superwallet/internal/storage/storagetest"
 	"github.com/hoangan/superwallet/internal/testdata"
 )
 
@@ -40,4 +45,69 @@ func TestInMemoryStorage(t *testing.T) {
 			t.Errorf("failed to get transactions by address txs count: %d", len(transactions))
 		}
 	})
+
+	t.Run("Rollback Block", func(t *testing.T) {
+		parent := &m.Checkpoint{BlockNumber: new(big.Int).Sub(testdata.Transaction1.BlockNumber, big.NewInt(1))}
+		removed, err := storage.RollbackBlock(testdata.Transaction1.BlockNumber, parent)
+		if err != nil {
+			t.Errorf("failed to roll back block: %v", err)
+		}
+
+		if len(removed) != 1 || removed[0].Address != address || removed[0].Transaction.Hash != testdata.Transaction1.Hash {
+			t.Errorf("failed to remove block transactions: %v", removed)
+		}
+
+		transactions, err := storage.GetTransactionsByAddress(address)
+		if err != nil {
+			t.Errorf("failed to get transactions by address: %v", err)
+		}
+
+		if len(transactions) != 0 {
+			t.Errorf("failed to remove block transactions txs count: %d", len(transactions))
+		}
+	})
+
+	t.Run("Commit Block", func(t *testing.T) {
+		checkpoint := &m.Checkpoint{BlockNumber: testdata.Transaction1.BlockNumber, BlockHash: testdata.Transaction1.BlockHash}
+		err := storage.CommitBlock(checkpoint, []*m.AddressTransaction{{Address: address, Transaction: testdata.Transaction1}})
+		if err != nil {
+			t.Errorf("failed to commit block: %v", err)
+		}
+
+		saved, err := storage.GetCheckpoint()
+		if err != nil || saved.BlockNumber.Cmp(checkpoint.BlockNumber) != 0 || saved.BlockHash != checkpoint.BlockHash {
+			t.Errorf("failed to get checkpoint: %v, %v", saved, err)
+		}
+
+		transactions, _ := storage.GetTransactionsByAddress(address)
+		if len(transactions) != 1 {
+			t.Errorf("failed to commit block txs count: %d", len(transactions))
+		}
+	})
+}
+
+func TestInMemoryStorageCheckpoint(t *testing.T) {
+	store, _ := inmemorystorage.New()
+
+	if _, err := store.GetCheckpoint(); !errors.Is(err, storage.ErrCheckpointNotFound) {
+		t.Errorf("failed to report missing checkpoint: %v", err)
+	}
+
+	if err := store.SaveCheckpoint(&m.Checkpoint{BlockNumber: big.NewInt(10), BlockHash: "0xa10"}); err != nil {
+		t.Errorf("failed to save checkpoint: %v", err)
+	}
+
+	if checkpoint, err := store.GetCheckpoint(); err != nil || checkpoint.BlockNumber.Int64() != 10 {
+		t.Errorf("failed to get checkpoint: %v, %v", checkpoint, err)
+	}
+}
+
+func TestInMemoryStorageSuite(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		store, err := inmemorystorage.New()
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		return store
+	})
 }
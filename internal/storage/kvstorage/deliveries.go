@@ -0,0 +1,322 @@
+package kvstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/storage"
+)
+
+const (
+	// DeliveriesKey is the key of the index of the webhook deliveries: the last id given,
+	// the pending deliveries by next attempt and the delivered ones in the order they were delivered.
+	// The dead deliveries are only found by their key.
+	DeliveriesKey = "webhook_deliveries"
+
+	// DeliveryPrefix is the key prefix of a webhook delivery along with its attempts.
+	DeliveryPrefix = "webhook_delivery:"
+)
+
+// deliveryIndex indexes the deliveries the notifier works on, so the due deliveries and the ones to prune
+// are found without loading the others, it is as large as the pending deliveries and the delivery history.
+type deliveryIndex struct {
+	LastID    int64              `json:"lastId"`
+	Pending   []*pendingDelivery `json:"pending"`
+	Delivered []int64            `json:"delivered"`
+}
+
+// pendingDelivery is a pending delivery in the index, ordered by next attempt then id.
+type pendingDelivery struct {
+	ID            int64     `json:"id"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+}
+
+func (s *KVStorage) GetDueDeliveries(now time.Time, limit int) ([]*m.Delivery, error) {
+	index, err := getDeliveryIndex(s.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due deliveries: %w", err)
+	}
+
+	deliveries := []*m.Delivery{}
+	for _, pending := range index.Pending {
+		if len(deliveries) >= limit || pending.NextAttemptAt.After(now) {
+			break
+		}
+
+		d, err := getDelivery(s.db, pending.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get due deliveries: %w", err)
+		}
+		d.Attempts = nil
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
+
+// GetDeliveries loads the deliveries found by their key, it is meant for debugging and not for the hot path.
+func (s *KVStorage) GetDeliveries(address string, state m.DeliveryState) ([]*m.Delivery, error) {
+	deliveries, err := getDeliveries(s.db, s.db, func(d *m.Delivery) bool {
+		return (address == "" || d.Event.Address == address) && (state == "" || d.State == state)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *KVStorage) GetDelivery(id int64) (*m.Delivery, error) {
+	return getDelivery(s.db, id)
+}
+
+func (s *KVStorage) UpdateDelivery(delivery *m.Delivery, attempt *m.DeliveryAttempt) error {
+	err := s.update(func(tx Tx) error {
+		saved, err := getDelivery(tx, delivery.ID)
+		if err != nil {
+			return err
+		}
+
+		index, err := getDeliveryIndex(tx)
+		if err != nil {
+			return err
+		}
+		index.removePending(saved.ID)
+		switch delivery.State {
+		case m.DeliveryStatePending:
+			index.addPending(saved.ID, delivery.NextAttemptAt)
+		case m.DeliveryStateDelivered:
+			if saved.State != m.DeliveryStateDelivered {
+				index.Delivered = append(index.Delivered, saved.ID)
+			}
+		}
+		if err := encodeAndSave(tx, DeliveriesKey, index); err != nil {
+			return err
+		}
+
+		saved.URL = delivery.URL
+		saved.State = delivery.State
+		saved.Failures = delivery.Failures
+		saved.NextAttemptAt = delivery.NextAttemptAt
+		if attempt != nil {
+			saved.Attempts = append(saved.Attempts, attempt)
+		}
+
+		return encodeAndSave(tx, deliveryKey(saved.ID), saved)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update delivery %d: %w", delivery.ID, err)
+	}
+
+	return nil
+}
+
+// PruneDeliveries deletes the first delivered deliveries but the keep last ones, found by the index.
+func (s *KVStorage) PruneDeliveries(keep int) error {
+	err := s.update(func(tx Tx) error {
+		index, err := getDeliveryIndex(tx)
+		if err != nil {
+			return err
+		}
+		if len(index.Delivered) <= keep {
+			return nil
+		}
+
+		pruned := index.Delivered[:len(index.Delivered)-keep]
+		for _, id := range pruned {
+			if err := tx.Delete(deliveryKey(id)); err != nil {
+				return err
+			}
+		}
+		index.Delivered = append([]int64{}, index.Delivered[len(pruned):]...)
+
+		return encodeAndSave(tx, DeliveriesKey, index)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune deliveries: %w", err)
+	}
+
+	return nil
+}
+
+// addDeliveries creates the pending deliveries of the events of the address transactions within the transaction,
+// for the addresses whose webhook matches the type of the event.
+// The transaction of the event is saved as it is at the time of the event.
+func addDeliveries(tx Tx, eventType m.EventType, addressTxs []*m.AddressTransaction) error {
+	var index *deliveryIndex
+	now := time.Now()
+	for _, addressTx := range addressTxs {
+		subscription, err := getSubscription(tx, addressTx.Address)
+		if err != nil {
+			return fmt.Errorf("failed to get webhook of %s: %w", addressTx.Address, err)
+		}
+		if subscription.Webhook == nil || !subscription.Webhook.Matches(eventType) {
+			continue
+		}
+
+		if index == nil {
+			if index, err = getDeliveryIndex(tx); err != nil {
+				return err
+			}
+		}
+
+		index.LastID++
+		index.addPending(index.LastID, now)
+		delivery := &m.Delivery{
+			ID:            index.LastID,
+			URL:           subscription.Webhook.URL,
+			Event:         &m.Event{Type: eventType, Address: addressTx.Address, Transaction: addressTx.Transaction},
+			State:         m.DeliveryStatePending,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+			Attempts:      []*m.DeliveryAttempt{},
+		}
+		if err := encodeAndSave(tx, deliveryKey(delivery.ID), delivery); err != nil {
+			return fmt.Errorf("failed to save delivery: %w", err)
+		}
+	}
+
+	if index == nil {
+		return nil
+	}
+
+	return encodeAndSave(tx, DeliveriesKey, index)
+}
+
+// deleteAddressDeliveries deletes the deliveries of the events of the address, along with their index entries.
+func deleteAddressDeliveries(db Database, tx Tx, address string) error {
+	deleted, err := getDeliveries(db, tx, func(d *m.Delivery) bool { return d.Event.Address == address })
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	index, err := getDeliveryIndex(tx)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[int64]bool, len(deleted))
+	for _, d := range deleted {
+		ids[d.ID] = true
+		index.removePending(d.ID)
+		if err := tx.Delete(deliveryKey(d.ID)); err != nil {
+			return err
+		}
+	}
+
+	delivered := make([]int64, 0, len(index.Delivered))
+	for _, id := range index.Delivered {
+		if !ids[id] {
+			delivered = append(delivered, id)
+		}
+	}
+	index.Delivered = delivered
+
+	return encodeAndSave(tx, DeliveriesKey, index)
+}
+
+// getDeliveries returns the deliveries matching the filter in the order they were created,
+// the deliveries are found by the keys of the database and read through the reader.
+func getDeliveries(db Database, r reader, filter func(*m.Delivery) bool) ([]*m.Delivery, error) {
+	keys, err := db.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int64{}
+	for _, key := range keys {
+		if value, ok := strings.CutPrefix(key, DeliveryPrefix); ok {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse delivery key %s: %w", key, err)
+			}
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	deliveries := []*m.Delivery{}
+	for _, id := range ids {
+		d, err := getDelivery(r, id)
+		// deleted since the keys were listed
+		if errors.Is(err, storage.ErrDeliveryNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if filter(d) {
+			deliveries = append(deliveries, d)
+		}
+	}
+
+	return deliveries, nil
+}
+
+// getDelivery loads the delivery along with its attempts, storage.ErrDeliveryNotFound if none.
+func getDelivery(r reader, id int64) (*m.Delivery, error) {
+	deliveryBytes, err := r.Get(deliveryKey(id))
+	if errors.Is(err, ErrNotFound) {
+		return nil, storage.ErrDeliveryNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get delivery: %w", err)
+	}
+
+	var delivery m.Delivery
+	if err := json.Unmarshal(deliveryBytes, &delivery); err != nil {
+		return nil, fmt.Errorf("failed to load delivery: %w", err)
+	}
+
+	return &delivery, nil
+}
+
+// getDeliveryIndex returns the index of the deliveries, empty before the first delivery.
+func getDeliveryIndex(r reader) (*deliveryIndex, error) {
+	indexBytes, err := r.Get(DeliveriesKey)
+	if errors.Is(err, ErrNotFound) {
+		return &deliveryIndex{Pending: []*pendingDelivery{}, Delivered: []int64{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var index deliveryIndex
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return nil, fmt.Errorf("failed to get delivery index: %w", err)
+	}
+
+	return &index, nil
+}
+
+// addPending inserts the pending delivery in the order of the next attempt, then of the id.
+func (index *deliveryIndex) addPending(id int64, nextAttemptAt time.Time) {
+	j := sort.Search(len(index.Pending), func(j int) bool {
+		pending := index.Pending[j]
+		return pending.NextAttemptAt.After(nextAttemptAt) || (pending.NextAttemptAt.Equal(nextAttemptAt) && pending.ID > id)
+	})
+
+	index.Pending = append(index.Pending, nil)
+	copy(index.Pending[j+1:], index.Pending[j:])
+	index.Pending[j] = &pendingDelivery{ID: id, NextAttemptAt: nextAttemptAt}
+}
+
+// removePending removes the delivery from the pending ones, if there.
+func (index *deliveryIndex) removePending(id int64) {
+	for j, pending := range index.Pending {
+		if pending.ID == id {
+			index.Pending = append(index.Pending[:j], index.Pending[j+1:]...)
+			return
+		}
+	}
+}
+
+func deliveryKey(id int64) string {
+	return DeliveryPrefix + strconv.FormatInt(id, 10)
+}
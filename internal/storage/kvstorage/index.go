@@ -0,0 +1,423 @@
+package kvstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	m "github.com/hoangan/superwallet/internal/models"
+)
+
+const (
+	// AddressIndexPrefix is the key prefix of the index of the transactions of an address,
+	// the sorted list of its buckets, each bucket is saved under the key of the list followed by :<bucket>.
+	AddressIndexPrefix = "address_index:"
+
+	// AddressIndexVersion is the key of the version of the address indexes, set once they are built.
+	AddressIndexVersion = "address_index_version"
+
+	// the address indexes replaced the lists of the transaction hashes of the addresses in version 2
+	addressIndexVersion = 2
+
+	// number of blocks of a bucket of the address index, a query reads the buckets of its block range only
+	indexBucketSize = 10000
+)
+
+// indexEntry is a transaction of an address in the address index, with the fields the queries filter on,
+// so only the transactions of the page are loaded.
+type indexEntry struct {
+	BlockNumber      int64              `json:"b"`
+	TransactionIndex int64              `json:"i"`
+	Hash             string             `json:"h"`
+	Timestamp        int64              `json:"t,omitempty"`
+	State            m.TransactionState `json:"s"`
+	Sent             bool               `json:"o,omitempty"`
+	Transfers        []*indexTransfer   `json:"tr,omitempty"`
+}
+
+// indexTransfer is a transfer from or to the address of the index entry.
+type indexTransfer struct {
+	CoinID int64    `json:"c"`
+	Value  *big.Int `json:"v,omitempty"`
+	In     bool     `json:"in,omitempty"`
+	Out    bool     `json:"out,omitempty"`
+}
+
+func newIndexEntry(address string, txn *m.Transaction) *indexEntry {
+	cursor := m.CursorOf(txn)
+	entry := &indexEntry{
+		BlockNumber:      cursor.BlockNumber,
+		TransactionIndex: cursor.TransactionIndex,
+		Hash:             txn.Hash,
+		State:            txn.State,
+		Sent:             txn.From == address,
+	}
+	if txn.Timestamp != nil {
+		entry.Timestamp = txn.Timestamp.Int64()
+	}
+
+	for _, transfer := range txn.Transfers {
+		if transfer.From != address && transfer.To != address {
+			continue
+		}
+		entry.Transfers = append(entry.Transfers, &indexTransfer{
+			CoinID: transfer.CoinID,
+			Value:  transfer.Value,
+			In:     transfer.To == address,
+			Out:    transfer.From == address,
+		})
+	}
+
+	return entry
+}
+
+func (e *indexEntry) cursor() *m.Cursor {
+	return &m.Cursor{BlockNumber: e.BlockNumber, TransactionIndex: e.TransactionIndex, Hash: e.Hash}
+}
+
+// matches reports whether the transaction of the entry matches the filters of the query, but the cursor.
+func (e *indexEntry) matches(query *m.TransactionQuery) bool {
+	if query.FromBlock != nil && e.BlockNumber < query.FromBlock.Int64() {
+		return false
+	}
+	if query.ToBlock != nil && e.BlockNumber > query.ToBlock.Int64() {
+		return false
+	}
+	if !query.FromTime.IsZero() && e.Timestamp < query.FromTime.Unix() {
+		return false
+	}
+	if !query.ToTime.IsZero() && e.Timestamp >= query.ToTime.Unix() {
+		return false
+	}
+	if !query.MatchesState(e.State) {
+		return false
+	}
+
+	if !query.FiltersTransfers() {
+		return true
+	}
+	if e.Sent && query.MatchesSent() {
+		return true
+	}
+	for _, transfer := range e.Transfers {
+		if query.MatchesTransfer(transfer.CoinID, transfer.Value, transfer.In, transfer.Out) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func addressIndexKey(address string) string {
+	return AddressIndexPrefix + address
+}
+
+func indexBucketKey(address string, bucket int64) string {
+	return addressIndexKey(address) + ":" + strconv.FormatInt(bucket, 10)
+}
+
+func indexBucketOf(blockNumber int64) int64 {
+	return blockNumber / indexBucketSize
+}
+
+// getIndexBuckets returns the sorted buckets of the address index, empty if none.
+func getIndexBuckets(r reader, address string) ([]int64, error) {
+	bucketsBytes, err := r.Get(addressIndexKey(address))
+	if errors.Is(err, ErrNotFound) {
+		return []int64{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var buckets []int64
+	if err := json.Unmarshal(bucketsBytes, &buckets); err != nil {
+		return nil, fmt.Errorf("failed to get address index: %w", err)
+	}
+
+	return buckets, nil
+}
+
+// getIndexBucket returns the entries of the bucket of the address index, sorted by their cursor.
+func getIndexBucket(r reader, address string, bucket int64) ([]*indexEntry, error) {
+	entriesBytes, err := r.Get(indexBucketKey(address, bucket))
+	if errors.Is(err, ErrNotFound) {
+		return []*indexEntry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []*indexEntry
+	if err := json.Unmarshal(entriesBytes, &entries); err != nil {
+		return nil, fmt.Errorf("failed to get address index bucket: %w", err)
+	}
+
+	return entries, nil
+}
+
+// saveIndexBucket saves the entries of the bucket, and adds the bucket to the index or removes it when empty.
+func saveIndexBucket(tx Tx, address string, bucket int64, entries []*indexEntry) error {
+	buckets, err := getIndexBuckets(tx, address)
+	if err != nil {
+		return err
+	}
+
+	position := sort.Search(len(buckets), func(j int) bool { return buckets[j] >= bucket })
+	exists := position < len(buckets) && buckets[position] == bucket
+
+	if len(entries) == 0 {
+		if !exists {
+			return nil
+		}
+		if err := tx.Delete(indexBucketKey(address, bucket)); err != nil {
+			return err
+		}
+		return encodeAndSave(tx, addressIndexKey(address), append(buckets[:position], buckets[position+1:]...))
+	}
+
+	if err := encodeAndSave(tx, indexBucketKey(address, bucket), entries); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	buckets = append(buckets, 0)
+	copy(buckets[position+1:], buckets[position:])
+	buckets[position] = bucket
+	return encodeAndSave(tx, addressIndexKey(address), buckets)
+}
+
+// indexAddressTransaction adds the transaction to the address index, in the order of the cursors.
+// Reports whether the transaction is added, false when the address index already has it.
+func indexAddressTransaction(tx Tx, address string, txn *m.Transaction) (bool, error) {
+	entry := newIndexEntry(address, txn)
+	bucket := indexBucketOf(entry.BlockNumber)
+
+	entries, err := getIndexBucket(tx, address, bucket)
+	if err != nil {
+		return false, err
+	}
+
+	position := sort.Search(len(entries), func(j int) bool { return entries[j].cursor().Compare(entry.cursor()) >= 0 })
+	if position < len(entries) && entries[position].Hash == entry.Hash {
+		return false, nil
+	}
+	entries = append(entries, nil)
+	copy(entries[position+1:], entries[position:])
+	entries[position] = entry
+
+	return true, saveIndexBucket(tx, address, bucket, entries)
+}
+
+// getIndexEntries returns the entries of all the buckets of the address index, sorted by their cursor.
+func getIndexEntries(r reader, address string) ([]*indexEntry, error) {
+	buckets, err := getIndexBuckets(r, address)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*indexEntry{}
+	for _, bucket := range buckets {
+		bucketEntries, err := getIndexBucket(r, address, bucket)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, bucketEntries...)
+	}
+
+	return entries, nil
+}
+
+// updateIndexEntry changes the entry of the transaction of the block in the address index,
+// or removes it when update returns false.
+func updateIndexEntry(tx Tx, address string, blockNumber *big.Int, hash string, update func(entry *indexEntry) bool) error {
+	bucket := indexBucketOf(blockNumber.Int64())
+	entries, err := getIndexBucket(tx, address, bucket)
+	if err != nil {
+		return err
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Hash != hash || update(entry) {
+			kept = append(kept, entry)
+		}
+	}
+
+	return saveIndexBucket(tx, address, bucket, kept)
+}
+
+// deleteAddressIndex deletes the buckets of the address index along with the index.
+func deleteAddressIndex(tx Tx, address string) error {
+	buckets, err := getIndexBuckets(tx, address)
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		if err := tx.Delete(indexBucketKey(address, bucket)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Delete(addressIndexKey(address))
+}
+
+// buildAddressIndexes indexes the transactions of the addresses saved to the lists of their transaction hashes,
+// once, the indexes are then kept up to date along with the transactions and the lists are emptied.
+func buildAddressIndexes(db Database, tx Tx) error {
+	var version int
+	if versionBytes, err := tx.Get(AddressIndexVersion); err == nil {
+		if err := json.Unmarshal(versionBytes, &version); err != nil {
+			return fmt.Errorf("failed to get address index version: %w", err)
+		}
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if version >= addressIndexVersion {
+		return nil
+	}
+
+	addresses, err := getAddressesWithBalances(tx)
+	if err != nil {
+		return err
+	}
+
+	// the unsubscribed addresses with their history kept are not in the subscribed addresses
+	keys, err := db.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if address, ok := strings.CutPrefix(key, SubscriptionsPrefix); ok {
+			addresses[address] = nil
+		}
+	}
+
+	for address := range addresses {
+		hashes, err := getAddressTxHashes(tx, address)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			txn, err := getTransaction(tx, hash)
+			if err != nil {
+				return err
+			}
+			if _, err := indexAddressTransaction(tx, address, txn); err != nil {
+				return err
+			}
+		}
+
+		// the address key is kept, it marks the addresses subscribed before the subscriptions were saved
+		if err := encodeAndSave(tx, address, []string{}); err != nil {
+			return err
+		}
+	}
+
+	return encodeAndSave(tx, AddressIndexVersion, addressIndexVersion)
+}
+
+// QueryTransactions returns a page of the transactions of the address matching the query.
+// Only the buckets of the address index in the block range are read, and only the transactions of the page are loaded.
+func (s *KVStorage) QueryTransactions(query *m.TransactionQuery) (*m.TransactionPage, error) {
+	if err := query.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", err)
+	}
+
+	if _, err := getSubscription(s.db, query.Address); err != nil {
+		return nil, fmt.Errorf("subscribed address does not exist: %s: %w", query.Address, err)
+	}
+
+	var after *m.Cursor
+	if query.Cursor != "" {
+		after, _ = m.DecodeCursor(query.Cursor)
+	}
+	descending := query.Order == m.SortDescending
+
+	buckets, err := getIndexBuckets(s.db, query.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %w", err)
+	}
+	if descending {
+		reversed := make([]int64, len(buckets))
+		for j, bucket := range buckets {
+			reversed[len(buckets)-1-j] = bucket
+		}
+		buckets = reversed
+	}
+
+	// beyond the range of the query or the cursor, in the order of the query
+	outOfRange := func(bucket int64) (skip bool, stop bool) {
+		first, last := bucket*indexBucketSize, (bucket+1)*indexBucketSize-1
+		if query.FromBlock != nil && last < query.FromBlock.Int64() {
+			return !descending, descending
+		}
+		if query.ToBlock != nil && first > query.ToBlock.Int64() {
+			return descending, !descending
+		}
+		if after != nil && ((!descending && last < after.BlockNumber) || (descending && first > after.BlockNumber)) {
+			return true, false
+		}
+		return false, false
+	}
+
+	// one more entry than the limit tells whether there is a next page
+	matched := make([]*indexEntry, 0, query.Limit+1)
+Buckets:
+	for _, bucket := range buckets {
+		if skip, stop := outOfRange(bucket); stop {
+			break
+		} else if skip {
+			continue
+		}
+
+		entries, err := getIndexBucket(s.db, query.Address, bucket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query transactions: %w", err)
+		}
+
+		for j := range entries {
+			entry := entries[j]
+			if descending {
+				entry = entries[len(entries)-1-j]
+			}
+
+			if after != nil {
+				if compared := entry.cursor().Compare(after); (!descending && compared <= 0) || (descending && compared >= 0) {
+					continue
+				}
+			}
+
+			if entry.matches(query) {
+				matched = append(matched, entry)
+				if len(matched) > query.Limit {
+					break Buckets
+				}
+			}
+		}
+	}
+
+	page := &m.TransactionPage{Transactions: []*m.Transaction{}}
+	if len(matched) > query.Limit {
+		matched = matched[:query.Limit]
+		page.NextCursor = matched[len(matched)-1].cursor().Encode()
+	}
+
+	for _, entry := range matched {
+		txn, err := getTransaction(s.db, entry.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query transactions: %w", err)
+		}
+		page.Transactions = append(page.Transactions, txn)
+	}
+
+	return page, nil
+}
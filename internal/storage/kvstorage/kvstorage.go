@@ -0,0 +1,839 @@
+package kvstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/storage"
+)
+
+const (
+	SubscribeAddressed = "subscribed_addresses"
+	Checkpoint         = "checkpoint"
+
+	// BlockTransactionsPrefix is the key prefix of the list of subscribed address transactions per block.
+	// Used to roll back the transactions of a block dropped by a chain reorganization.
+	BlockTransactionsPrefix = "block_transactions:"
+
+	// BalancesPrefix is the key prefix of the balances by coin of a subscribed address.
+	BalancesPrefix = "balances:"
+
+	// SubscriptionsPrefix is the key prefix of the subscription of an address.
+	SubscriptionsPrefix = "subscription:"
+)
+
+var (
+	// ErrNotFound is returned by the database when the key is not found.
+	ErrNotFound = errors.New("key not found")
+
+	// ErrDBClosed is returned by the database when it is closed.
+	ErrDBClosed = errors.New("database closed")
+
+	// ErrTxDone is returned when the transaction is used after being committed or rolled back.
+	ErrTxDone = errors.New("transaction already committed or rolled back")
+)
+
+// Database is the key-value database the storage is saved to,
+// values are stored as byte slice for storing complex data after marshalling.
+type Database interface {
+	// Get returns ErrNotFound when the key is not found.
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+	Keys() ([]string, error)
+	Close() error
+
+	// Begin starts a transaction, waiting for the transaction in progress to be done,
+	// one transaction writes at a time.
+	Begin() (Tx, error)
+}
+
+// Tx is a database transaction. Its reads see the committed values and its own writes,
+// its writes are applied all at once on Commit, none of them on Rollback.
+// Rollback after Commit does nothing, so it can be deferred.
+type Tx interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+	Commit() error
+	Rollback() error
+}
+
+// blockTransaction references a transaction saved for a subscribed address within a block,
+// along with the balance changes it made to be reverted.
+type blockTransaction struct {
+	Address        string             `json:"address"`
+	Hash           string             `json:"hash"`
+	BalanceChanges map[int64]*big.Int `json:"balanceChanges,omitempty"`
+}
+
+// KVStorage implements the storage on top of a key-value database,
+// shared by the in-memory and the on-disk storages.
+// Every change is made within a database transaction, so it is saved all at once or not at all,
+// and the concurrent changes of the shared values are never lost.
+type KVStorage struct {
+	db Database
+}
+
+// reader reads the committed values of the database, or the values seen by a transaction.
+type reader interface {
+	Get(key string) ([]byte, error)
+}
+
+func New(db Database) (*KVStorage, error) {
+	storage := &KVStorage{
+		db: db,
+	}
+
+	// Initialize the database with subscribed addresses storage,
+	// their balances are kept by address, see BalancesPrefix.
+	// A database opened again already has them, and gets the address indexes of its transactions once.
+	err := storage.update(func(tx Tx) error {
+		if _, err := tx.Get(SubscribeAddressed); errors.Is(err, ErrNotFound) {
+			if err := encodeAndSave(tx, SubscribeAddressed, make(map[string]big.Int)); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		return buildAddressIndexes(db, tx)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize the database: %w", err)
+	}
+
+	return storage, nil
+}
+
+// Close closes the database, the storage can not be used anymore.
+func (s *KVStorage) Close() error {
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("failed to close the database: %w", err)
+	}
+
+	return nil
+}
+
+// update runs fn within a database transaction, its writes are committed when fn succeeds and dropped otherwise.
+func (s *KVStorage) update(fn func(tx Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *KVStorage) GetCheckpoint() (*m.Checkpoint, error) {
+	checkpointBytes, err := s.db.Get(Checkpoint)
+	if errors.Is(err, ErrNotFound) {
+		return nil, storage.ErrCheckpointNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint from db: %w", err)
+	}
+
+	var checkpoint m.Checkpoint
+	if err := json.Unmarshal(checkpointBytes, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint from db: %w", err)
+	}
+
+	return &checkpoint, nil
+}
+
+func (s *KVStorage) SaveCheckpoint(checkpoint *m.Checkpoint) error {
+	err := s.update(func(tx Tx) error {
+		return encodeAndSave(tx, Checkpoint, checkpoint)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// CommitBlock saves the transactions of a block and moves the checkpoint to the block within one transaction,
+// in case of failure midway nothing is saved and the block is processed again from the previous checkpoint.
+// The deliveries are created for the address transactions saved for the first time only.
+func (s *KVStorage) CommitBlock(checkpoint *m.Checkpoint, txs []*m.AddressTransaction) error {
+	err := s.update(func(tx Tx) error {
+		added := make([]*m.AddressTransaction, 0, len(txs))
+		for _, addressTx := range txs {
+			ok, err := addAddressTransaction(tx, addressTx.Address, addressTx.Transaction, addressTx.BalanceChanges)
+			if err != nil {
+				return err
+			}
+			if ok {
+				added = append(added, addressTx)
+			}
+		}
+
+		if err := addDeliveries(tx, m.EventTransactionIndexed, added); err != nil {
+			return err
+		}
+
+		return encodeAndSave(tx, Checkpoint, checkpoint)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit block %s: %w", checkpoint.BlockNumber, err)
+	}
+
+	return nil
+}
+
+func (s *KVStorage) GetAddressesWithBalances() (map[string]*big.Int, error) {
+	addresses, err := getAddressesWithBalances(s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	for address := range addresses {
+		balances, err := getBalances(s.db, address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get balances of %s: %w", address, err)
+		}
+
+		addresses[address] = big.NewInt(0)
+		if balance, ok := balances[m.NativeCoinID]; ok {
+			addresses[address] = balance.Balance
+		}
+	}
+
+	return addresses, nil
+}
+
+// getAddressesWithBalances returns the subscribed addresses, the balances are loaded separately.
+func getAddressesWithBalances(r reader) (map[string]*big.Int, error) {
+	var addresses map[string]*big.Int
+
+	addressesBytes, err := r.Get(SubscribeAddressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses: %w", err)
+	}
+
+	if err := json.Unmarshal(addressesBytes, &addresses); err != nil {
+		return nil, fmt.Errorf("failed to get addresses: %w", err)
+	}
+
+	return addresses, nil
+}
+
+func (s *KVStorage) GetBalances(address string) ([]*m.Balance, error) {
+	if _, err := getSubscription(s.db, address); err != nil {
+		return nil, fmt.Errorf("subscribed address does not exist: %s: %w", address, err)
+	}
+
+	balances, err := getBalances(s.db, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balances: %w", err)
+	}
+
+	sorted := make([]*m.Balance, 0, len(balances))
+	for _, balance := range balances {
+		sorted = append(sorted, balance)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].CoinID < sorted[j].CoinID })
+
+	return sorted, nil
+}
+
+func (s *KVStorage) SeedBalance(address string, coinID int64, amount *big.Int) error {
+	err := s.update(func(tx Tx) error {
+		if _, err := tx.Get(address); err != nil {
+			return fmt.Errorf("subscribed address does not exist: %w", err)
+		}
+
+		balances, err := getBalances(tx, address)
+		if err != nil {
+			return err
+		}
+
+		balance, ok := balances[coinID]
+		if !ok {
+			balance = &m.Balance{CoinID: coinID, Balance: big.NewInt(0)}
+			balances[coinID] = balance
+		}
+		if balance.Seeded {
+			return nil
+		}
+
+		balance.Balance.Add(balance.Balance, amount)
+		balance.Seeded = true
+
+		return encodeAndSave(tx, balancesKey(address), balances)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to seed balance: %w", err)
+	}
+
+	return nil
+}
+
+// SubscribeAddress adds a new address to the list of subscribed addresses.
+// Addresses should be stored in address table in the case of sql database,
+// with coin_id, chain_id, ticker, etc.
+// For simplicity in the case of in-memory storage, we assume all addresses are for ETH native coin.
+// Could be extended by adding the coind_id in the back of the address, e.g: address:coin_id.
+func (s *KVStorage) SubscribeAddress(address string) error {
+	err := s.update(func(tx Tx) error {
+		subscription, err := getSubscription(tx, address)
+		if err == nil && subscription.State == m.SubscriptionStateActive {
+			return nil
+		} else if err != nil && !errors.Is(err, storage.ErrSubscriptionNotFound) {
+			return err
+		}
+
+		// Get the current subscribed addresses and add the new address to the list.
+		addresses, err := getAddressesWithBalances(tx)
+		if err != nil {
+			return err
+		}
+
+		// Add the new address to the collection of subscribed addresses.
+		// with initial balance of 0.
+		addresses[address] = big.NewInt(0)
+		if err := encodeAndSave(tx, SubscribeAddressed, addresses); err != nil {
+			return err
+		}
+
+		if subscription == nil {
+			// Save the address in the database, its transactions are found by the address index.
+			// The list of transaction hashes saved under the address is left empty, see buildAddressIndexes.
+			if err := encodeAndSave(tx, address, []string{}); err != nil {
+				return err
+			}
+		} else if err := unseedBalances(tx, address); err != nil {
+			// the transactions of the gap are not recorded, the balances are seeded again
+			return err
+		}
+
+		resumed := &m.Subscription{Address: address, State: m.SubscriptionStateActive}
+		if subscription != nil {
+			resumed.Webhook = subscription.Webhook
+		}
+		return encodeAndSave(tx, subscriptionKey(address), resumed)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe address: %w", err)
+	}
+
+	return nil
+}
+
+func (s *KVStorage) GetSubscription(address string) (*m.Subscription, error) {
+	return getSubscription(s.db, address)
+}
+
+func (s *KVStorage) SetWebhook(address string, webhook *m.Webhook) error {
+	err := s.update(func(tx Tx) error {
+		subscription, err := getSubscription(tx, address)
+		if err != nil {
+			return err
+		}
+
+		subscription.Webhook = webhook
+		return encodeAndSave(tx, subscriptionKey(address), subscription)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set webhook: %w", err)
+	}
+
+	return nil
+}
+
+func (s *KVStorage) PauseAddress(address string, lastBlockNumber *big.Int) error {
+	err := s.update(func(tx Tx) error {
+		subscription, err := getSubscription(tx, address)
+		if err != nil {
+			return err
+		}
+
+		switch subscription.State {
+		case m.SubscriptionStatePaused:
+			// paused since the first pause
+			return nil
+		case m.SubscriptionStateUnsubscribed:
+			return fmt.Errorf("address %s is unsubscribed", address)
+		}
+
+		subscription.State = m.SubscriptionStatePaused
+		subscription.LastBlock = lastBlockNumber
+		return encodeAndSave(tx, subscriptionKey(address), subscription)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pause address: %w", err)
+	}
+
+	return nil
+}
+
+func (s *KVStorage) UnsubscribeAddress(address string, lastBlockNumber *big.Int, purge bool) error {
+	err := s.update(func(tx Tx) error {
+		subscription, err := getSubscription(tx, address)
+		if err != nil {
+			return err
+		}
+
+		addresses, err := getAddressesWithBalances(tx)
+		if err != nil {
+			return err
+		}
+		delete(addresses, address)
+		if err := encodeAndSave(tx, SubscribeAddressed, addresses); err != nil {
+			return err
+		}
+
+		if purge {
+			return purgeAddress(s.db, tx, address)
+		}
+
+		// a paused address is not recorded since it was paused
+		if subscription.State == m.SubscriptionStateActive {
+			subscription.LastBlock = lastBlockNumber
+		}
+		subscription.State = m.SubscriptionStateUnsubscribed
+		return encodeAndSave(tx, subscriptionKey(address), subscription)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unsubscribe address: %w", err)
+	}
+
+	return nil
+}
+
+// purgeAddress deletes the subscription of the address, its balances, its transactions and its webhook deliveries,
+// the transactions shared with other addresses are kept for them.
+func purgeAddress(db Database, tx Tx, address string) error {
+	entries, err := getIndexEntries(tx, address)
+	if err != nil {
+		return err
+	}
+
+	blockNumbers := make(map[int64]*big.Int)
+	for _, entry := range entries {
+		blockNumbers[entry.BlockNumber] = big.NewInt(entry.BlockNumber)
+	}
+
+	for _, blockNumber := range blockNumbers {
+		blockTxs, err := getBlockTransactions(tx, blockNumber)
+		if err != nil {
+			return err
+		}
+
+		kept := []*blockTransaction{}
+		purged := make(map[string]bool)
+		for _, blockTx := range blockTxs {
+			if blockTx.Address == address {
+				purged[blockTx.Hash] = true
+				continue
+			}
+			kept = append(kept, blockTx)
+		}
+
+		for _, blockTx := range kept {
+			delete(purged, blockTx.Hash)
+		}
+		for hash := range purged {
+			if err := tx.Delete(hash); err != nil {
+				return err
+			}
+		}
+
+		if len(kept) == 0 {
+			err = tx.Delete(blockTransactionsKey(blockNumber))
+		} else {
+			err = encodeAndSave(tx, blockTransactionsKey(blockNumber), kept)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range []string{address, balancesKey(address), subscriptionKey(address)} {
+		if err := tx.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	if err := deleteAddressDeliveries(db, tx, address); err != nil {
+		return err
+	}
+
+	return deleteAddressIndex(tx, address)
+}
+
+func (s *KVStorage) AddAddressTransaction(address string, txn *m.Transaction) error {
+	return s.update(func(tx Tx) error {
+		_, err := addAddressTransaction(tx, address, txn, nil)
+		return err
+	})
+}
+
+// addAddressTransaction saves the transaction for the address, and applies its balance changes
+// unless the transaction is already saved for the address. Reports whether the transaction is saved for the address.
+func addAddressTransaction(tx Tx, address string, txn *m.Transaction, balanceChanges map[int64]*big.Int) (bool, error) {
+	// Store the txn only once, multiple addresses can have the same txn.
+	// It's common for exchange to batch their withdrawals into a single transaction.
+	if _, err := tx.Get(txn.Hash); errors.Is(err, ErrNotFound) {
+		if err := encodeAndSave(tx, txn.Hash, txn); err != nil {
+			return false, fmt.Errorf("failed to save transaction: %w", err)
+		}
+	} else if err != nil { //other error, e.g.: db closed
+		return false, fmt.Errorf("failed to add address transaction: %w", err)
+	}
+
+	if _, err := tx.Get(address); err != nil {
+		return false, fmt.Errorf("subscribed address does not exist: %w", err)
+	}
+
+	// The transactions of the address are found by the address index, only the bucket of the block is read
+	// to check whether the transaction is already saved for the address.
+	added, err := indexAddressTransaction(tx, address, txn)
+	if err != nil {
+		return false, fmt.Errorf("failed to add address transaction: %w", err)
+	}
+	if !added {
+		return false, nil
+	}
+
+	// Keep track of the transactions per block, so they can be rolled back on chain reorganization.
+	blockTxs, err := getBlockTransactions(tx, txn.BlockNumber)
+	if err != nil {
+		return false, fmt.Errorf("failed to add address transaction: %w", err)
+	}
+
+	blockTxs = append(blockTxs, &blockTransaction{Address: address, Hash: txn.Hash, BalanceChanges: balanceChanges})
+	if err := encodeAndSave(tx, blockTransactionsKey(txn.BlockNumber), blockTxs); err != nil {
+		return false, fmt.Errorf("failed to add address transaction: %w", err)
+	}
+
+	if err := applyBalanceChanges(tx, address, balanceChanges, false); err != nil {
+		return false, fmt.Errorf("failed to add address transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+// RollbackBlock removes the transactions of a block dropped by a chain reorganization
+// from the subscribed addresses and the database, and moves the checkpoint back to the parent within one transaction.
+func (s *KVStorage) RollbackBlock(blockNumber *big.Int, parent *m.Checkpoint) ([]*m.AddressTransaction, error) {
+	var removed []*m.AddressTransaction
+	err := s.update(func(tx Tx) error {
+		blockTxs, err := getBlockTransactions(tx, blockNumber)
+		if err != nil {
+			return err
+		}
+
+		removed = make([]*m.AddressTransaction, 0, len(blockTxs))
+		txns := make(map[string]*m.Transaction)
+		for _, blockTx := range blockTxs {
+			txn, ok := txns[blockTx.Hash]
+			if !ok {
+				if txn, err = getTransaction(tx, blockTx.Hash); err != nil {
+					return err
+				}
+				txns[blockTx.Hash] = txn
+			}
+
+			if err := applyBalanceChanges(tx, blockTx.Address, blockTx.BalanceChanges, true); err != nil {
+				return err
+			}
+
+			removeEntry := func(*indexEntry) bool { return false }
+			if err := updateIndexEntry(tx, blockTx.Address, blockNumber, blockTx.Hash, removeEntry); err != nil {
+				return err
+			}
+
+			removed = append(removed, &m.AddressTransaction{Address: blockTx.Address, Transaction: txn, BalanceChanges: blockTx.BalanceChanges})
+		}
+
+		for hash := range txns {
+			if err := tx.Delete(hash); err != nil {
+				return err
+			}
+		}
+
+		if err := addDeliveries(tx, m.EventTransactionReorged, removed); err != nil {
+			return err
+		}
+
+		if err := tx.Delete(blockTransactionsKey(blockNumber)); err != nil {
+			return err
+		}
+
+		return encodeAndSave(tx, Checkpoint, parent)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to roll back block %s: %w", blockNumber, err)
+	}
+
+	return removed, nil
+}
+
+// UpdateBlockTransactionsState moves the transactions of a block forward to the confirmation state.
+func (s *KVStorage) UpdateBlockTransactionsState(blockNumber *big.Int, state m.TransactionState) ([]*m.AddressTransaction, error) {
+	var updated []*m.AddressTransaction
+	err := s.update(func(tx Tx) error {
+		blockTxs, err := getBlockTransactions(tx, blockNumber)
+		if err != nil {
+			return err
+		}
+
+		updated = []*m.AddressTransaction{}
+		txns := make(map[string]*m.Transaction)
+		for _, blockTx := range blockTxs {
+			// The transaction is shared by the subscribed addresses of the same block,
+			// it is updated once and reported for every address.
+			txn, ok := txns[blockTx.Hash]
+			if !ok {
+				if txn, err = getTransaction(tx, blockTx.Hash); err != nil {
+					return err
+				}
+
+				if !txn.State.Before(state) {
+					txns[blockTx.Hash] = nil
+					continue
+				}
+
+				txn.State = state
+				if err := encodeAndSave(tx, txn.Hash, txn); err != nil {
+					return err
+				}
+				txns[blockTx.Hash] = txn
+			}
+
+			if txn != nil {
+				updateState := func(entry *indexEntry) bool {
+					entry.State = state
+					return true
+				}
+				if err := updateIndexEntry(tx, blockTx.Address, blockNumber, blockTx.Hash, updateState); err != nil {
+					return err
+				}
+				updated = append(updated, &m.AddressTransaction{Address: blockTx.Address, Transaction: txn})
+			}
+		}
+
+		return addDeliveries(tx, m.StateEventType(state), updated)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update block transactions state: %w", err)
+	}
+
+	return updated, nil
+}
+
+func (s *KVStorage) GetTransactionsByAddress(address string) ([]*m.Transaction, error) {
+	if _, err := getSubscription(s.db, address); err != nil {
+		return nil, fmt.Errorf("subscribed address does not exist: %s: %w", address, err)
+	}
+
+	// Get the transactions of the address index, in the order of the blocks.
+	entries, err := getIndexEntries(s.db, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transactions by address: %w", err)
+	}
+
+	var txns []*m.Transaction
+	for _, entry := range entries {
+		txBytes, err := s.db.Get(entry.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get transaction by hash: %w", err)
+		}
+
+		var txn m.Transaction
+		if err := json.Unmarshal(txBytes, &txn); err != nil {
+			fmt.Printf("failed to load address transaction: %s\n", string(txBytes))
+			return nil, fmt.Errorf("failed to load address transaction: %w", err)
+		}
+
+		txns = append(txns, &txn)
+	}
+
+	return txns, nil
+}
+
+func (s *KVStorage) GetTransaction(hash string) (*m.Transaction, error) {
+	txn, err := getTransaction(s.db, hash)
+	if errors.Is(err, ErrNotFound) {
+		return nil, storage.ErrTransactionNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %w", err)
+	}
+
+	return txn, nil
+}
+
+func (s *KVStorage) IsSubscribedAddress(address string) bool {
+	subscription, err := getSubscription(s.db, address)
+	return err == nil && subscription.State == m.SubscriptionStateActive
+}
+
+// getSubscription returns the subscription of the address, storage.ErrSubscriptionNotFound if none.
+func getSubscription(r reader, address string) (*m.Subscription, error) {
+	subscriptionBytes, err := r.Get(subscriptionKey(address))
+	if errors.Is(err, ErrNotFound) {
+		// subscribed before the subscriptions were saved, always active
+		if _, err := r.Get(address); errors.Is(err, ErrNotFound) {
+			return nil, storage.ErrSubscriptionNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		return &m.Subscription{Address: address, State: m.SubscriptionStateActive}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var subscription m.Subscription
+	if err := json.Unmarshal(subscriptionBytes, &subscription); err != nil {
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	return &subscription, nil
+}
+
+func subscriptionKey(address string) string {
+	return SubscriptionsPrefix + address
+}
+
+// getTransaction loads a saved transaction by its hash.
+func getTransaction(r reader, hash string) (*m.Transaction, error) {
+	txBytes, err := r.Get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction by hash: %w", err)
+	}
+
+	var txn m.Transaction
+	if err := json.Unmarshal(txBytes, &txn); err != nil {
+		return nil, fmt.Errorf("failed to load transaction: %w", err)
+	}
+
+	return &txn, nil
+}
+
+// getAddressTxHashes returns the list of tx hash of a subscribed address, saved before the address indexes.
+func getAddressTxHashes(r reader, address string) ([]string, error) {
+	addressTxHashesBytes, err := r.Get(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var addressTxHashes []string
+	if err := json.Unmarshal(addressTxHashesBytes, &addressTxHashes); err != nil {
+		return nil, fmt.Errorf("failed to get address tx hash list: %w", err)
+	}
+
+	return addressTxHashes, nil
+}
+
+// getBlockTransactions returns the subscribed address transactions saved for a block.
+// A block without any subscribed address transaction has an empty list.
+func getBlockTransactions(r reader, blockNumber *big.Int) ([]*blockTransaction, error) {
+	blockTxsBytes, err := r.Get(blockTransactionsKey(blockNumber))
+	if errors.Is(err, ErrNotFound) {
+		return []*blockTransaction{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var blockTxs []*blockTransaction
+	if err := json.Unmarshal(blockTxsBytes, &blockTxs); err != nil {
+		return nil, fmt.Errorf("failed to get block transaction list: %w", err)
+	}
+
+	return blockTxs, nil
+}
+
+func blockTransactionsKey(blockNumber *big.Int) string {
+	return BlockTransactionsPrefix + blockNumber.String()
+}
+
+// getBalances returns the balances of the address by coin.
+// An address without any balance yet has an empty map.
+func getBalances(r reader, address string) (map[int64]*m.Balance, error) {
+	balancesBytes, err := r.Get(balancesKey(address))
+	if errors.Is(err, ErrNotFound) {
+		return make(map[int64]*m.Balance), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var balances map[int64]*m.Balance
+	if err := json.Unmarshal(balancesBytes, &balances); err != nil {
+		return nil, fmt.Errorf("failed to get balances: %w", err)
+	}
+
+	return balances, nil
+}
+
+// applyBalanceChanges adds the changes to the balances of the address, or subtracts them to revert them.
+func applyBalanceChanges(tx Tx, address string, changes map[int64]*big.Int, revert bool) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	balances, err := getBalances(tx, address)
+	if err != nil {
+		return err
+	}
+
+	for coinID, change := range changes {
+		balance, ok := balances[coinID]
+		if !ok {
+			balance = &m.Balance{CoinID: coinID, Balance: big.NewInt(0)}
+			balances[coinID] = balance
+		}
+
+		if revert {
+			balance.Balance.Sub(balance.Balance, change)
+		} else {
+			balance.Balance.Add(balance.Balance, change)
+		}
+	}
+
+	return encodeAndSave(tx, balancesKey(address), balances)
+}
+
+// unseedBalances marks the balances of the address to be seeded again.
+func unseedBalances(tx Tx, address string) error {
+	balances, err := getBalances(tx, address)
+	if err != nil || len(balances) == 0 {
+		return err
+	}
+
+	for _, balance := range balances {
+		balance.Seeded = false
+	}
+
+	return encodeAndSave(tx, balancesKey(address), balances)
+}
+
+func balancesKey(address string) string {
+	return BalancesPrefix + address
+}
+
+// encodeAndSave marshal any value data type and saves it within the transaction as bytes.
+func encodeAndSave(tx Tx, key string, value interface{}) error {
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to save value: %w", err)
+	}
+
+	if err := tx.Set(key, valueBytes); err != nil {
+		return fmt.Errorf("failed to save value: %w", err)
+	}
+
+	return nil
+}
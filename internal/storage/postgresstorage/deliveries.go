@@ -0,0 +1,208 @@
+package postgresstorage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/storage"
+)
+
+// deliveryColumns are the columns of the deliveries scanned by scanDeliveries.
+const deliveryColumns = `id, url, event, state, failures, created_at, next_attempt_at`
+
+func (s *PostgresStorage) GetDueDeliveries(now time.Time, limit int) ([]*m.Delivery, error) {
+	deliveries, err := scanDeliveries(s.db, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE chain_id = $1 AND state = $2 AND next_attempt_at <= $3
+		ORDER BY next_attempt_at, id
+		LIMIT $4`, s.chainID, string(m.DeliveryStatePending), now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *PostgresStorage) GetDeliveries(address string, state m.DeliveryState) ([]*m.Delivery, error) {
+	deliveries, err := scanDeliveries(s.db, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE chain_id = $1 AND ($2 = '' OR address = $2) AND ($3 = '' OR state = $3)
+		ORDER BY id`, s.chainID, address, string(state))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+
+	if err := s.loadAttempts(deliveries); err != nil {
+		return nil, fmt.Errorf("failed to get deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *PostgresStorage) GetDelivery(id int64) (*m.Delivery, error) {
+	deliveries, err := scanDeliveries(s.db, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE chain_id = $1 AND id = $2`, s.chainID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery: %w", err)
+	}
+	if len(deliveries) == 0 {
+		return nil, storage.ErrDeliveryNotFound
+	}
+
+	if err := s.loadAttempts(deliveries); err != nil {
+		return nil, fmt.Errorf("failed to get delivery: %w", err)
+	}
+
+	return deliveries[0], nil
+}
+
+func (s *PostgresStorage) UpdateDelivery(delivery *m.Delivery, attempt *m.DeliveryAttempt) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`UPDATE webhook_deliveries SET url = $3, state = $4, failures = $5, next_attempt_at = $6
+			WHERE chain_id = $1 AND id = $2`,
+			s.chainID, delivery.ID, delivery.URL, string(delivery.State), delivery.Failures, nullTime(delivery.NextAttemptAt))
+		if err != nil {
+			return err
+		}
+		if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+			return storage.ErrDeliveryNotFound
+		}
+
+		if attempt == nil {
+			return nil
+		}
+
+		_, err = tx.Exec(`INSERT INTO webhook_delivery_attempts (delivery_id, attempted_at, status_code, error, duration)
+			VALUES ($1, $2, $3, $4, $5)`,
+			delivery.ID, attempt.Time, attempt.StatusCode, attempt.Error, int64(attempt.Duration))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update delivery %d: %w", delivery.ID, err)
+	}
+
+	return nil
+}
+
+// PruneDeliveries deletes the delivered deliveries older than the keep last ones, their attempts along.
+func (s *PostgresStorage) PruneDeliveries(keep int) error {
+	if _, err := s.db.Exec(`DELETE FROM webhook_deliveries
+		WHERE chain_id = $1 AND state = $2 AND id NOT IN (
+			SELECT id FROM webhook_deliveries WHERE chain_id = $1 AND state = $2 ORDER BY id DESC LIMIT $3
+		)`, s.chainID, string(m.DeliveryStateDelivered), keep); err != nil {
+		return fmt.Errorf("failed to prune deliveries: %w", err)
+	}
+
+	return nil
+}
+
+// insertDeliveries inserts the pending deliveries of the events of the address transactions in one statement,
+// for the addresses whose webhook matches the type of the event.
+// The transaction of the event is saved as it is at the time of the event.
+func (s *PostgresStorage) insertDeliveries(q queryer, eventType m.EventType, txs []*m.AddressTransaction) error {
+	if len(txs) == 0 {
+		return nil
+	}
+
+	addresses := make([]string, 0, len(txs))
+	events := make([]string, 0, len(txs))
+	for _, addressTx := range txs {
+		eventBytes, err := json.Marshal(&m.Event{Type: eventType, Address: addressTx.Address, Transaction: addressTx.Transaction})
+		if err != nil {
+			return fmt.Errorf("failed to marshal event of %s: %w", addressTx.Transaction.Hash, err)
+		}
+		addresses = append(addresses, addressTx.Address)
+		events = append(events, string(eventBytes))
+	}
+
+	// a webhook without events gets all of them
+	if _, err := q.Exec(`INSERT INTO webhook_deliveries
+		(chain_id, address, event_type, event, url, state, created_at, next_attempt_at)
+		SELECT $1, u.address, $2, u.event, a.webhook->>'url', $3, $4, $4
+		FROM unnest($5::TEXT[], $6::JSONB[]) WITH ORDINALITY AS u (address, event, position)
+		JOIN addresses a ON a.chain_id = $1 AND a.address = u.address
+		WHERE a.webhook IS NOT NULL
+			AND (COALESCE(jsonb_array_length(a.webhook->'events'), 0) = 0 OR a.webhook->'events' ? $2)
+		ORDER BY u.position`,
+		s.chainID, string(eventType), string(m.DeliveryStatePending), time.Now(),
+		pq.Array(addresses), pq.Array(events)); err != nil {
+		return fmt.Errorf("failed to insert deliveries: %w", err)
+	}
+
+	return nil
+}
+
+// loadAttempts loads the attempts of the deliveries in the order they were made.
+func (s *PostgresStorage) loadAttempts(deliveries []*m.Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*m.Delivery, len(deliveries))
+	ids := make([]int64, 0, len(deliveries))
+	for _, d := range deliveries {
+		byID[d.ID] = d
+		ids = append(ids, d.ID)
+	}
+
+	rows, err := s.db.Query(`SELECT delivery_id, attempted_at, status_code, error, duration FROM webhook_delivery_attempts
+		WHERE delivery_id = ANY($1)
+		ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var deliveryID, duration int64
+		var attempt m.DeliveryAttempt
+		if err := rows.Scan(&deliveryID, &attempt.Time, &attempt.StatusCode, &attempt.Error, &duration); err != nil {
+			return err
+		}
+		attempt.Duration = time.Duration(duration)
+
+		d := byID[deliveryID]
+		d.Attempts = append(d.Attempts, &attempt)
+	}
+
+	return rows.Err()
+}
+
+// scanDeliveries runs the query of the delivery columns, the deliveries are returned without their attempts.
+func scanDeliveries(q queryer, query string, args ...interface{}) ([]*m.Delivery, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*m.Delivery{}
+	for rows.Next() {
+		var d m.Delivery
+		var state string
+		var eventBytes []byte
+		var nextAttemptAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.URL, &eventBytes, &state, &d.Failures, &d.CreatedAt, &nextAttemptAt); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(eventBytes, &d.Event); err != nil {
+			return nil, fmt.Errorf("failed to load event: %w", err)
+		}
+		d.State = m.DeliveryState(state)
+		d.NextAttemptAt = nextAttemptAt.Time
+		d.Attempts = []*m.DeliveryAttempt{}
+
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// nullTime is NULL for the zero time, e.g: the next attempt of a delivery done.
+func nullTime(value time.Time) sql.NullTime {
+	return sql.NullTime{Time: value, Valid: !value.IsZero()}
+}
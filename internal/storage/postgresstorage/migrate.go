@@ -0,0 +1,108 @@
+package postgresstorage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationLockID is the key of the advisory lock serializing the migrations of the indexers sharing the database.
+const migrationLockID = 7263512019
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is a schema change, named <version>_<description>.sql and applied in the order of the versions.
+// A migration is never changed once released, the schema is changed by a new migration.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations() ([]*migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	migrations := make([]*migration, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid migration name %s, expected <version>_<description>.sql", name)
+		}
+
+		sqlBytes, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", name, err)
+		}
+
+		migrations = append(migrations, &migration{version: version, name: name, sql: string(sqlBytes)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	return migrations, nil
+}
+
+// migrate applies the migrations not applied yet, all or none of them.
+// Returns the version of the schema.
+func migrate(db *sql.DB) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin migration: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return 0, fmt.Errorf("failed to lock migrations: %w", err)
+	}
+
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INT         NOT NULL PRIMARY KEY,
+		name       TEXT        NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return 0, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	for _, migration := range migrations {
+		if migration.version <= version {
+			continue
+		}
+
+		if _, err := tx.Exec(migration.sql); err != nil {
+			return 0, fmt.Errorf("failed to apply migration %s: %w", migration.name, err)
+		}
+
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.version, migration.name); err != nil {
+			return 0, fmt.Errorf("failed to apply migration %s: %w", migration.name, err)
+		}
+
+		fmt.Printf("applied migration %s\n", migration.name)
+		version = migration.version
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit migrations: %w", err)
+	}
+
+	return version, nil
+}
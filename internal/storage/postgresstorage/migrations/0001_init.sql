@@ -0,0 +1,77 @@
+-- Subscribed addresses, per chain.
+CREATE TABLE addresses (
+    chain_id      BIGINT      NOT NULL,
+    address       TEXT        NOT NULL,
+    subscribed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (chain_id, address)
+);
+
+-- Balances of the subscribed addresses per coin, the native coin and the tokens.
+CREATE TABLE balances (
+    chain_id BIGINT         NOT NULL,
+    address  TEXT           NOT NULL,
+    coin_id  BIGINT         NOT NULL,
+    balance  NUMERIC(78, 0) NOT NULL DEFAULT 0,
+    PRIMARY KEY (chain_id, address, coin_id),
+    FOREIGN KEY (chain_id, address) REFERENCES addresses (chain_id, address) ON DELETE CASCADE
+);
+
+-- Transactions of the subscribed addresses, saved once when shared by several addresses.
+-- data is the whole transaction as returned by the storage, the columns are the queried fields.
+CREATE TABLE transactions (
+    chain_id          BIGINT         NOT NULL,
+    hash              TEXT           NOT NULL,
+    block_number      BIGINT         NOT NULL,
+    block_hash        TEXT           NOT NULL,
+    transaction_index BIGINT,
+    from_address      TEXT           NOT NULL,
+    to_address        TEXT           NOT NULL,
+    value             NUMERIC(78, 0) NOT NULL DEFAULT 0,
+    state             TEXT           NOT NULL,
+    data              JSONB          NOT NULL,
+    PRIMARY KEY (chain_id, hash)
+);
+
+CREATE INDEX transactions_block_number_idx ON transactions (chain_id, block_number);
+
+-- Value transfers of the transactions: native, token and internal transfers.
+CREATE TABLE transfers (
+    chain_id     BIGINT         NOT NULL,
+    tx_hash      TEXT           NOT NULL,
+    position     INT            NOT NULL,
+    coin_id      BIGINT         NOT NULL,
+    ticker       TEXT           NOT NULL,
+    from_address TEXT           NOT NULL,
+    to_address   TEXT           NOT NULL,
+    value        NUMERIC(78, 0) NOT NULL,
+    token        TEXT,
+    log_index    BIGINT,
+    internal     BOOLEAN        NOT NULL DEFAULT false,
+    PRIMARY KEY (chain_id, tx_hash, position),
+    FOREIGN KEY (chain_id, tx_hash) REFERENCES transactions (chain_id, hash) ON DELETE CASCADE
+);
+
+CREATE INDEX transfers_from_address_idx ON transfers (chain_id, from_address);
+CREATE INDEX transfers_to_address_idx ON transfers (chain_id, to_address);
+
+-- Transactions of each subscribed address, block_number is copied from the transaction
+-- for the block range queries of an address.
+CREATE TABLE address_transactions (
+    chain_id     BIGINT NOT NULL,
+    address      TEXT   NOT NULL,
+    tx_hash      TEXT   NOT NULL,
+    block_number BIGINT NOT NULL,
+    PRIMARY KEY (chain_id, address, tx_hash),
+    FOREIGN KEY (chain_id, address) REFERENCES addresses (chain_id, address) ON DELETE CASCADE,
+    FOREIGN KEY (chain_id, tx_hash) REFERENCES transactions (chain_id, hash) ON DELETE CASCADE
+);
+
+CREATE INDEX address_transactions_address_block_idx ON address_transactions (chain_id, address, block_number);
+CREATE INDEX address_transactions_block_idx ON address_transactions (chain_id, block_number);
+
+-- Last block fully processed by the indexer, per chain.
+CREATE TABLE checkpoints (
+    chain_id     BIGINT NOT NULL PRIMARY KEY,
+    block_number BIGINT NOT NULL,
+    block_hash   TEXT   NOT NULL
+);
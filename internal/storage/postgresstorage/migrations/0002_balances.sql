@@ -0,0 +1,5 @@
+-- Balances are seeded once with the balance of the address before the indexed transactions.
+ALTER TABLE balances ADD COLUMN seeded BOOLEAN NOT NULL DEFAULT false;
+
+-- Balance changes of the transaction for the address by coin id, reverted when the transaction is removed.
+ALTER TABLE address_transactions ADD COLUMN balance_changes JSONB NOT NULL DEFAULT '{}';
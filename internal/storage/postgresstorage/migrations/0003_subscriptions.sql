@@ -0,0 +1,4 @@
+-- Subscriptions are paused or unsubscribed with their history kept, see models.SubscriptionState.
+-- last_block is the last block indexed while the address was active.
+ALTER TABLE addresses ADD COLUMN state TEXT NOT NULL DEFAULT 'active';
+ALTER TABLE addresses ADD COLUMN last_block BIGINT;
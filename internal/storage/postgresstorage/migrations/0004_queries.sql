@@ -0,0 +1,13 @@
+-- Time of the block of the transaction in unix seconds, for the time range queries.
+ALTER TABLE transactions ADD COLUMN block_timestamp BIGINT;
+
+-- Position of the transaction in its block copied from the transaction, -1 when unknown,
+-- the address transactions are paged by block, position and hash.
+ALTER TABLE address_transactions ADD COLUMN transaction_index BIGINT NOT NULL DEFAULT -1;
+
+UPDATE address_transactions at SET transaction_index = t.transaction_index
+FROM transactions t
+WHERE t.chain_id = at.chain_id AND t.hash = at.tx_hash AND t.transaction_index IS NOT NULL;
+
+CREATE INDEX address_transactions_address_position_idx
+    ON address_transactions (chain_id, address, block_number, transaction_index, tx_hash);
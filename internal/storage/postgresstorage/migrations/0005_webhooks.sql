@@ -0,0 +1,2 @@
+-- Webhook the events of the address are delivered to, see models.Webhook.
+ALTER TABLE addresses ADD COLUMN webhook JSONB;
@@ -0,0 +1,32 @@
+-- Outbox of the events delivered to the webhooks, see models.Delivery. A pending delivery is inserted
+-- within the change of the transaction of its event, so the event is delivered after a restart as well.
+-- url is the url of the webhook when the delivery was created, then of its last attempt.
+CREATE TABLE webhook_deliveries (
+    id              BIGSERIAL   PRIMARY KEY,
+    chain_id        BIGINT      NOT NULL,
+    address         TEXT        NOT NULL,
+    event_type      TEXT        NOT NULL,
+    event           JSONB       NOT NULL,
+    url             TEXT        NOT NULL,
+    state           TEXT        NOT NULL,
+    failures        INT         NOT NULL DEFAULT 0,
+    created_at      TIMESTAMPTZ NOT NULL,
+    next_attempt_at TIMESTAMPTZ,
+    FOREIGN KEY (chain_id, address) REFERENCES addresses (chain_id, address) ON DELETE CASCADE
+);
+
+-- the pending deliveries are polled by the time of their next attempt
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (chain_id, next_attempt_at) WHERE state = 'pending';
+CREATE INDEX webhook_deliveries_address_idx ON webhook_deliveries (chain_id, address, id);
+
+-- Attempts of the deliveries in the order they were made, duration in nanoseconds.
+CREATE TABLE webhook_delivery_attempts (
+    id           BIGSERIAL   PRIMARY KEY,
+    delivery_id  BIGINT      NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+    attempted_at TIMESTAMPTZ NOT NULL,
+    status_code  INT         NOT NULL DEFAULT 0,
+    error        TEXT        NOT NULL DEFAULT '',
+    duration     BIGINT      NOT NULL
+);
+
+CREATE INDEX webhook_delivery_attempts_delivery_idx ON webhook_delivery_attempts (delivery_id, id);
@@ -1,18 +1,118 @@
 package storage
 
 import (
+	"errors"
 	"math/big"
+	"time"
 
 	m "github.com/hoangan/superwallet/internal/models"
 )
 
+var (
+	// ErrCheckpointNotFound is returned when the indexer has not processed any block yet.
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+	// ErrSubscriptionNotFound is returned for an address never subscribed, or unsubscribed and purged.
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+
+	// ErrTransactionNotFound is returned for a transaction not saved for any address.
+	ErrTransactionNotFound = errors.New("transaction not found")
+
+	// ErrDeliveryNotFound is returned for a webhook delivery never created, or pruned from the history.
+	ErrDeliveryNotFound = errors.New("delivery not found")
+)
+
 // Storage interface is the interface that wraps the basic methods for a storage.
 type Storage interface {
+	// SubscribeAddress subscribes the address, or makes the subscription of a paused or unsubscribed address active again.
+	// The balances of an address subscribed again are seeded again, see SeedBalance.
 	SubscribeAddress(address string) error
+
+	// GetSubscription returns the subscription of the address, ErrSubscriptionNotFound if none.
+	GetSubscription(address string) (*m.Subscription, error)
+
+	// SetWebhook sets the webhook the events of the subscribed address are delivered to, removed when nil.
+	// The webhook is kept while the address is paused, unsubscribed and subscribed again, deleted when purged.
+	SetWebhook(address string, webhook *m.Webhook) error
+
+	// PauseAddress stops recording the transactions of the subscribed address, its history is kept.
+	// lastBlockNumber is the last block indexed while it was active.
+	PauseAddress(address string, lastBlockNumber *big.Int) error
+
+	// UnsubscribeAddress stops watching the address. Its history is purged when purge is set, its webhook deliveries
+	// included, kept along with its balances otherwise. lastBlockNumber is the last block indexed while it was active.
+	UnsubscribeAddress(address string, lastBlockNumber *big.Int, purge bool) error
+
+	// GetTransactionsByAddress returns the transactions of the address, active, paused or unsubscribed
+	// with its history kept.
 	GetTransactionsByAddress(address string) ([]*m.Transaction, error)
 	AddAddressTransaction(address string, tx *m.Transaction) error
+
+	// GetTransaction returns the transaction of the hash saved for any address, ErrTransactionNotFound if none.
+	GetTransaction(hash string) (*m.Transaction, error)
+
+	// QueryTransactions returns a page of the transactions of the address matching the query,
+	// in the order of the query, along with the cursor of the next page.
+	// Same addresses as GetTransactionsByAddress, ErrSubscriptionNotFound for the others.
+	QueryTransactions(query *m.TransactionQuery) (*m.TransactionPage, error)
+
+	// GetAddressesWithBalances returns the subscribed addresses, active or paused,
+	// along with their balance of the native coin.
 	GetAddressesWithBalances() (map[string]*big.Int, error)
-	SaveIndexedBlockNumber(indexedBlockNumber *big.Int) error
-	GetIndexedBlockNumber() (*big.Int, error)
+
+	// GetBalances returns the balances of the address by coin, of the coins it ever held or was seeded with.
+	GetBalances(address string) ([]*m.Balance, error)
+
+	// SeedBalance adds the amount held before the subscription to the balance of the address in the coin,
+	// and marks the balance as seeded. Does nothing once the balance is seeded.
+	SeedBalance(address string, coinID int64, amount *big.Int) error
+
+	// CommitBlock saves the subscribed address transactions of a block along with the checkpoint of the block,
+	// the checkpoint is only moved forward once all transactions of the block are saved.
+	// The balance changes of the address transactions are applied once, when the transaction is first saved.
+	// The deliveries of the transaction.indexed events are created along, see GetDueDeliveries.
+	CommitBlock(checkpoint *m.Checkpoint, txs []*m.AddressTransaction) error
+
+	// SaveCheckpoint moves the checkpoint without saving any transaction.
+	SaveCheckpoint(checkpoint *m.Checkpoint) error
+
+	// GetCheckpoint returns the last fully processed block, ErrCheckpointNotFound if none.
+	GetCheckpoint() (*m.Checkpoint, error)
+
+	// IsSubscribedAddress reports whether the transactions of the address are recorded, its subscription is active.
 	IsSubscribedAddress(address string) bool
+
+	// RollbackBlock removes all saved transactions of the given block dropped by a chain reorganization,
+	// and moves the checkpoint back to the parent of the block, within one transaction.
+	// Returns the removed transactions along with the subscribed address they were saved for,
+	// their balance changes are reverted. The deliveries of the transaction.reorged events are created along.
+	RollbackBlock(blockNumber *big.Int, parent *m.Checkpoint) ([]*m.AddressTransaction, error)
+
+	// UpdateBlockTransactionsState moves the saved transactions of the given block forward to the state.
+	// Transactions already at or past the state are left untouched.
+	// Returns the updated transactions along with the subscribed address they were saved for.
+	// The deliveries of the events of the state are created along, see models.StateEventType.
+	UpdateBlockTransactionsState(blockNumber *big.Int, state m.TransactionState) ([]*m.AddressTransaction, error)
+
+	// GetDueDeliveries returns up to limit pending webhook deliveries due at the time, the most overdue first,
+	// without their attempts. The deliveries are the outbox of the events: a pending delivery is created
+	// within the change of the transaction of the event, for the addresses whose webhook matches the event.
+	GetDueDeliveries(now time.Time, limit int) ([]*m.Delivery, error)
+
+	// GetDeliveries returns the webhook deliveries of the address, of all the addresses when empty,
+	// in the state when given, in the order they were created, along with their attempts.
+	GetDeliveries(address string, state m.DeliveryState) ([]*m.Delivery, error)
+
+	// GetDelivery returns the webhook delivery along with its attempts, ErrDeliveryNotFound if none.
+	GetDelivery(id int64) (*m.Delivery, error)
+
+	// UpdateDelivery saves the url, state, failures and next attempt of the delivery,
+	// along with the attempt made when not nil. ErrDeliveryNotFound if the delivery is not saved.
+	UpdateDelivery(delivery *m.Delivery, attempt *m.DeliveryAttempt) error
+
+	// PruneDeliveries deletes the delivered deliveries but the keep last ones, the others are kept.
+	PruneDeliveries(keep int) error
+
+	// Close releases the storage, e.g: flushes and closes its files.
+	Close() error
 }
@@ -0,0 +1,705 @@
+// Package storagetest is the behavioural test suite of the storage.Storage implementations.
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	m "github.com/hoangan/superwallet/internal/models"
+	"github.com/hoangan/superwallet/internal/storage"
+)
+
+// NewStorage creates an empty storage for a test, closed by the suite.
+type NewStorage func(t *testing.T) storage.Storage
+
+const (
+	address1 = "0x29182006a4967e9a50c0a66076da514993d3b4d4"
+	address2 = "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97"
+)
+
+// NewTransaction returns a pending transaction of the block.
+func NewTransaction(hash string, blockNumber int64) *m.Transaction {
+	return &m.Transaction{
+		Hash:        hash,
+		BlockHash:   "0xb" + big.NewInt(blockNumber).String(),
+		BlockNumber: big.NewInt(blockNumber),
+		From:        address1,
+		To:          address2,
+		Value:       big.NewInt(1000),
+		State:       m.TransactionStatePending,
+	}
+}
+
+// Run runs the suite against the storages created by newStorage.
+func Run(t *testing.T, newStorage NewStorage) {
+	open := func(t *testing.T) storage.Storage {
+		store := newStorage(t)
+		t.Cleanup(func() { _ = store.Close() })
+		return store
+	}
+
+	t.Run("Subscribe Address", func(t *testing.T) {
+		store := open(t)
+
+		if store.IsSubscribedAddress(address1) {
+			t.Errorf("failed to report the address is not subscribed")
+		}
+
+		for j := 0; j < 2; j++ {
+			if err := store.SubscribeAddress(address1); err != nil {
+				t.Errorf("failed to subscribe address: %v", err)
+			}
+		}
+
+		if !store.IsSubscribedAddress(address1) {
+			t.Errorf("failed to subscribe address")
+		}
+
+		addresses, err := store.GetAddressesWithBalances()
+		if err != nil {
+			t.Fatalf("failed to get addresses: %v", err)
+		}
+		if len(addresses) != 1 || addresses[address1] == nil || addresses[address1].Sign() != 0 {
+			t.Errorf("failed to get addresses with zero balance, got %v", addresses)
+		}
+
+		if transactions, err := store.GetTransactionsByAddress(address1); err != nil || len(transactions) != 0 {
+			t.Errorf("failed to get no transactions of new address: %v, %v", transactions, err)
+		}
+	})
+
+	t.Run("Unknown Address", func(t *testing.T) {
+		store := open(t)
+
+		if _, err := store.GetTransactionsByAddress(address1); err == nil {
+			t.Errorf("failed to report the address is not subscribed")
+		}
+		if err := store.AddAddressTransaction(address1, NewTransaction("0xt1", 1)); err == nil {
+			t.Errorf("failed to reject the transaction of an address not subscribed")
+		}
+	})
+
+	t.Run("Add Address Transaction", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1, address2)
+
+		// saved once per address, shared by both addresses
+		txn := NewTransaction("0xt1", 1)
+		for j := 0; j < 2; j++ {
+			for _, address := range []string{address1, address2} {
+				if err := store.AddAddressTransaction(address, txn); err != nil {
+					t.Fatalf("failed to add address transaction: %v", err)
+				}
+			}
+		}
+
+		for _, address := range []string{address1, address2} {
+			transactions, err := store.GetTransactionsByAddress(address)
+			if err != nil {
+				t.Fatalf("failed to get transactions by address: %v", err)
+			}
+			if len(transactions) != 1 || transactions[0].Hash != txn.Hash || transactions[0].Value.Cmp(txn.Value) != 0 {
+				t.Errorf("failed to get the transaction of %s once, got %v", address, transactions)
+			}
+		}
+
+		if saved, err := store.GetTransaction(txn.Hash); err != nil || saved.Hash != txn.Hash || saved.State != txn.State {
+			t.Errorf("failed to get the transaction by hash: %v, %v", saved, err)
+		}
+		if _, err := store.GetTransaction("0xunknown"); !errors.Is(err, storage.ErrTransactionNotFound) {
+			t.Errorf("failed to report the transaction is not found: %v", err)
+		}
+	})
+
+	t.Run("Checkpoint", func(t *testing.T) {
+		store := open(t)
+
+		if _, err := store.GetCheckpoint(); !errors.Is(err, storage.ErrCheckpointNotFound) {
+			t.Errorf("failed to report missing checkpoint: %v", err)
+		}
+
+		for _, number := range []int64{10, 9} {
+			if err := store.SaveCheckpoint(&m.Checkpoint{BlockNumber: big.NewInt(number), BlockHash: "0xb"}); err != nil {
+				t.Fatalf("failed to save checkpoint: %v", err)
+			}
+		}
+
+		// moved back after a rollback
+		if checkpoint, err := store.GetCheckpoint(); err != nil || checkpoint.BlockNumber.Int64() != 9 {
+			t.Errorf("failed to get checkpoint: %v, %v", checkpoint, err)
+		}
+	})
+
+	t.Run("Commit Block", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1, address2)
+
+		txn := NewTransaction("0xt1", 5)
+		checkpoint := &m.Checkpoint{BlockNumber: big.NewInt(5), BlockHash: txn.BlockHash}
+		txs := []*m.AddressTransaction{{Address: address1, Transaction: txn}, {Address: address2, Transaction: txn}}
+
+		// committed again after a failure
+		for j := 0; j < 2; j++ {
+			if err := store.CommitBlock(checkpoint, txs); err != nil {
+				t.Fatalf("failed to commit block: %v", err)
+			}
+		}
+
+		saved, err := store.GetCheckpoint()
+		if err != nil || saved.BlockNumber.Cmp(checkpoint.BlockNumber) != 0 || saved.BlockHash != checkpoint.BlockHash {
+			t.Errorf("failed to get checkpoint: %v, %v", saved, err)
+		}
+
+		for _, address := range []string{address1, address2} {
+			if transactions, _ := store.GetTransactionsByAddress(address); len(transactions) != 1 {
+				t.Errorf("failed to commit block txs count of %s: %d", address, len(transactions))
+			}
+		}
+	})
+
+	t.Run("Commit Block Atomically", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1)
+		commit(t, store, 1)
+
+		// the second address is not subscribed, nothing of the block is saved
+		txn := NewTransaction("0xt1", 2)
+		checkpoint := &m.Checkpoint{BlockNumber: big.NewInt(2), BlockHash: txn.BlockHash}
+		txs := []*m.AddressTransaction{{Address: address1, Transaction: txn}, {Address: address2, Transaction: txn}}
+		if err := store.CommitBlock(checkpoint, txs); err == nil {
+			t.Fatalf("failed to reject the transaction of an address not subscribed")
+		}
+
+		if saved, err := store.GetCheckpoint(); err != nil || saved.BlockNumber.Int64() != 1 {
+			t.Errorf("failed to keep the previous checkpoint: %v, %v", saved, err)
+		}
+		if transactions, _ := store.GetTransactionsByAddress(address1); len(transactions) != 0 {
+			t.Errorf("failed to drop the transactions of the failed block, got %v", transactions)
+		}
+	})
+
+	t.Run("Concurrent Subscribe", func(t *testing.T) {
+		store := open(t)
+
+		var wg sync.WaitGroup
+		for j := 0; j < 20; j++ {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				if err := store.SubscribeAddress(fmt.Sprintf("0x%040d", j)); err != nil {
+					t.Errorf("failed to subscribe address: %v", err)
+				}
+			}(j)
+		}
+		wg.Wait()
+
+		if addresses, err := store.GetAddressesWithBalances(); err != nil || len(addresses) != 20 {
+			t.Errorf("failed to keep every subscribed address, got %d: %v", len(addresses), err)
+		}
+	})
+
+	t.Run("Rollback Block", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1, address2)
+
+		kept := NewTransaction("0xt1", 1)
+		commit(t, store, 1, &m.AddressTransaction{Address: address1, Transaction: kept})
+
+		reorged := NewTransaction("0xt2", 2)
+		commit(t, store, 2, &m.AddressTransaction{Address: address1, Transaction: reorged},
+			&m.AddressTransaction{Address: address2, Transaction: reorged})
+
+		removed, err := store.RollbackBlock(big.NewInt(2), parent(2))
+		if err != nil {
+			t.Fatalf("failed to roll back block: %v", err)
+		}
+		if len(removed) != 2 || removed[0].Transaction.Hash != reorged.Hash || removed[1].Transaction.Hash != reorged.Hash {
+			t.Errorf("failed to return the removed transactions, got %v", removed)
+		}
+		if checkpoint, err := store.GetCheckpoint(); err != nil || checkpoint.BlockNumber.Int64() != 1 || checkpoint.BlockHash != "0xb1" {
+			t.Errorf("failed to move the checkpoint back to the parent: %v, %v", checkpoint, err)
+		}
+
+		if transactions, _ := store.GetTransactionsByAddress(address1); len(transactions) != 1 || transactions[0].Hash != kept.Hash {
+			t.Errorf("failed to keep the transactions of the other blocks, got %v", transactions)
+		}
+		if transactions, _ := store.GetTransactionsByAddress(address2); len(transactions) != 0 {
+			t.Errorf("failed to remove block transactions, got %v", transactions)
+		}
+
+		if removed, err := store.RollbackBlock(big.NewInt(2), parent(2)); err != nil || len(removed) != 0 {
+			t.Errorf("failed to remove block transactions again: %v, %v", removed, err)
+		}
+		if _, err := store.GetTransaction(reorged.Hash); !errors.Is(err, storage.ErrTransactionNotFound) {
+			t.Errorf("failed to remove the transaction: %v", err)
+		}
+
+		// the block is indexed again on the new chain
+		commit(t, store, 2, &m.AddressTransaction{Address: address2, Transaction: reorged})
+		if transactions, _ := store.GetTransactionsByAddress(address2); len(transactions) != 1 {
+			t.Errorf("failed to commit the block again, got %v", transactions)
+		}
+	})
+
+	t.Run("Update Block Transactions State", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1, address2)
+
+		txn := NewTransaction("0xt1", 3)
+		commit(t, store, 3, &m.AddressTransaction{Address: address1, Transaction: txn},
+			&m.AddressTransaction{Address: address2, Transaction: txn})
+
+		updated, err := store.UpdateBlockTransactionsState(big.NewInt(3), m.TransactionStateFinalized)
+		if err != nil {
+			t.Fatalf("failed to update block transactions state: %v", err)
+		}
+		if len(updated) != 2 || updated[0].Transaction.State != m.TransactionStateFinalized {
+			t.Errorf("failed to update block transactions state, got %v", updated)
+		}
+
+		// never moved back
+		if updated, err := store.UpdateBlockTransactionsState(big.NewInt(3), m.TransactionStateConfirmed); err != nil || len(updated) != 0 {
+			t.Errorf("failed to leave the finalized transactions untouched: %v, %v", updated, err)
+		}
+
+		if transactions, _ := store.GetTransactionsByAddress(address2); len(transactions) != 1 || transactions[0].State != m.TransactionStateFinalized {
+			t.Errorf("failed to save the transaction state, got %v", transactions)
+		}
+	})
+
+	t.Run("Balances", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1, address2)
+
+		txn := NewTransaction("0xt1", 1)
+		txs := []*m.AddressTransaction{
+			{Address: address1, Transaction: txn, BalanceChanges: map[int64]*big.Int{m.NativeCoinID: big.NewInt(-1500)}},
+			{Address: address2, Transaction: txn, BalanceChanges: map[int64]*big.Int{m.NativeCoinID: big.NewInt(1000), 2: big.NewInt(5)}},
+		}
+
+		// applied once when committed again
+		for j := 0; j < 2; j++ {
+			commit(t, store, 1, txs...)
+		}
+		checkBalance(t, store, address1, m.NativeCoinID, -1500, false)
+		checkBalance(t, store, address2, m.NativeCoinID, 1000, false)
+		checkBalance(t, store, address2, 2, 5, false)
+
+		// seeded once
+		for j := 0; j < 2; j++ {
+			if err := store.SeedBalance(address2, m.NativeCoinID, big.NewInt(200)); err != nil {
+				t.Fatalf("failed to seed balance: %v", err)
+			}
+		}
+		checkBalance(t, store, address2, m.NativeCoinID, 1200, true)
+
+		if addresses, err := store.GetAddressesWithBalances(); err != nil || addresses[address2] == nil || addresses[address2].Int64() != 1200 {
+			t.Errorf("failed to get addresses with native balance, got %v: %v", addresses, err)
+		}
+
+		removed, err := store.RollbackBlock(big.NewInt(1), parent(1))
+		if err != nil {
+			t.Fatalf("failed to roll back block: %v", err)
+		}
+		for _, addressTx := range removed {
+			if addressTx.Address == address2 && (addressTx.BalanceChanges[2] == nil || addressTx.BalanceChanges[2].Int64() != 5) {
+				t.Errorf("failed to return the balance changes of the removed transaction, got %v", addressTx.BalanceChanges)
+			}
+		}
+
+		// reverted, the seed is kept
+		checkBalance(t, store, address1, m.NativeCoinID, 0, false)
+		checkBalance(t, store, address2, m.NativeCoinID, 200, true)
+		checkBalance(t, store, address2, 2, 0, false)
+
+		if err := store.SeedBalance("0xunknown", m.NativeCoinID, big.NewInt(1)); err == nil {
+			t.Errorf("failed to reject the balance of an address not subscribed")
+		}
+	})
+
+	t.Run("Webhook", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1)
+
+		webhook := &m.Webhook{URL: "https://example.com/hook", Secret: "secret", Events: []m.EventType{m.EventTransactionIndexed}}
+		if err := store.SetWebhook(address1, webhook); err != nil {
+			t.Fatalf("failed to set webhook: %v", err)
+		}
+
+		// kept while the address is unsubscribed and subscribed again
+		if err := store.UnsubscribeAddress(address1, big.NewInt(1), false); err != nil {
+			t.Fatalf("failed to unsubscribe address: %v", err)
+		}
+		subscribe(t, store, address1)
+		subscription, err := store.GetSubscription(address1)
+		if err != nil || subscription.Webhook == nil || subscription.Webhook.URL != webhook.URL ||
+			subscription.Webhook.Secret != webhook.Secret || len(subscription.Webhook.Events) != 1 {
+			t.Errorf("failed to get the webhook of the subscription: %+v, %v", subscription, err)
+		}
+
+		if err := store.SetWebhook(address1, nil); err != nil {
+			t.Fatalf("failed to remove webhook: %v", err)
+		}
+		if subscription, err := store.GetSubscription(address1); err != nil || subscription.Webhook != nil {
+			t.Errorf("failed to remove the webhook: %+v, %v", subscription, err)
+		}
+
+		if err := store.SetWebhook(address2, webhook); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+			t.Errorf("failed to reject the webhook of an address not subscribed: %v", err)
+		}
+	})
+
+	t.Run("Webhook Deliveries", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1, address2)
+
+		webhook := &m.Webhook{URL: "https://example.com/hook", Events: []m.EventType{m.EventTransactionIndexed, m.EventTransactionReorged}}
+		if err := store.SetWebhook(address1, webhook); err != nil {
+			t.Fatalf("failed to set webhook: %v", err)
+		}
+
+		// created once along with the block, for the address with a webhook only
+		txn := NewTransaction("0xt1", 1)
+		for j := 0; j < 2; j++ {
+			commit(t, store, 1, &m.AddressTransaction{Address: address1, Transaction: txn},
+				&m.AddressTransaction{Address: address2, Transaction: txn})
+		}
+		due, err := store.GetDueDeliveries(time.Now(), 10)
+		if err != nil || len(due) != 1 {
+			t.Fatalf("failed to create the delivery along with the block: %+v, %v", due, err)
+		}
+		delivery := due[0]
+		if delivery.State != m.DeliveryStatePending || delivery.URL != webhook.URL || delivery.Event.Type != m.EventTransactionIndexed ||
+			delivery.Event.Address != address1 || delivery.Event.Transaction.Hash != txn.Hash || delivery.Event.Transaction.State != m.TransactionStatePending {
+			t.Errorf("failed to create the delivery of the event: %+v", delivery)
+		}
+
+		// the confirmed event does not match the webhook, the reorged one does
+		if _, err := store.UpdateBlockTransactionsState(big.NewInt(1), m.TransactionStateConfirmed); err != nil {
+			t.Fatalf("failed to update block transactions state: %v", err)
+		}
+		if _, err := store.RollbackBlock(big.NewInt(1), parent(1)); err != nil {
+			t.Fatalf("failed to roll back block: %v", err)
+		}
+		deliveries, err := store.GetDeliveries(address1, "")
+		if err != nil || len(deliveries) != 2 || deliveries[1].Event.Type != m.EventTransactionReorged ||
+			deliveries[1].Event.Transaction.State != m.TransactionStateConfirmed {
+			t.Errorf("failed to create the deliveries of the matching events: %+v, %v", deliveries, err)
+		}
+
+		// retried later
+		retry := time.Now().Add(time.Hour)
+		delivery.Failures = 1
+		delivery.NextAttemptAt = retry
+		attempt := &m.DeliveryAttempt{Time: time.Now(), StatusCode: 500, Error: "unexpected status code 500", Duration: time.Millisecond}
+		if err := store.UpdateDelivery(delivery, attempt); err != nil {
+			t.Fatalf("failed to update delivery: %v", err)
+		}
+		if due, _ := store.GetDueDeliveries(time.Now(), 10); len(due) != 1 || due[0].ID == delivery.ID {
+			t.Errorf("failed to wait for the retry of the delivery, got %+v", due)
+		}
+		if due, _ := store.GetDueDeliveries(retry, 10); len(due) != 2 || due[1].ID != delivery.ID {
+			t.Errorf("failed to get the delivery due at its retry last, got %+v", due)
+		}
+
+		delivery.State = m.DeliveryStateDelivered
+		delivery.NextAttemptAt = time.Time{}
+		if err := store.UpdateDelivery(delivery, &m.DeliveryAttempt{Time: time.Now(), StatusCode: 200}); err != nil {
+			t.Fatalf("failed to update delivery: %v", err)
+		}
+		saved, err := store.GetDelivery(delivery.ID)
+		if err != nil || saved.State != m.DeliveryStateDelivered || saved.Failures != 1 || len(saved.Attempts) != 2 ||
+			saved.Attempts[0].StatusCode != 500 || saved.Attempts[0].Error != attempt.Error || saved.Attempts[0].Duration != attempt.Duration {
+			t.Errorf("failed to save the attempts of the delivery: %+v, %v", saved, err)
+		}
+		if delivered, _ := store.GetDeliveries("", m.DeliveryStateDelivered); len(delivered) != 1 {
+			t.Errorf("failed to get the deliveries in the state, got %+v", delivered)
+		}
+
+		// only the pending deliveries are due, a dead one is due again once redelivered
+		reorged := deliveries[1]
+		reorged.State = m.DeliveryStateDead
+		reorged.NextAttemptAt = time.Time{}
+		if err := store.UpdateDelivery(reorged, nil); err != nil {
+			t.Fatalf("failed to update delivery: %v", err)
+		}
+		if due, _ := store.GetDueDeliveries(retry, 10); len(due) != 0 {
+			t.Errorf("failed to leave out the delivered and dead deliveries, got %+v", due)
+		}
+		reorged.State = m.DeliveryStatePending
+		reorged.NextAttemptAt = time.Now()
+		if err := store.UpdateDelivery(reorged, nil); err != nil {
+			t.Fatalf("failed to update delivery: %v", err)
+		}
+		if due, _ := store.GetDueDeliveries(retry, 10); len(due) != 1 || due[0].ID != reorged.ID {
+			t.Errorf("failed to get the redelivered delivery due, got %+v", due)
+		}
+
+		if _, err := store.GetDelivery(1000); !errors.Is(err, storage.ErrDeliveryNotFound) {
+			t.Errorf("failed to report the delivery is not found: %v", err)
+		}
+		if err := store.UpdateDelivery(&m.Delivery{ID: 1000}, nil); !errors.Is(err, storage.ErrDeliveryNotFound) {
+			t.Errorf("failed to reject updating a delivery not saved: %v", err)
+		}
+
+		// the delivered ones are pruned, the pending one is kept
+		if err := store.PruneDeliveries(0); err != nil {
+			t.Fatalf("failed to prune deliveries: %v", err)
+		}
+		if deliveries, _ := store.GetDeliveries("", ""); len(deliveries) != 1 || deliveries[0].State != m.DeliveryStatePending {
+			t.Errorf("failed to prune the delivered deliveries, got %+v", deliveries)
+		}
+
+		// purged along with the address
+		if err := store.UnsubscribeAddress(address1, big.NewInt(1), true); err != nil {
+			t.Fatalf("failed to purge address: %v", err)
+		}
+		if deliveries, _ := store.GetDeliveries("", ""); len(deliveries) != 0 {
+			t.Errorf("failed to purge the deliveries of the address, got %+v", deliveries)
+		}
+	})
+
+	t.Run("Pause Address", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1)
+		commit(t, store, 1, &m.AddressTransaction{Address: address1, Transaction: NewTransaction("0xt1", 1),
+			BalanceChanges: map[int64]*big.Int{m.NativeCoinID: big.NewInt(-1000)}})
+		if err := store.SeedBalance(address1, m.NativeCoinID, big.NewInt(5000)); err != nil {
+			t.Fatalf("failed to seed balance: %v", err)
+		}
+
+		// paused since the first pause
+		for _, lastBlock := range []int64{1, 2} {
+			if err := store.PauseAddress(address1, big.NewInt(lastBlock)); err != nil {
+				t.Fatalf("failed to pause address: %v", err)
+			}
+		}
+
+		if store.IsSubscribedAddress(address1) {
+			t.Errorf("failed to stop recording the transactions of the paused address")
+		}
+		if subscription, err := store.GetSubscription(address1); err != nil || subscription.State != m.SubscriptionStatePaused || subscription.LastBlock.Int64() != 1 {
+			t.Errorf("failed to get the paused subscription: %+v, %v", subscription, err)
+		}
+		if transactions, err := store.GetTransactionsByAddress(address1); err != nil || len(transactions) != 1 {
+			t.Errorf("failed to keep the history of the paused address: %v, %v", transactions, err)
+		}
+		if addresses, _ := store.GetAddressesWithBalances(); addresses[address1] == nil {
+			t.Errorf("failed to list the paused address, got %v", addresses)
+		}
+
+		// resumed, the balance is to be seeded again
+		subscribe(t, store, address1)
+		if subscription, err := store.GetSubscription(address1); err != nil || subscription.State != m.SubscriptionStateActive || subscription.LastBlock != nil {
+			t.Errorf("failed to resume the subscription: %+v, %v", subscription, err)
+		}
+		checkBalance(t, store, address1, m.NativeCoinID, 4000, false)
+
+		if err := store.PauseAddress(address2, big.NewInt(1)); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+			t.Errorf("failed to reject pausing an address not subscribed: %v", err)
+		}
+	})
+
+	t.Run("Unsubscribe Address", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1, address2)
+
+		shared := NewTransaction("0xt1", 1)
+		commit(t, store, 1, &m.AddressTransaction{Address: address1, Transaction: shared},
+			&m.AddressTransaction{Address: address2, Transaction: shared})
+		commit(t, store, 2, &m.AddressTransaction{Address: address1, Transaction: NewTransaction("0xt2", 2)})
+
+		// the history is kept
+		if err := store.UnsubscribeAddress(address1, big.NewInt(2), false); err != nil {
+			t.Fatalf("failed to unsubscribe address: %v", err)
+		}
+		if store.IsSubscribedAddress(address1) {
+			t.Errorf("failed to unsubscribe address")
+		}
+		if subscription, err := store.GetSubscription(address1); err != nil || subscription.State != m.SubscriptionStateUnsubscribed || subscription.LastBlock.Int64() != 2 {
+			t.Errorf("failed to get the unsubscribed subscription: %+v, %v", subscription, err)
+		}
+		if transactions, err := store.GetTransactionsByAddress(address1); err != nil || len(transactions) != 2 {
+			t.Errorf("failed to keep the history of the unsubscribed address: %v, %v", transactions, err)
+		}
+		if addresses, _ := store.GetAddressesWithBalances(); len(addresses) != 1 || addresses[address2] == nil {
+			t.Errorf("failed to leave the unsubscribed address out, got %v", addresses)
+		}
+
+		// the history is purged, the shared transaction is kept for the other address
+		if err := store.UnsubscribeAddress(address1, big.NewInt(2), true); err != nil {
+			t.Fatalf("failed to purge address: %v", err)
+		}
+		if _, err := store.GetSubscription(address1); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+			t.Errorf("failed to purge the subscription: %v", err)
+		}
+		if _, err := store.GetTransactionsByAddress(address1); err == nil {
+			t.Errorf("failed to purge the history of the address")
+		}
+		if transactions, err := store.GetTransactionsByAddress(address2); err != nil || len(transactions) != 1 {
+			t.Errorf("failed to keep the shared transaction of the other address: %v, %v", transactions, err)
+		}
+
+		// the purged transactions are not rolled back anymore
+		if removed, err := store.RollbackBlock(big.NewInt(2), parent(2)); err != nil || len(removed) != 0 {
+			t.Errorf("failed to purge the block transactions: %v, %v", removed, err)
+		}
+		if removed, err := store.RollbackBlock(big.NewInt(1), parent(1)); err != nil || len(removed) != 1 || removed[0].Address != address2 {
+			t.Errorf("failed to keep the block transactions of the other address: %v, %v", removed, err)
+		}
+
+		// subscribed again from scratch
+		subscribe(t, store, address1)
+		if transactions, err := store.GetTransactionsByAddress(address1); err != nil || len(transactions) != 0 {
+			t.Errorf("failed to subscribe the purged address again: %v, %v", transactions, err)
+		}
+
+		if err := store.UnsubscribeAddress(address1, big.NewInt(2), true); err != nil {
+			t.Errorf("failed to purge address without transactions: %v", err)
+		}
+		if err := store.UnsubscribeAddress(address1, big.NewInt(2), false); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+			t.Errorf("failed to reject unsubscribing an address not subscribed: %v", err)
+		}
+	})
+
+	t.Run("Query Transactions", func(t *testing.T) {
+		store := open(t)
+		subscribe(t, store, address1, address2)
+
+		newTransaction := func(hash string, blockNumber int64, index int64, from string, transfers ...*m.Transfer) *m.Transaction {
+			tx := NewTransaction(hash, blockNumber)
+			tx.TransactionIndex = big.NewInt(index)
+			tx.Timestamp = big.NewInt(blockNumber * 1000)
+			tx.From = from
+			tx.Transfers = transfers
+			return tx
+		}
+		transfer := func(coinID int64, from string, to string, value int64) *m.Transfer {
+			return &m.Transfer{CoinID: coinID, From: from, To: to, Value: big.NewInt(value)}
+		}
+
+		// t4 is in another bucket of the address index of the in-memory storages, a contract call without transfer
+		t1 := newTransaction("0xt1", 1, 0, address1, transfer(m.NativeCoinID, address1, address2, 1000))
+		t2 := newTransaction("0xt2", 2, 1, address2, transfer(m.NativeCoinID, address2, address1, 50))
+		t3 := newTransaction("0xt3", 2, 0, address2, transfer(2, address2, address1, 500))
+		t4 := newTransaction("0xt4", 20001, 0, address1)
+		other := newTransaction("0xt5", 3, 0, address2)
+
+		commit(t, store, 1, &m.AddressTransaction{Address: address1, Transaction: t1})
+		commit(t, store, 2, &m.AddressTransaction{Address: address1, Transaction: t2},
+			&m.AddressTransaction{Address: address1, Transaction: t3})
+		commit(t, store, 3, &m.AddressTransaction{Address: address2, Transaction: other})
+		commit(t, store, 20001, &m.AddressTransaction{Address: address1, Transaction: t4})
+
+		check := func(query m.TransactionQuery, expected ...string) {
+			t.Helper()
+
+			query.Address = address1
+			page, err := store.QueryTransactions(&query)
+			if err != nil {
+				t.Fatalf("failed to query transactions: %v", err)
+			}
+
+			hashes := []string{}
+			for _, tx := range page.Transactions {
+				hashes = append(hashes, tx.Hash)
+			}
+			if fmt.Sprint(hashes) != fmt.Sprint(expected) || page.NextCursor != "" {
+				t.Errorf("failed to query transactions %+v, got %v next=%q", query, hashes, page.NextCursor)
+			}
+		}
+
+		// the most recent first by default, a page at a time
+		page, err := store.QueryTransactions(&m.TransactionQuery{Address: address1, Limit: 3})
+		if err != nil || len(page.Transactions) != 3 || page.Transactions[0].Hash != "0xt4" ||
+			page.Transactions[1].Hash != "0xt2" || page.Transactions[2].Hash != "0xt3" || page.NextCursor == "" {
+			t.Fatalf("failed to query the first page: %+v, %v", page, err)
+		}
+		check(m.TransactionQuery{Limit: 3, Cursor: page.NextCursor}, "0xt1")
+
+		check(m.TransactionQuery{Order: m.SortAscending}, "0xt1", "0xt3", "0xt2", "0xt4")
+		check(m.TransactionQuery{Order: m.SortAscending, FromBlock: big.NewInt(2), ToBlock: big.NewInt(2)}, "0xt3", "0xt2")
+		check(m.TransactionQuery{FromBlock: big.NewInt(20000)}, "0xt4")
+		check(m.TransactionQuery{Order: m.SortAscending, FromTime: time.Unix(2000, 0), ToTime: time.Unix(20001000, 0)}, "0xt3", "0xt2")
+
+		// a transaction sent by the address goes out without transfer
+		check(m.TransactionQuery{Order: m.SortAscending, Direction: m.DirectionIn}, "0xt3", "0xt2")
+		check(m.TransactionQuery{Order: m.SortAscending, Direction: m.DirectionOut}, "0xt1", "0xt4")
+		check(m.TransactionQuery{CoinIDs: []int64{2}}, "0xt3")
+		check(m.TransactionQuery{Order: m.SortAscending, MinValue: big.NewInt(100)}, "0xt1", "0xt3")
+		check(m.TransactionQuery{Direction: m.DirectionOut, CoinIDs: []int64{2}})
+
+		if _, err := store.UpdateBlockTransactionsState(big.NewInt(2), m.TransactionStateConfirmed); err != nil {
+			t.Fatalf("failed to update block transactions state: %v", err)
+		}
+		check(m.TransactionQuery{Order: m.SortAscending, States: []m.TransactionState{m.TransactionStateConfirmed}}, "0xt3", "0xt2")
+
+		// rolled back transactions are not found anymore
+		if _, err := store.RollbackBlock(big.NewInt(20001), parent(20001)); err != nil {
+			t.Fatalf("failed to roll back block: %v", err)
+		}
+		check(m.TransactionQuery{}, "0xt2", "0xt3", "0xt1")
+
+		// the history of an unsubscribed address is kept
+		if err := store.UnsubscribeAddress(address1, big.NewInt(20001), false); err != nil {
+			t.Fatalf("failed to unsubscribe address: %v", err)
+		}
+		check(m.TransactionQuery{Order: m.SortAscending}, "0xt1", "0xt3", "0xt2")
+
+		if _, err := store.QueryTransactions(&m.TransactionQuery{Address: address1, Cursor: "invalid"}); !errors.Is(err, m.ErrInvalidCursor) {
+			t.Errorf("failed to reject an invalid cursor: %v", err)
+		}
+		if _, err := store.QueryTransactions(&m.TransactionQuery{Address: "0xunknown"}); !errors.Is(err, storage.ErrSubscriptionNotFound) {
+			t.Errorf("failed to reject the query of an address not subscribed: %v", err)
+		}
+	})
+}
+
+// checkBalance checks the balance of the coin of the address.
+func checkBalance(t *testing.T, store storage.Storage, address string, coinID int64, expected int64, seeded bool) {
+	t.Helper()
+
+	balances, err := store.GetBalances(address)
+	if err != nil {
+		t.Fatalf("failed to get balances: %v", err)
+	}
+
+	for _, balance := range balances {
+		if balance.CoinID == coinID {
+			if balance.Balance.Int64() != expected || balance.Seeded != seeded {
+				t.Errorf("failed to get balance of coin %d of %s, got %s seeded=%t", coinID, address, balance.Balance, balance.Seeded)
+			}
+			return
+		}
+	}
+
+	t.Errorf("failed to get balance of coin %d of %s", coinID, address)
+}
+
+func subscribe(t *testing.T, store storage.Storage, addresses ...string) {
+	t.Helper()
+
+	for _, address := range addresses {
+		if err := store.SubscribeAddress(address); err != nil {
+			t.Fatalf("failed to subscribe address: %v", err)
+		}
+	}
+}
+
+func commit(t *testing.T, store storage.Storage, blockNumber int64, txs ...*m.AddressTransaction) {
+	t.Helper()
+
+	checkpoint := &m.Checkpoint{BlockNumber: big.NewInt(blockNumber), BlockHash: "0xb" + big.NewInt(blockNumber).String()}
+	if err := store.CommitBlock(checkpoint, txs); err != nil {
+		t.Fatalf("failed to commit block: %v", err)
+	}
+}
+
+// parent returns the checkpoint of the parent of the block, as committed by commit.
+func parent(blockNumber int64) *m.Checkpoint {
+	return &m.Checkpoint{BlockNumber: big.NewInt(blockNumber - 1), BlockHash: "0xb" + big.NewInt(blockNumber-1).String()}
+}
@@ -0,0 +1,691 @@
+package testdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/hoangan/superwallet/internal/eth/rpc"
+	"github.com/hoangan/superwallet/pkg/enccode/hexencoder"
+)
+
+// Node is a fake Ethereum JSON-RPC node serving an in-memory chain,
+// used to test the indexer without reaching a real node.
+type Node struct {
+	*httptest.Server
+	lock   sync.Mutex
+	blocks map[int64]*rpc.RawBlock
+	logs   map[string][]*rpc.RawLog // by block hash
+	latest int64
+
+	// requests of the blocks by tag, e.g: safe or finalized
+	tagRequests map[string]int
+
+	// debug_traceBlockByNumber is only supported once any call trace is set
+	callTraces map[int64][]*rpc.RawTransactionTrace
+
+	// receipts by transaction hash, successful receipts are made up for the other transactions
+	receipts              map[string]*rpc.RawReceipt
+	blockReceiptsDisabled bool
+
+	batchDisabled bool
+
+	// balances by address, or by token contract and address, the value by block it is set from
+	balances map[string]map[int64]*big.Int
+
+	// WebSocket connections subscribed to the new heads
+	subscriptions map[*websocket.Conn]bool
+}
+
+func NewNode() *Node {
+	node := &Node{
+		blocks:        make(map[int64]*rpc.RawBlock),
+		logs:          make(map[string][]*rpc.RawLog),
+		tagRequests:   make(map[string]int),
+		receipts:      make(map[string]*rpc.RawReceipt),
+		balances:      make(map[string]map[int64]*big.Int),
+		subscriptions: make(map[*websocket.Conn]bool),
+	}
+	node.Server = httptest.NewServer(http.HandlerFunc(node.handle))
+
+	return node
+}
+
+// SetBlock adds the block to the chain or replaces the block of the same number.
+// The latest block is moved to the block number, which drops any block above it.
+func (n *Node) SetBlock(block *rpc.RawBlock) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	number, _ := hexencoder.HexToDecimal(block.Number)
+	n.blocks[number.Int64()] = block
+	for i := number.Int64() + 1; i <= n.latest; i++ {
+		delete(n.blocks, i)
+	}
+	n.latest = number.Int64()
+
+	// notify the subscribers of the new head
+	notification := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "eth_subscription",
+		"params": map[string]interface{}{
+			"subscription": nodeSubscriptionId,
+			"result": &rpc.RawBlockHeader{
+				Hash:       block.Hash,
+				Number:     block.Number,
+				ParentHash: block.ParentHash,
+				Timestamp:  block.Timestamp,
+			},
+		},
+	}
+	for conn := range n.subscriptions {
+		if err := conn.WriteJSON(notification); err != nil {
+			_ = conn.Close()
+			delete(n.subscriptions, conn)
+		}
+	}
+}
+
+// WSURL returns the WebSocket URL of the node, serving eth_subscribe newHeads.
+func (n *Node) WSURL() string {
+	return "ws" + strings.TrimPrefix(n.URL, "http")
+}
+
+// Subscriptions returns the number of the new heads subscriptions.
+func (n *Node) Subscriptions() int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return len(n.subscriptions)
+}
+
+// CloseSubscriptions drops the WebSocket connections of the new heads subscriptions.
+func (n *Node) CloseSubscriptions() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for conn := range n.subscriptions {
+		_ = conn.Close()
+		delete(n.subscriptions, conn)
+	}
+}
+
+// SetCallTraces sets the callTracer traces of the block, which enables debug_traceBlockByNumber.
+// Blocks without traces set are traced to an empty list.
+func (n *Node) SetCallTraces(blockNumber int64, traces ...*rpc.RawTransactionTrace) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.callTraces == nil {
+		n.callTraces = make(map[int64][]*rpc.RawTransactionTrace)
+	}
+	n.callTraces[blockNumber] = traces
+}
+
+// TagRequests returns the number of requests of the block of the tag, e.g: safe or finalized.
+func (n *Node) TagRequests(tag string) int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.tagRequests[tag]
+}
+
+// SetReceipt sets the receipt of the transaction of the receipt transaction hash.
+func (n *Node) SetReceipt(receipt *rpc.RawReceipt) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.receipts[receipt.TransactionHash] = receipt
+}
+
+// DisableBlockReceipts makes eth_getBlockReceipts not supported by the node.
+func (n *Node) DisableBlockReceipts() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.blockReceiptsDisabled = true
+}
+
+// AddLogs adds the logs to the blocks of their block hash.
+func (n *Node) AddLogs(logs ...*rpc.RawLog) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, log := range logs {
+		n.logs[log.BlockHash] = append(n.logs[log.BlockHash], log)
+	}
+}
+
+// DisableBatch makes the node reject batch requests.
+func (n *Node) DisableBatch() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.batchDisabled = true
+}
+
+// SetBalance sets the balance in wei of the address from the block on.
+func (n *Node) SetBalance(address string, blockNumber int64, value *big.Int) {
+	n.setBalance(strings.ToLower(address), blockNumber, value)
+}
+
+// SetTokenBalance sets the balance of the address from the block on, returned by balanceOf of the token contract.
+func (n *Node) SetTokenBalance(contract string, address string, blockNumber int64, value *big.Int) {
+	n.setBalance(strings.ToLower(contract)+":"+strings.ToLower(address), blockNumber, value)
+}
+
+func (n *Node) setBalance(key string, blockNumber int64, value *big.Int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.balances[key] == nil {
+		n.balances[key] = make(map[int64]*big.Int)
+	}
+	n.balances[key][blockNumber] = new(big.Int).Set(value)
+}
+
+// nodeRequest is a JSON-RPC request sent to the node.
+type nodeRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Id     uint64            `json:"id"`
+}
+
+const nodeSubscriptionId = "0x9ce59a13059e417087c02d3236a0b1cc"
+
+func (n *Node) handle(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		n.subscribe(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var response interface{}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		n.lock.Lock()
+		batchDisabled := n.batchDisabled
+		n.lock.Unlock()
+		if batchDisabled {
+			http.Error(w, "batch requests are not supported", http.StatusBadRequest)
+			return
+		}
+
+		var requests []*nodeRequest
+		if err := json.Unmarshal(body, &requests); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the responses of a batch are answered in reverse order, clients must match them by id
+		responses := make([]map[string]interface{}, len(requests))
+		for j, request := range requests {
+			responses[len(requests)-1-j] = n.handleRequest(request)
+		}
+		response = responses
+	} else {
+		var request nodeRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response = n.handleRequest(&request)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// subscribe serves the new heads subscription over WebSocket.
+func (n *Node) subscribe(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	var request nodeRequest
+	if err := conn.ReadJSON(&request); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	n.lock.Lock()
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": request.Id, "result": nodeSubscriptionId}
+	if request.Method != "eth_subscribe" {
+		delete(response, "result")
+		response["error"] = map[string]interface{}{"code": -32601, "message": "only eth_subscribe is supported over WebSocket"}
+	}
+	if err := conn.WriteJSON(response); err != nil || request.Method != "eth_subscribe" {
+		n.lock.Unlock()
+		_ = conn.Close()
+		return
+	}
+	n.subscriptions[conn] = true
+	n.lock.Unlock()
+
+	// read until the connection is closed by either side
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	n.lock.Lock()
+	delete(n.subscriptions, conn)
+	n.lock.Unlock()
+	_ = conn.Close()
+}
+
+func (n *Node) handleRequest(request *nodeRequest) map[string]interface{} {
+	response := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      request.Id,
+	}
+	invalidParams := func(err error) map[string]interface{} {
+		response["error"] = map[string]interface{}{
+			"code":    -32602,
+			"message": err.Error(),
+		}
+		return response
+	}
+	if len(request.Params) == 0 {
+		request.Params = []json.RawMessage{[]byte("null")}
+	}
+
+	switch request.Method {
+	case "eth_blockNumber":
+		n.lock.Lock()
+		response["result"] = hexencoder.DecimalToHex(big.NewInt(n.latest))
+		n.lock.Unlock()
+	case "eth_getBlockByNumber":
+		var tag string
+		if err := json.Unmarshal(request.Params[0], &tag); err != nil {
+			return invalidParams(err)
+		}
+		response["result"] = n.getBlock(tag)
+	case "eth_getLogs":
+		var filter struct {
+			BlockHash string            `json:"blockHash"`
+			FromBlock string            `json:"fromBlock"`
+			ToBlock   string            `json:"toBlock"`
+			Topics    []json.RawMessage `json:"topics"`
+		}
+		if err := json.Unmarshal(request.Params[0], &filter); err != nil {
+			return invalidParams(err)
+		}
+		// each topic is null, a topic or a list of topics
+		topics := make([][]string, len(filter.Topics))
+		for j, topic := range filter.Topics {
+			var single string
+			if string(topic) == "null" {
+				continue
+			} else if json.Unmarshal(topic, &single) == nil {
+				topics[j] = []string{single}
+			} else if err := json.Unmarshal(topic, &topics[j]); err != nil {
+				return invalidParams(err)
+			}
+		}
+		if filter.BlockHash != "" {
+			response["result"] = n.getLogs(filter.BlockHash, topics)
+			break
+		}
+		fromBlock, err := hexencoder.HexToDecimal(filter.FromBlock)
+		if err != nil {
+			return invalidParams(err)
+		}
+		toBlock, err := hexencoder.HexToDecimal(filter.ToBlock)
+		if err != nil {
+			return invalidParams(err)
+		}
+		response["result"] = n.getRangeLogs(fromBlock.Int64(), toBlock.Int64(), topics)
+	case "eth_getBlockReceipts":
+		var tag string
+		if err := json.Unmarshal(request.Params[0], &tag); err != nil {
+			return invalidParams(err)
+		}
+		if receipts, ok := n.getBlockReceipts(tag); ok {
+			response["result"] = receipts
+			break
+		}
+		response["error"] = map[string]interface{}{
+			"code":    -32601,
+			"message": "the method eth_getBlockReceipts does not exist/is not available",
+		}
+	case "eth_getTransactionReceipt":
+		var txHash string
+		if err := json.Unmarshal(request.Params[0], &txHash); err != nil {
+			return invalidParams(err)
+		}
+		response["result"] = n.getTransactionReceipt(txHash)
+	case "eth_getBalance":
+		var address, tag string
+		if len(request.Params) < 2 {
+			return invalidParams(fmt.Errorf("missing block number"))
+		}
+		if err := json.Unmarshal(request.Params[0], &address); err != nil {
+			return invalidParams(err)
+		}
+		if err := json.Unmarshal(request.Params[1], &tag); err != nil {
+			return invalidParams(err)
+		}
+		response["result"] = hexencoder.DecimalToHex(n.getBalance(strings.ToLower(address), tag))
+	case "eth_getTransactionCount":
+		var address, tag string
+		if len(request.Params) < 2 {
+			return invalidParams(fmt.Errorf("missing block number"))
+		}
+		if err := json.Unmarshal(request.Params[0], &address); err != nil {
+			return invalidParams(err)
+		}
+		if err := json.Unmarshal(request.Params[1], &tag); err != nil {
+			return invalidParams(err)
+		}
+		response["result"] = hexencoder.DecimalToHex(big.NewInt(n.getTransactionCount(strings.ToLower(address), tag)))
+	case "eth_call":
+		var call struct {
+			To   string `json:"to"`
+			Data string `json:"data"`
+		}
+		var tag string
+		if len(request.Params) < 2 {
+			return invalidParams(fmt.Errorf("missing block number"))
+		}
+		if err := json.Unmarshal(request.Params[0], &call); err != nil {
+			return invalidParams(err)
+		}
+		if err := json.Unmarshal(request.Params[1], &tag); err != nil {
+			return invalidParams(err)
+		}
+		// only balanceOf(address) is supported
+		if !strings.HasPrefix(call.Data, "0x70a08231") || len(call.Data) != 74 {
+			response["error"] = map[string]interface{}{"code": 3, "message": "execution reverted"}
+			break
+		}
+		address := "0x" + call.Data[len(call.Data)-40:]
+		balance := n.getBalance(strings.ToLower(call.To)+":"+address, tag)
+		response["result"] = fmt.Sprintf("0x%064x", balance)
+	case "debug_traceBlockByNumber":
+		var tag string
+		if err := json.Unmarshal(request.Params[0], &tag); err != nil {
+			return invalidParams(err)
+		}
+		if traces, ok := n.getCallTraces(tag); ok {
+			response["result"] = traces
+			break
+		}
+		fallthrough
+	default:
+		response["error"] = map[string]interface{}{
+			"code":    -32601,
+			"message": fmt.Sprintf("the method %s does not exist/is not available", request.Method),
+		}
+	}
+
+	return response
+}
+
+func (n *Node) getBlock(tag string) *rpc.RawBlock {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if tag == "latest" {
+		return n.blocks[n.latest]
+	}
+
+	// the safe and finalized tags are not served, as a pre-merge node
+	number, err := hexencoder.HexToDecimal(tag)
+	if err != nil {
+		n.tagRequests[tag]++
+		return nil
+	}
+
+	return n.blocks[number.Int64()]
+}
+
+// getBalance returns the balance set for the highest block up to the block of the tag, zero if none.
+func (n *Node) getBalance(key string, tag string) *big.Int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	blockNumber := n.latest
+	if tag != "latest" {
+		number, err := hexencoder.HexToDecimal(tag)
+		if err != nil {
+			return big.NewInt(0)
+		}
+		blockNumber = number.Int64()
+	}
+
+	balance, setAt := big.NewInt(0), int64(-1)
+	for number, value := range n.balances[key] {
+		if number <= blockNumber && number > setAt {
+			balance, setAt = value, number
+		}
+	}
+
+	return balance
+}
+
+// getTransactionCount returns the number of transactions sent by the address up to the block of the tag.
+func (n *Node) getTransactionCount(address string, tag string) int64 {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	blockNumber := n.latest
+	if tag != "latest" {
+		number, err := hexencoder.HexToDecimal(tag)
+		if err != nil {
+			return 0
+		}
+		blockNumber = number.Int64()
+	}
+
+	count := int64(0)
+	for number, block := range n.blocks {
+		if number > blockNumber {
+			continue
+		}
+		for _, tx := range block.Transactions {
+			if strings.ToLower(tx.From) == address {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+func (n *Node) getBlockReceipts(tag string) ([]*rpc.RawReceipt, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.blockReceiptsDisabled {
+		return nil, false
+	}
+
+	number, err := hexencoder.HexToDecimal(tag)
+	if err != nil {
+		return nil, true
+	}
+
+	block, ok := n.blocks[number.Int64()]
+	if !ok {
+		return nil, true
+	}
+
+	receipts := []*rpc.RawReceipt{}
+	for _, tx := range block.Transactions {
+		receipts = append(receipts, n.receipt(block, tx))
+	}
+
+	return receipts, true
+}
+
+func (n *Node) getTransactionReceipt(txHash string) *rpc.RawReceipt {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	for _, block := range n.blocks {
+		for _, tx := range block.Transactions {
+			if tx.Hash == txHash {
+				return n.receipt(block, tx)
+			}
+		}
+	}
+
+	return nil
+}
+
+// receipt returns the receipt set for the transaction,
+// or makes up a successful receipt with the logs of the transaction.
+func (n *Node) receipt(block *rpc.RawBlock, tx *rpc.RawTransaction) *rpc.RawReceipt {
+	if receipt, ok := n.receipts[tx.Hash]; ok {
+		receipt.BlockHash = block.Hash
+		receipt.BlockNumber = block.Number
+		return receipt
+	}
+
+	logs := []*rpc.RawLog{}
+	for _, log := range n.logs[block.Hash] {
+		if log.TransactionHash == tx.Hash {
+			logs = append(logs, log)
+		}
+	}
+
+	return &rpc.RawReceipt{
+		Type:              tx.Type,
+		TransactionHash:   tx.Hash,
+		BlockHash:         block.Hash,
+		BlockNumber:       block.Number,
+		From:              tx.From,
+		To:                tx.To,
+		Status:            "0x1",
+		GasUsed:           tx.Gas,
+		CumulativeGasUsed: tx.Gas,
+		EffectiveGasPrice: tx.GasPrice,
+		Logs:              logs,
+	}
+}
+
+func (n *Node) getCallTraces(tag string) ([]*rpc.RawTransactionTrace, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.callTraces == nil {
+		return nil, false
+	}
+
+	number, err := hexencoder.HexToDecimal(tag)
+	if err != nil {
+		return nil, false
+	}
+
+	traces := n.callTraces[number.Int64()]
+	if traces == nil {
+		traces = []*rpc.RawTransactionTrace{}
+	}
+
+	return traces, true
+}
+
+func (n *Node) getLogs(blockHash string, topics [][]string) []*rpc.RawLog {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	logs := []*rpc.RawLog{}
+	for _, log := range n.logs[blockHash] {
+		if matchTopics(log, topics) {
+			logs = append(logs, log)
+		}
+	}
+
+	return logs
+}
+
+// getRangeLogs returns the logs of the blocks of the chain from the block to the block, by the block number of the logs.
+func (n *Node) getRangeLogs(fromBlock int64, toBlock int64, topics [][]string) []*rpc.RawLog {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	logs := []*rpc.RawLog{}
+	for number := fromBlock; number <= toBlock && number <= n.latest; number++ {
+		block, ok := n.blocks[number]
+		if !ok {
+			continue
+		}
+		for _, log := range n.logs[block.Hash] {
+			if matchTopics(log, topics) {
+				logs = append(logs, log)
+			}
+		}
+	}
+
+	return logs
+}
+
+// matchTopics reports whether the topics of the log match the filter by position, an empty position matching any topic.
+func matchTopics(log *rpc.RawLog, topics [][]string) bool {
+	for j, candidates := range topics {
+		if len(candidates) == 0 {
+			continue
+		}
+		if j >= len(log.Topics) {
+			return false
+		}
+		matched := false
+		for _, topic := range candidates {
+			matched = matched || strings.EqualFold(topic, log.Topics[j])
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewRawBlock creates a block on top of the parent hash with the given transactions.
+// The block number, hash and the position in the block are set to the transactions.
+func NewRawBlock(number int64, hash string, parentHash string, txs ...*rpc.RawTransaction) *rpc.RawBlock {
+	numberHex := hexencoder.DecimalToHex(big.NewInt(number))
+	for j, tx := range txs {
+		tx.BlockNumber = numberHex
+		tx.BlockHash = hash
+		tx.TransactionIndex = hexencoder.DecimalToHex(big.NewInt(int64(j)))
+	}
+
+	return &rpc.RawBlock{
+		Hash:         hash,
+		Number:       numberHex,
+		ParentHash:   parentHash,
+		Timestamp:    "0x0",
+		Transactions: txs,
+	}
+}
+
+// NewRawTransaction creates a legacy ETH transfer transaction.
+func NewRawTransaction(hash string, from string, to string, value int64) *rpc.RawTransaction {
+	return &rpc.RawTransaction{
+		Hash:     hash,
+		Type:     "0x0",
+		From:     from,
+		To:       to,
+		Value:    hexencoder.DecimalToHex(big.NewInt(value)),
+		Nonce:    "0x0",
+		Gas:      "0x5208",
+		GasPrice: "0x3b9aca00",
+		ChainId:  "0x1",
+	}
+}
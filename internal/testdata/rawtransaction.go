@@ -17,5 +17,51 @@ var (
 		From:        "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97",
 		To:          "0x29182006a4967e9a50c0a66076da514993d3b4d4",
 		Value:       "0xa588ee0d2314c0",
+
+		MaxFeePerGas:         "0x3b9aca00",
+		MaxPriorityFeePerGas: "0x0",
+	}
+
+	// blob transaction of a rollup batch
+	RawBlobTransaction1 = &rpc.RawTransaction{
+		Hash:                 "0x4e5f0a8c4e9b7b8e0c3c9ef44d7a4b8d1f0b6b4c2a5d3e1f9a8b7c6d5e4f3a2b",
+		Type:                 "0x3",
+		BlockHash:            "0xf20326ecb02332687c918de6df6c8b354ccdf8406ea1b276a4da07e22b072715",
+		BlockNumber:          "0x1359a3b",
+		ChainId:              "0x1",
+		Nonce:                "0x1a2b",
+		Gas:                  "0x5208",
+		MaxFeePerGas:         "0x2540be400",
+		MaxPriorityFeePerGas: "0x3b9aca00",
+		MaxFeePerBlobGas:     "0x3b9aca00",
+		From:                 "0xc1b634853cb333d3ad8663715b08f41a3aec47cc",
+		To:                   "0x1c479675ad559dc151f6ec7ed3fbf8cee79582b6",
+		Value:                "0x0",
+		AccessList: []*rpc.RawAccessTuple{
+			{
+				Address:     "0x1c479675ad559dc151f6ec7ed3fbf8cee79582b6",
+				StorageKeys: []string{"0x0000000000000000000000000000000000000000000000000000000000000000"},
+			},
+		},
+		BlobVersionedHashes: []string{
+			"0x01b3c2f9e3e4d7f1a7ad0b1d0c6b2e1a3c2d4f5e6a7b8c9d0e1f2a3b4c5d6e7f",
+			"0x01f0e1d2c3b4a5968778695a4b3c2d1e0f1a2b3c4d5e6f708192a3b4c5d6e7f8",
+		},
+	}
+
+	// USDT transfer of 500 USDT
+	RawTransferLog1 = &rpc.RawLog{
+		Address: "0xdAC17F958D2ee523a2206206994597C13D831ec7",
+		Topics: []string{
+			"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+			"0x00000000000000000000000028c6c06298d514db089934071355e5743bf21d60",
+			"0x00000000000000000000000029182006a4967e9a50c0a66076da514993d3b4d4",
+		},
+		Data:             "0x000000000000000000000000000000000000000000000000000000001dcd6500",
+		BlockNumber:      "0x1359a3b",
+		BlockHash:        "0xf20326ecb02332687c918de6df6c8b354ccdf8406ea1b276a4da07e22b072715",
+		TransactionHash:  "0x1d1ee5c8e5fbc70ad1c3ab7bf1bc8fd8cd0e1e8ef0b7ef0b1ad3cf0ff7db0a41",
+		TransactionIndex: "0x5",
+		LogIndex:         "0x1c",
 	}
 )
@@ -19,6 +19,10 @@ var (
 		From:        "0x4838b106fce9647bdf1e7877bf73ce8b0bad5f97",
 		To:          "0x29182006a4967e9a50c0a66076da514993d3b4d4",
 		Value:       big.NewInt(46593927161255104),
+
+		MaxFeePerGas:         big.NewInt(1000000000),
+		MaxPriorityFeePerGas: big.NewInt(0),
+
 		Transfers: []*m.Transfer{
 			{
 				CoinID: 1,
@@ -29,4 +33,14 @@ var (
 			},
 		},
 	}
+
+	TransferLog1Transfer = &m.Transfer{
+		CoinID:   2,
+		Ticker:   "USDT",
+		From:     "0x28c6c06298d514db089934071355e5743bf21d60",
+		To:       "0x29182006a4967e9a50c0a66076da514993d3b4d4",
+		Value:    big.NewInt(500000000),
+		Token:    "0xdac17f958d2ee523a2206206994597c13d831ec7",
+		LogIndex: big.NewInt(28),
+	}
 )
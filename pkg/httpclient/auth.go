@@ -0,0 +1,153 @@
+package httpclient
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwtRefreshInterval is how long a JWT is used before a new one is issued,
+// the Engine API rejects the tokens issued more than 60 seconds ago.
+const jwtRefreshInterval = 30 * time.Second
+
+// Auth are the credentials sent with every request. At most one of basic auth, bearer token and JWT is used,
+// they all set the Authorization header.
+type Auth struct {
+	// Headers are sent as is, e.g: an API key header of the provider
+	Headers map[string]string
+
+	Username string
+	Password string
+
+	BearerToken string
+
+	// JWTSecret is the shared secret of the HS256 JWT issued for the requests, e.g: the jwt.hex of the Engine API
+	JWTSecret []byte
+}
+
+// AuthFromEnv loads the credentials from the environment variables of the prefix:
+//
+//	<PREFIX>_HEADERS          headers as "Name: value" separated by ";"
+//	<PREFIX>_BASIC_AUTH       "username:password"
+//	<PREFIX>_BEARER_TOKEN     bearer token
+//	<PREFIX>_JWT_SECRET       hex encoded JWT secret
+//	<PREFIX>_JWT_SECRET_FILE  file of the hex encoded JWT secret
+func AuthFromEnv(prefix string) (Auth, error) {
+	var auth Auth
+
+	if headers := os.Getenv(prefix + "_HEADERS"); headers != "" {
+		auth.Headers = make(map[string]string)
+		for _, header := range strings.Split(headers, ";") {
+			if strings.TrimSpace(header) == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(header, ":")
+			if !ok {
+				return Auth{}, fmt.Errorf("invalid header in %s_HEADERS, expected \"Name: value\"", prefix)
+			}
+			auth.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	if basicAuth := os.Getenv(prefix + "_BASIC_AUTH"); basicAuth != "" {
+		var ok bool
+		if auth.Username, auth.Password, ok = strings.Cut(basicAuth, ":"); !ok {
+			return Auth{}, fmt.Errorf("invalid %s_BASIC_AUTH, expected \"username:password\"", prefix)
+		}
+	}
+
+	auth.BearerToken = os.Getenv(prefix + "_BEARER_TOKEN")
+
+	secret := os.Getenv(prefix + "_JWT_SECRET")
+	if path := os.Getenv(prefix + "_JWT_SECRET_FILE"); path != "" && secret == "" {
+		secretBytes, err := os.ReadFile(path)
+		if err != nil {
+			return Auth{}, fmt.Errorf("failed to read %s_JWT_SECRET_FILE: %w", prefix, err)
+		}
+		secret = string(secretBytes)
+	}
+	if secret != "" {
+		var err error
+		if auth.JWTSecret, err = hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(secret), "0x")); err != nil {
+			return Auth{}, fmt.Errorf("failed to decode JWT secret of %s: %w", prefix, err)
+		}
+	}
+
+	return auth, nil
+}
+
+// WithAuth sets the credentials sent with every request.
+func WithAuth(auth Auth) Option {
+	return func(c *Client) {
+		c.auth = &authenticator{auth: auth}
+	}
+}
+
+// authenticator sets the credentials to the requests, issuing the JWT again once it is too old.
+type authenticator struct {
+	auth     Auth
+	lock     sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+func (a *authenticator) apply(req *http.Request) error {
+	for name, value := range a.auth.Headers {
+		req.Header.Set(name, value)
+	}
+
+	switch {
+	case len(a.auth.JWTSecret) > 0:
+		token, err := a.jwt()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case a.auth.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+a.auth.BearerToken)
+	case a.auth.Username != "" || a.auth.Password != "":
+		req.SetBasicAuth(a.auth.Username, a.auth.Password)
+	}
+
+	return nil
+}
+
+// jwt returns the HS256 JWT with the issued at claim, issued again every jwtRefreshInterval.
+func (a *authenticator) jwt() (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.token != "" && time.Since(a.issuedAt) < jwtRefreshInterval {
+		return a.token, nil
+	}
+
+	if len(a.auth.JWTSecret) == 0 {
+		return "", errors.New("missing JWT secret")
+	}
+
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{"iat": now.Unix()})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JWT claims: %w", err)
+	}
+
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + encoding.EncodeToString(claims)
+
+	mac := hmac.New(sha256.New, a.auth.JWTSecret)
+	mac.Write([]byte(unsigned))
+
+	a.token = unsigned + "." + encoding.EncodeToString(mac.Sum(nil))
+	a.issuedAt = now
+
+	return a.token, nil
+}
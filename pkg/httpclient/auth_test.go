@@ -0,0 +1,90 @@
+package httpclient_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hoangan/superwallet/pkg/httpclient"
+)
+
+// newAuthServer records the headers of the last request.
+func newAuthServer(t *testing.T) (*httptest.Server, *http.Header) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &header
+}
+
+func TestAuth(t *testing.T) {
+	t.Run("Headers And Basic Auth From Env", func(t *testing.T) {
+		t.Setenv("NODE_HEADERS", "X-Api-Key: key; X-Team: indexer")
+		t.Setenv("NODE_BASIC_AUTH", "user:pass")
+
+		auth, err := httpclient.AuthFromEnv("NODE")
+		if err != nil {
+			t.Fatalf("failed to load credentials: %v", err)
+		}
+
+		server, header := newAuthServer(t)
+		client := httpclient.NewHttpClient(server.URL, httpclient.WithAuth(auth))
+		if _, err := client.Post(context.Background(), []byte(`{}`)); err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		if header.Get("X-Api-Key") != "key" || header.Get("X-Team") != "indexer" {
+			t.Errorf("failed to send the headers, got %v", *header)
+		}
+		req := &http.Request{Header: *header}
+		if username, password, ok := req.BasicAuth(); !ok || username != "user" || password != "pass" {
+			t.Errorf("failed to send basic auth, got %s", header.Get("Authorization"))
+		}
+	})
+
+	t.Run("JWT", func(t *testing.T) {
+		secret := "a3f1c2d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+		t.Setenv("ENGINE_JWT_SECRET", "0x"+secret)
+
+		auth, err := httpclient.AuthFromEnv("ENGINE")
+		if err != nil {
+			t.Fatalf("failed to load credentials: %v", err)
+		}
+
+		server, header := newAuthServer(t)
+		client := httpclient.NewHttpClient(server.URL, httpclient.WithAuth(auth))
+		if _, err := client.Post(context.Background(), []byte(`{}`)); err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		token := strings.TrimPrefix(header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			t.Fatalf("failed to send JWT, got %s", token)
+		}
+
+		mac := hmac.New(sha256.New, auth.JWTSecret)
+		mac.Write([]byte(parts[0] + "." + parts[1]))
+		if base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) != parts[2] {
+			t.Errorf("failed to sign JWT with the secret")
+		}
+
+		claimsBytes, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims struct {
+			Iat int64 `json:"iat"`
+		}
+		if err := json.Unmarshal(claimsBytes, &claims); err != nil || time.Since(time.Unix(claims.Iat, 0)) > time.Minute {
+			t.Errorf("failed to set the issued at claim, got %s", claimsBytes)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package httpclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without sending the request while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitState is the state of the circuit breaker of a client.
+type CircuitState int
+
+const (
+	// CircuitClosed lets the requests through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects the requests after repeated failures.
+	CircuitOpen
+	// CircuitHalfOpen lets a single request through to probe the recovery of the server.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// StateHook is called on every change of the circuit state, e.g: to log it or export it as a metric.
+// It is called while the state is locked, it must return quickly and not use the client.
+type StateHook func(url string, from CircuitState, to CircuitState)
+
+// CircuitBreakerPolicy tells when the circuit opens, after FailureThreshold consecutive failed requests,
+// and how long it stays open before half-opening.
+type CircuitBreakerPolicy struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+var DefaultCircuitBreakerPolicy = CircuitBreakerPolicy{
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+}
+
+type circuitBreaker struct {
+	lock     sync.Mutex
+	policy   CircuitBreakerPolicy
+	url      string
+	hook     StateHook
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool // a request is probing the recovery while half-open
+}
+
+// allow reports whether the request can be sent, half-opening the circuit once the open timeout is over.
+func (b *circuitBreaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.policy.OpenTimeout {
+			return false
+		}
+		b.setState(CircuitHalfOpen)
+		b.probing = true
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+
+	return true
+}
+
+// record updates the circuit with the outcome of the request.
+func (b *circuitBreaker) record(failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		b.setState(CircuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.policy.FailureThreshold {
+		b.openedAt = time.Now()
+		b.setState(CircuitOpen)
+	}
+}
+
+// abort releases the request allowed without any outcome, e.g: cancelled.
+func (b *circuitBreaker) abort() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.probing = false
+}
+
+func (b *circuitBreaker) getState() CircuitState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
+
+func (b *circuitBreaker) setState(state CircuitState) {
+	if b.state == state {
+		return
+	}
+
+	from := b.state
+	b.state = state
+	if b.hook != nil {
+		b.hook(b.url, from, state)
+	}
+}
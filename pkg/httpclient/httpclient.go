@@ -2,24 +2,76 @@ package httpclient
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 )
 
+// StatusError is returned when the server answers with a non 2xx status code.
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+
+	// RetryAfter is how long the server asks to wait before retrying, from the Retry-After header.
+	// Zero when the server does not tell.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, e.Body)
+}
+
 type Client struct {
-	client *http.Client
-	url    string
+	client  *http.Client
+	url     string
+	retry   RetryPolicy
+	breaker *circuitBreaker
+	limiter *limiter
+	auth    *authenticator // nil without credentials
+}
+
+// Option customizes the Client created by NewHttpClient.
+type Option func(*Client)
+
+// WithRetryPolicy sets how the failed requests are retried, DefaultRetryPolicy by default.
+// A zero MaxRetries disables the retries.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithCircuitBreaker sets when the circuit breaker opens, DefaultCircuitBreakerPolicy by default.
+func WithCircuitBreaker(policy CircuitBreakerPolicy) Option {
+	return func(c *Client) {
+		c.breaker.policy = policy
+	}
+}
+
+// WithStateHook sets the hook called on every change of the circuit breaker state.
+func WithStateHook(hook StateHook) Option {
+	return func(c *Client) {
+		c.breaker.hook = hook
+	}
+}
+
+// WithRateLimit sets the rate limit and the daily budget of the requests, no limit by default.
+func WithRateLimit(limit RateLimit) Option {
+	return func(c *Client) {
+		c.limiter = newLimiter(limit)
+	}
 }
 
 // NewHttpClient creates a new HttpClient with a given url
 // Customize the http client connection parameters,
 // in order to prevent resource leaks and improve performance
 // use a timeout of 10 seconds since public nodes are slow
-func NewHttpClient(url string) *Client {
-	return &Client{
+func NewHttpClient(url string, opts ...Option) *Client {
+	c := &Client{
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 			Transport: &http.Transport{
@@ -30,12 +82,86 @@ func NewHttpClient(url string) *Client {
 				ResponseHeaderTimeout: 5 * time.Second,
 			},
 		},
-		url: url,
+		url:   url,
+		retry: DefaultRetryPolicy,
+		breaker: &circuitBreaker{
+			policy: DefaultCircuitBreakerPolicy,
+			url:    url,
+		},
+		limiter: newLimiter(RateLimit{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// CircuitState returns the state of the circuit breaker.
+func (c *Client) CircuitState() CircuitState {
+	return c.breaker.getState()
+}
+
+// Usage returns the requests sent and the credits spent.
+func (c *Client) Usage() Usage {
+	return c.limiter.getUsage()
+}
+
+// Post sends the body to the url, retrying by the retry policy while the circuit breaker is not open.
+// Every attempt waits for the rate limit. The request is cancelled as soon as the context is done.
+func (c *Client) Post(ctx context.Context, body []byte) ([]byte, error) {
+	cost := requestCost(ctx)
+	for attempt := 0; ; attempt++ {
+		if !c.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		if err := c.limiter.wait(ctx, cost); err != nil {
+			c.breaker.abort()
+			return nil, err
+		}
+
+		respBody, err := c.post(ctx, body)
+		if ctx.Err() != nil {
+			// cancelled by the caller, nothing is learned about the server
+			c.breaker.abort()
+			return nil, ctx.Err()
+		}
+
+		c.breaker.record(err != nil && isServerFailure(err))
+		if err == nil {
+			return respBody, nil
+		}
+
+		delay, ok := c.retry.retryDelay(attempt, err)
+		if !ok {
+			return nil, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
 	}
 }
 
-func (c *Client) Post(body []byte) ([]byte, error) {
-	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(body))
+func (c *Client) post(ctx context.Context, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.auth != nil {
+		if err := c.auth.apply(req); err != nil {
+			return nil, fmt.Errorf("failed to authenticate request: %w", err)
+		}
+	}
+
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -53,5 +179,32 @@ func (c *Client) Post(body []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       respBody,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
 	return respBody, nil
 }
+
+// parseRetryAfter parses the Retry-After header, either a number of seconds or an HTTP date.
+func parseRetryAfter(retryAfter string) time.Duration {
+	if retryAfter == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,207 @@
+package httpclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hoangan/superwallet/pkg/httpclient"
+)
+
+// newServer answers with the status codes in order, then with 200.
+func newServer(t *testing.T, statusCodes ...int) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1)) - 1
+		if n < len(statusCodes) {
+			if statusCodes[n] == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			http.Error(w, http.StatusText(statusCodes[n]), statusCodes[n])
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestPost(t *testing.T) {
+	policy := httpclient.RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	t.Run("Retry Server Errors", func(t *testing.T) {
+		server, requests := newServer(t, http.StatusBadGateway, http.StatusServiceUnavailable)
+		client := httpclient.NewHttpClient(server.URL, httpclient.WithRetryPolicy(policy))
+
+		if _, err := client.Post(context.Background(), []byte(`{}`)); err != nil {
+			t.Errorf("failed to retry server errors: %v", err)
+		}
+		if requests.Load() != 3 {
+			t.Errorf("failed to retry server errors, expected 3 requests, got %d", requests.Load())
+		}
+	})
+
+	t.Run("No Retry Of Client Errors", func(t *testing.T) {
+		server, requests := newServer(t, http.StatusBadRequest)
+		client := httpclient.NewHttpClient(server.URL, httpclient.WithRetryPolicy(policy))
+
+		_, err := client.Post(context.Background(), []byte(`{}`))
+		var statusErr *httpclient.StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+			t.Errorf("failed to return the client error, got %v", err)
+		}
+		if requests.Load() != 1 {
+			t.Errorf("failed to give up on client error, expected 1 request, got %d", requests.Load())
+		}
+	})
+
+	t.Run("Retry After Too Long", func(t *testing.T) {
+		// Retry-After of 1s is longer than the max delay of the policy, left to the caller
+		server, requests := newServer(t, http.StatusTooManyRequests)
+		client := httpclient.NewHttpClient(server.URL, httpclient.WithRetryPolicy(policy))
+
+		_, err := client.Post(context.Background(), []byte(`{}`))
+		var statusErr *httpclient.StatusError
+		if !errors.As(err, &statusErr) || statusErr.RetryAfter != time.Second {
+			t.Errorf("failed to return the rate limiting with Retry-After, got %v", err)
+		}
+		if requests.Load() != 1 {
+			t.Errorf("failed to give up on long Retry-After, expected 1 request, got %d", requests.Load())
+		}
+	})
+
+	t.Run("Circuit Breaker", func(t *testing.T) {
+		server, requests := newServer(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+
+		var lock sync.Mutex
+		var transitions []string
+		client := httpclient.NewHttpClient(server.URL,
+			httpclient.WithRetryPolicy(httpclient.RetryPolicy{}),
+			httpclient.WithCircuitBreaker(httpclient.CircuitBreakerPolicy{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond}),
+			httpclient.WithStateHook(func(url string, from httpclient.CircuitState, to httpclient.CircuitState) {
+				lock.Lock()
+				defer lock.Unlock()
+				transitions = append(transitions, from.String()+"->"+to.String())
+			}),
+		)
+
+		for j := 0; j < 2; j++ {
+			_, _ = client.Post(context.Background(), []byte(`{}`))
+		}
+		if client.CircuitState() != httpclient.CircuitOpen {
+			t.Fatalf("failed to open the circuit after repeated failures, got %s", client.CircuitState())
+		}
+
+		if _, err := client.Post(context.Background(), []byte(`{}`)); !errors.Is(err, httpclient.ErrCircuitOpen) {
+			t.Errorf("failed to reject the request while open, got %v", err)
+		}
+		if requests.Load() != 2 {
+			t.Errorf("failed to reject the request while open, expected 2 requests, got %d", requests.Load())
+		}
+
+		// the probe fails, the circuit opens again
+		time.Sleep(60 * time.Millisecond)
+		_, _ = client.Post(context.Background(), []byte(`{}`))
+		if client.CircuitState() != httpclient.CircuitOpen {
+			t.Errorf("failed to open the circuit again after failed probe, got %s", client.CircuitState())
+		}
+
+		// the probe succeeds, the circuit closes
+		time.Sleep(60 * time.Millisecond)
+		if _, err := client.Post(context.Background(), []byte(`{}`)); err != nil {
+			t.Errorf("failed to probe the recovery: %v", err)
+		}
+		if client.CircuitState() != httpclient.CircuitClosed {
+			t.Errorf("failed to close the circuit after recovery, got %s", client.CircuitState())
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		expected := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+		if len(transitions) != len(expected) {
+			t.Fatalf("failed to call the state hook, expected %v, got %v", expected, transitions)
+		}
+		for j := range expected {
+			if transitions[j] != expected[j] {
+				t.Errorf("failed to call the state hook, expected %v, got %v", expected, transitions)
+				break
+			}
+		}
+	})
+
+	t.Run("Rate Limit", func(t *testing.T) {
+		server, _ := newServer(t)
+		client := httpclient.NewHttpClient(server.URL, httpclient.WithRateLimit(httpclient.RateLimit{
+			RequestsPerSecond: 100,
+			Burst:             5,
+			DailyBudget:       20,
+		}))
+
+		// the burst is sent right away, the next 10 credits are refilled in 100ms
+		start := time.Now()
+		ctx := httpclient.WithRequestCost(context.Background(), 5)
+		for j := 0; j < 3; j++ {
+			if _, err := client.Post(ctx, []byte(`{}`)); err != nil {
+				t.Fatalf("failed to send request: %v", err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+			t.Errorf("failed to throttle the requests, took %v", elapsed)
+		}
+
+		if _, err := client.Post(ctx, []byte(`{}`)); err != nil {
+			t.Fatalf("failed to send request within budget: %v", err)
+		}
+		if _, err := client.Post(context.Background(), []byte(`{}`)); !errors.Is(err, httpclient.ErrBudgetExceeded) {
+			t.Errorf("failed to reject the request over budget, got %v", err)
+		}
+
+		usage := client.Usage()
+		expected := httpclient.Usage{Requests: 4, Credits: 20, CreditsToday: 20, Throttled: 3, Rejected: 1}
+		if usage != expected {
+			t.Errorf("failed to count usage, expected %+v, got %+v", expected, usage)
+		}
+	})
+
+	t.Run("Rate Limit Cancel", func(t *testing.T) {
+		server, _ := newServer(t)
+		client := httpclient.NewHttpClient(server.URL, httpclient.WithRateLimit(httpclient.RateLimit{
+			RequestsPerSecond: 10,
+			Burst:             5,
+			DailyBudget:       10,
+		}))
+
+		ctx := httpclient.WithRequestCost(context.Background(), 5)
+		if _, err := client.Post(ctx, []byte(`{}`)); err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		// cancelled while waiting 500ms for its credits, the request is not sent
+		cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, err := client.Post(cancelled, []byte(`{}`)); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("failed to cancel the throttled request, got %v", err)
+		}
+
+		usage := client.Usage()
+		expected := httpclient.Usage{Requests: 1, Credits: 5, CreditsToday: 5, Throttled: 1}
+		if usage != expected {
+			t.Errorf("failed to refund the credits of the cancelled request, expected %+v, got %+v", expected, usage)
+		}
+
+		// the credits are back in the bucket and the budget
+		start := time.Now()
+		if _, err := client.Post(ctx, []byte(`{}`)); err != nil {
+			t.Fatalf("failed to send request within budget: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+			t.Errorf("failed to give the credits back to the bucket, took %v", elapsed)
+		}
+	})
+}
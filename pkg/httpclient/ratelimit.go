@@ -0,0 +1,149 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded is returned without sending the request once the daily budget of credits is spent.
+var ErrBudgetExceeded = errors.New("daily request budget exceeded")
+
+// RateLimit bounds the requests sent to the server, to stay within the plan of the provider.
+// Every request costs credits, 1 unless set with WithRequestCost. The credits are refilled at RequestsPerSecond,
+// up to Burst, the requests wait for their credits. DailyBudget bounds the credits spent per UTC day.
+// Zero values disable the respective limit.
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+	DailyBudget       uint64
+}
+
+// Usage counts the requests sent to the server and the credits spent.
+type Usage struct {
+	Requests     uint64
+	Credits      uint64
+	CreditsToday uint64 // credits spent since the last UTC midnight
+	Throttled    uint64 // requests delayed by the rate limit
+	Rejected     uint64 // requests rejected by the daily budget
+}
+
+type costKey struct{}
+
+// WithRequestCost sets the cost in credits of the requests sent with the context,
+// e.g: tracing a block costs more than fetching it.
+func WithRequestCost(ctx context.Context, cost int) context.Context {
+	return context.WithValue(ctx, costKey{}, cost)
+}
+
+func requestCost(ctx context.Context) int {
+	if cost, ok := ctx.Value(costKey{}).(int); ok && cost > 0 {
+		return cost
+	}
+	return 1
+}
+
+// limiter is a token bucket bounding the credits per second, and a counter of the credits spent per day.
+type limiter struct {
+	lock     sync.Mutex
+	limit    RateLimit
+	tokens   float64
+	refilled time.Time
+	day      time.Time
+	usage    Usage
+}
+
+func newLimiter(limit RateLimit) *limiter {
+	return &limiter{
+		limit:    limit,
+		tokens:   float64(limit.Burst),
+		refilled: time.Now(),
+	}
+}
+
+// wait takes the credits of the request from the bucket, waiting for them to be refilled when needed.
+// The credits are given back when the context is done while waiting, the request is not sent.
+func (l *limiter) wait(ctx context.Context, cost int) error {
+	delay, day, err := l.reserve(cost)
+	if err != nil || delay <= 0 {
+		return err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		l.refund(cost, day)
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes the credits, the bucket can go in debt so the requests are served in order,
+// and returns how long to wait for the debt to be refilled, along with the day the credits are spent.
+func (l *limiter) reserve(cost int) (time.Duration, time.Time, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	if today := now.UTC().Truncate(24 * time.Hour); !today.Equal(l.day) {
+		l.day = today
+		l.usage.CreditsToday = 0
+	}
+
+	if l.limit.DailyBudget > 0 && l.usage.CreditsToday+uint64(cost) > l.limit.DailyBudget {
+		l.usage.Rejected++
+		return 0, l.day, ErrBudgetExceeded
+	}
+
+	l.usage.Requests++
+	l.usage.Credits += uint64(cost)
+	l.usage.CreditsToday += uint64(cost)
+
+	if l.limit.RequestsPerSecond <= 0 {
+		return 0, l.day, nil
+	}
+
+	burst := float64(max(l.limit.Burst, 1))
+	l.tokens = min(burst, l.tokens+now.Sub(l.refilled).Seconds()*l.limit.RequestsPerSecond)
+	l.refilled = now
+
+	// a request costing more than the burst would never be served
+	l.tokens -= min(float64(cost), burst)
+	if l.tokens >= 0 {
+		return 0, l.day, nil
+	}
+
+	l.usage.Throttled++
+	return time.Duration(-l.tokens / l.limit.RequestsPerSecond * float64(time.Second)), l.day, nil
+}
+
+// refund gives back the credits reserved on the day for a request not sent, to the bucket and the budget.
+// The request is still counted as throttled.
+func (l *limiter) refund(cost int, day time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.usage.Requests--
+	l.usage.Credits -= uint64(cost)
+	if day.Equal(l.day) {
+		l.usage.CreditsToday -= uint64(cost)
+	}
+
+	burst := float64(max(l.limit.Burst, 1))
+	l.tokens = min(burst, l.tokens+min(float64(cost), burst))
+}
+
+func (l *limiter) getUsage() Usage {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	usage := l.usage
+	if !time.Now().UTC().Truncate(24 * time.Hour).Equal(l.day) {
+		usage.CreditsToday = 0
+	}
+	return usage
+}
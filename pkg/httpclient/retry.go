@@ -0,0 +1,83 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy tells how the failed requests are retried. The delay between the attempts grows exponentially
+// from BaseDelay up to MaxDelay, with a random jitter so the clients do not retry all at once.
+// A 429 response is retried after its Retry-After delay, unless it is longer than MaxDelay.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// DefaultRetryPolicy retries a couple of times quickly, longer outages are left to the caller,
+// e.g: failing over to another node.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 2,
+	BaseDelay:  200 * time.Millisecond,
+	MaxDelay:   5 * time.Second,
+}
+
+// Backoff returns the delay before the retry following the given number of failed attempts,
+// drawn at random up to the exponential delay, "full jitter".
+func Backoff(attempt int, baseDelay time.Duration, maxDelay time.Duration) time.Duration {
+	delay := maxDelay
+	if attempt < 32 {
+		if exponential := baseDelay << attempt; exponential > 0 && exponential < maxDelay {
+			delay = exponential
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// retryDelay returns how long to wait before retrying the failed attempt, false when it is not to be retried.
+func (p RetryPolicy) retryDelay(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.MaxRetries || !isRetryable(err) {
+		return 0, false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter, statusErr.RetryAfter <= p.MaxDelay
+	}
+
+	return Backoff(attempt, p.BaseDelay, p.MaxDelay), true
+}
+
+// isRetryable reports whether the request could succeed when sent again: the connection failures,
+// rate limiting and the errors of the gateways in front of the server.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	return true
+}
+
+// isServerFailure reports whether the request failed because of the server rather than the request itself,
+// counted by the circuit breaker.
+func isServerFailure(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	return true
+}